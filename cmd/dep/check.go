@@ -149,7 +149,7 @@ func (cmd *checkCommand) Run(ctx *dep.Ctx, args []string) error {
 			ordered = append(ordered, path)
 
 			switch status {
-			case verify.DigestMismatchInLock, verify.HashVersionMismatch, verify.EmptyDigestInLock, verify.NotInLock:
+			case verify.DigestMismatchInLock, verify.HashVersionMismatch, verify.EmptyDigestInLock, verify.SymlinkInTree, verify.EmptyTreeInLock, verify.NotInLock:
 				if noverify[path] {
 					hasnoverify = true
 					continue
@@ -201,6 +201,10 @@ func (cmd *checkCommand) Run(ctx *dep.Ctx, args []string) error {
 				fmt.Fprintf(bufptr, "%s: hash of vendored tree not equal to digest in Gopkg.lock\n", pr)
 			case verify.EmptyDigestInLock:
 				fmt.Fprintf(bufptr, "%s: no digest in Gopkg.lock to compare against hash of vendored tree\n", pr)
+			case verify.SymlinkInTree:
+				fmt.Fprintf(bufptr, "%s: vendored tree is a symlink, which cannot be verified\n", pr)
+			case verify.EmptyTreeInLock:
+				fmt.Fprintf(bufptr, "%s: vendored tree is empty\n", pr)
 			case verify.HashVersionMismatch:
 				// This will double-print if the hash version is zero, but
 				// that's a rare case that really only occurs before the first
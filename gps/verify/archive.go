@@ -0,0 +1,284 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// archiveNode is a file system node of a tree read from an archive, which is
+// held in memory so that its nodes are able to be hashed in the order in
+// which DigestFromDirectory would hash them once extracted.
+type archiveNode struct {
+	mode     os.FileMode             // type of the node
+	data     []byte                  // contents of the node, when it is a regular file
+	children map[string]*archiveNode // nodes within the node, when it is a directory
+}
+
+// archiveTree is the tree of file system nodes read from an archive, as it
+// would be extracted.
+type archiveTree struct {
+	root archiveNode
+}
+
+func newArchiveTree() *archiveTree {
+	return &archiveTree{root: archiveNode{mode: os.ModeDir, children: make(map[string]*archiveNode)}}
+}
+
+// cleanArchiveName returns the slash-separated pathname, relative to the root
+// of the archive, of the entry with the specified name, which is empty for the
+// root itself. Archives name directories with or without a trailing slash, and
+// often with a leading `./`, neither of which are part of the pathname. An
+// absolute name, or one which refers outside the archive, is an error.
+func cleanArchiveName(name string) (string, error) {
+	if strings.HasPrefix(name, "/") {
+		return "", errors.Errorf("cannot hash archive entry %q: pathname is absolute", name)
+	}
+	slashRelative := path.Clean(name)
+	if slashRelative == ".." || strings.HasPrefix(slashRelative, "../") {
+		return "", errors.Errorf("cannot hash archive entry %q: pathname refers outside the archive", name)
+	}
+	if slashRelative == "." {
+		return "", nil
+	}
+	return slashRelative, nil
+}
+
+// dir returns the directory at the specified slash-separated pathname,
+// creating it and any missing directories enclosing it, as extracting an
+// archive which does not list them would. A node which is not a directory at
+// one of those pathnames is replaced, as it would be when extracted.
+func (tree *archiveTree) dir(slashRelative string) *archiveNode {
+	node := &tree.root
+	if slashRelative == "" {
+		return node
+	}
+	for _, name := range strings.Split(slashRelative, "/") {
+		child, ok := node.children[name]
+		if !ok || !child.mode.IsDir() {
+			child = &archiveNode{mode: os.ModeDir, children: make(map[string]*archiveNode)}
+			node.children[name] = child
+		}
+		node = child
+	}
+	return node
+}
+
+// add adds the node at the specified slash-separated pathname to the tree,
+// replacing any node already there, as a later entry in an archive replaces an
+// earlier one with the same name when the archive is extracted. A directory
+// already in the tree is kept, along with its contents, when it is added
+// again.
+func (tree *archiveTree) add(slashRelative string, node *archiveNode) {
+	if slashRelative == "" {
+		return // the root is always a directory
+	}
+	if node.mode.IsDir() {
+		tree.dir(slashRelative)
+		return
+	}
+	slashParent := path.Dir(slashRelative)
+	if slashParent == "." {
+		slashParent = ""
+	}
+	tree.dir(slashParent).children[path.Base(slashRelative)] = node
+}
+
+// lookup returns the node at the specified slash-separated pathname, or nil
+// when there is none.
+func (tree *archiveTree) lookup(slashRelative string) *archiveNode {
+	node := &tree.root
+	if slashRelative == "" {
+		return node
+	}
+	for _, name := range strings.Split(slashRelative, "/") {
+		if node = node.children[name]; node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// digest returns the hash of the tree, which is identical to the hash
+// DigestFromDirectory returns for the tree once it has been extracted.
+func (tree *archiveTree) digest() ([]byte, error) {
+	closure := newDirWalkClosure(sha256.New())
+	defer closure.release()
+
+	if err := closure.writeArchiveNode("", &tree.root); err != nil {
+		return nil, err
+	}
+	return closure.someHash.Sum(nil), nil
+}
+
+// writeArchiveNode writes the specified node of an archive tree, and every
+// node within it, to the hash, in the order in which DigestFromDirectory
+// writes them, skipping the same nodes. Symbolic links are ignored entirely.
+// As when walking a directory, filepath.SkipDir is returned for a skipped node
+// which is not a directory, so that the remaining nodes of its directory are
+// skipped too.
+func (closure *dirWalkClosure) writeArchiveNode(slashRelative string, node *archiveNode) error {
+	if node.mode&os.ModeSymlink != 0 {
+		return nil
+	}
+	if skip, err := closure.walk.skipNode(slashRelative, node.mode.IsDir()); skip {
+		if node.mode.IsDir() {
+			return nil
+		}
+		return err
+	}
+
+	mt, isRegular := digestModeType(node.mode)
+	closure.writeEntry(digestEntry{osRelative: slashRelative, modeType: mt, isRegular: isRegular})
+	if isRegular {
+		written, err := closure.writeContents(bytes.NewReader(node.data))
+		return newCopyError(slashRelative, written, err)
+	}
+	if !node.mode.IsDir() {
+		return nil
+	}
+
+	names := make([]string, 0, len(node.children))
+	for name := range node.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := closure.writeArchiveNode(path.Join(slashRelative, name), node.children[name]); err != nil {
+			if err == filepath.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// DigestFromTar returns a hash of the tree of files in the specified tar
+// stream, which is identical to the hash DigestFromDirectory returns for the
+// directory into which the stream is extracted. The hash version is
+// HashVersion.
+//
+// Entries are hashed in the order in which DigestFromDirectory would find
+// them once extracted, rather than in the order in which they appear in the
+// stream, so the contents of every regular file are held in memory until the
+// whole stream has been read. As when extracting the stream, directories which
+// are not listed in it are implied by the entries within them, and an entry
+// replaces any earlier entry with the same name. A hard link is hashed as a
+// copy of the file it links to, and symbolic links are ignored, just as they
+// are by DigestFromDirectory.
+//
+// An entry with an absolute pathname, or one which refers outside the root of
+// the stream, is an error, because it cannot be extracted into a directory.
+func DigestFromTar(r io.Reader) ([]byte, error) {
+	tree := newArchiveTree()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot read tar stream")
+		}
+
+		slashRelative, err := cleanArchiveName(hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeXGlobalHeader:
+			continue // describes the entries which follow, rather than a node
+		case tar.TypeLink:
+			slashTarget, err := cleanArchiveName(hdr.Linkname)
+			if err != nil {
+				return nil, err
+			}
+			target := tree.lookup(slashTarget)
+			if target == nil || !target.mode.IsRegular() {
+				return nil, errors.Errorf("cannot hash tar entry %q: hard link to %q, which is not a regular file earlier in the stream", hdr.Name, hdr.Linkname)
+			}
+			tree.add(slashRelative, &archiveNode{mode: target.mode, data: target.data})
+			continue
+		}
+
+		node := &archiveNode{mode: hdr.FileInfo().Mode() & os.ModeType}
+		if node.mode.IsRegular() {
+			if node.data, err = ioutil.ReadAll(tr); err != nil {
+				return nil, errors.Wrapf(err, "cannot read tar entry %q", hdr.Name)
+			}
+		}
+		tree.add(slashRelative, node)
+	}
+
+	return tree.digest()
+}
+
+// DigestFromZip returns a hash of the tree of files in the zip archive of the
+// specified size which is read from the specified reader, which is identical
+// to the hash DigestFromDirectory returns for the directory into which the
+// archive is extracted. The hash version is HashVersion.
+//
+// As with DigestFromTar, directories which are not listed in the archive are
+// implied by the files within them, and a file replaces any earlier file with
+// the same name. Symbolic links, which are only recorded by archives created
+// on Unix systems, are ignored, just as they are by DigestFromDirectory.
+//
+// A file with an absolute pathname, or one which refers outside the root of
+// the archive, is an error, because it cannot be extracted into a directory.
+func DigestFromZip(r io.ReaderAt, size int64) ([]byte, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read zip archive")
+	}
+
+	tree := newArchiveTree()
+	for _, zf := range zr.File {
+		slashRelative, err := cleanArchiveName(zf.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		node := &archiveNode{mode: zf.Mode() & os.ModeType}
+		if node.mode.IsRegular() {
+			if node.data, err = readZipFile(zf); err != nil {
+				return nil, err
+			}
+		}
+		tree.add(slashRelative, node)
+	}
+
+	return tree.digest()
+}
+
+// readZipFile returns the uncompressed contents of the specified file of a
+// zip archive.
+func readZipFile(zf *zip.File) ([]byte, error) {
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open zip entry %q", zf.Name)
+	}
+	data, err := ioutil.ReadAll(rc)
+	if er := rc.Close(); err == nil {
+		err = er
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read zip entry %q", zf.Name)
+	}
+	return data, nil
+}
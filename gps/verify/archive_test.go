@@ -0,0 +1,329 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tarEntry describes an entry of a tar stream created by mkTar.
+type tarEntry struct {
+	name     string
+	typeflag byte
+	contents string // contents of a regular file, or target of a link
+}
+
+// mkTar returns a tar stream holding the specified entries, in order.
+func mkTar(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		hdr := &tar.Header{Name: entry.name, Typeflag: entry.typeflag, Mode: 0644}
+		switch entry.typeflag {
+		case tar.TypeReg:
+			hdr.Size = int64(len(entry.contents))
+		case tar.TypeDir:
+			hdr.Mode = 0755
+		case tar.TypeSymlink, tar.TypeLink:
+			hdr.Linkname = entry.contents
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if entry.typeflag == tar.TypeReg {
+			if _, err := io.WriteString(tw, entry.contents); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// extractTar extracts the specified tar stream into a temporary directory,
+// and returns its pathname.
+func extractTar(t *testing.T, stream []byte) string {
+	t.Helper()
+
+	osDirname, err := ioutil.TempDir("", "dep-verify-tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tar.NewReader(bytes.NewReader(stream))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		slashRelative, err := cleanArchiveName(hdr.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		osPathname := filepath.Join(osDirname, filepath.FromSlash(slashRelative))
+		if hdr.Typeflag == tar.TypeDir {
+			if err = os.MkdirAll(osPathname, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err = os.MkdirAll(filepath.Dir(osPathname), 0755); err != nil {
+			t.Fatal(err)
+		}
+		_ = os.Remove(osPathname) // a later entry replaces an earlier one
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = ioutil.WriteFile(osPathname, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		case tar.TypeLink:
+			if err = os.Link(filepath.Join(osDirname, filepath.FromSlash(hdr.Linkname)), osPathname); err != nil {
+				t.Fatal(err)
+			}
+		case tar.TypeSymlink:
+			if err = os.Symlink(hdr.Linkname, osPathname); err != nil {
+				os.RemoveAll(osDirname)
+				t.Skipf("cannot create symlink: %s", err)
+			}
+		}
+	}
+	return osDirname
+}
+
+func TestDigestFromTar(t *testing.T) {
+	stream := mkTar(t, []tarEntry{
+		{"./", tar.TypeDir, ""},
+		{"./pkg", tar.TypeDir, ""}, // no trailing slash
+		{"./pkg/a.go", tar.TypeReg, "package pkg\r\n"},
+		{"implied/deeper/b.go", tar.TypeReg, "package deeper\n"}, // no entries for its directories
+		{"a.b/c.go", tar.TypeReg, "package c\n"},
+		{"a/b.go", tar.TypeReg, "package a\n"},
+		{"empty/", tar.TypeDir, ""},
+		{"empty.txt", tar.TypeReg, ""},
+		{"dup.go", tar.TypeReg, "package first\n"},
+		{"dup.go", tar.TypeReg, "package second\n"},
+		{".git/HEAD", tar.TypeReg, "ref: refs/heads/master\n"},
+		{"pkg/vendor/x/x.go", tar.TypeReg, "package x\n"},
+		{"sub/.git", tar.TypeReg, "gitdir: ../.git/modules/sub\n"}, // skips the rest of sub, as in a submodule
+		{"sub/y/y.go", tar.TypeReg, "package y\n"},
+		{"sub/z.go", tar.TypeReg, "package sub\n"},
+		{"vendor", tar.TypeReg, "not a directory\n"}, // skips the rest of the root
+		{"z.go", tar.TypeReg, "package z\n"},
+		{"hard.go", tar.TypeLink, "pkg/a.go"},
+		{"link", tar.TypeSymlink, "pkg/a.go"},
+	})
+
+	osDirname := extractTar(t, stream)
+	defer os.RemoveAll(osDirname)
+
+	want, err := DigestFromDirectory(osDirname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DigestFromTar(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want.Digest) {
+		t.Errorf("(GOT): %x; (WNT): %x", got, want.Digest)
+	}
+}
+
+func TestDigestFromTarEmpty(t *testing.T) {
+	osDirname, err := ioutil.TempDir("", "dep-verify-tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(osDirname)
+
+	want, err := DigestFromDirectory(osDirname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DigestFromTar(bytes.NewReader(mkTar(t, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want.Digest) {
+		t.Errorf("(GOT): %x; (WNT): %x", got, want.Digest)
+	}
+}
+
+func TestDigestFromTarBails(t *testing.T) {
+	cases := map[string][]byte{
+		"Absolute":       mkTar(t, []tarEntry{{"/etc/passwd", tar.TypeReg, "root"}}),
+		"OutsideRoot":    mkTar(t, []tarEntry{{"a/../../b.go", tar.TypeReg, "package b\n"}}),
+		"DanglingLink":   mkTar(t, []tarEntry{{"hard.go", tar.TypeLink, "missing.go"}}),
+		"LinkToDir":      mkTar(t, []tarEntry{{"dir/", tar.TypeDir, ""}, {"hard", tar.TypeLink, "dir"}}),
+		"LinkOutside":    mkTar(t, []tarEntry{{"hard", tar.TypeLink, "../a.go"}}),
+		"TruncatedEntry": mkTar(t, []tarEntry{{"a.go", tar.TypeReg, "package a\n"}})[:515], // within the contents
+	}
+	for name, stream := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got, err := DigestFromTar(bytes.NewReader(stream)); err == nil {
+				t.Errorf("(GOT): %x; (WNT): error", got)
+			}
+		})
+	}
+}
+
+// mkZip returns a zip archive of the specified directory, which records the
+// type of each node in it, as archives created on Unix systems do.
+func mkZip(t *testing.T, osDirname string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	err := filepath.Walk(osDirname, func(osPathname string, info os.FileInfo, err error) error {
+		if err != nil || osPathname == osDirname {
+			return err
+		}
+		osRelative, err := filepath.Rel(osDirname, osPathname)
+		if err != nil {
+			return err
+		}
+		hdr, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(osRelative)
+		if info.IsDir() {
+			hdr.Name += "/"
+		} else {
+			hdr.Method = zip.Deflate
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.Mode().IsRegular():
+			data, err := ioutil.ReadFile(osPathname)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(data)
+			return err
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(osPathname)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(w, target)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDigestFromZip(t *testing.T) {
+	osDirname := mkTestTree(t, map[string]string{
+		"pkg/a.go":          "package pkg\r\n",
+		"a.b/c.go":          "package c\n",
+		"a/b.go":            "package a\n",
+		"empty.txt":         "",
+		".git/HEAD":         "ref: refs/heads/master\n",
+		"pkg/vendor/x/x.go": "package x\n",
+	})
+	defer os.RemoveAll(osDirname)
+	if err := os.Mkdir(filepath.Join(osDirname, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("pkg/a.go", filepath.Join(osDirname, "link")); err != nil {
+		t.Skipf("cannot create symlink: %s", err)
+	}
+
+	want, err := DigestFromDirectory(osDirname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := mkZip(t, osDirname)
+	got, err := DigestFromZip(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want.Digest) {
+		t.Errorf("(GOT): %x; (WNT): %x", got, want.Digest)
+	}
+
+	// Many archives list only files, leaving their directories implied.
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"pkg/a.go", "a.b/c.go", "a/b.go", "empty.txt"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(osDirname, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = zw.Create("empty/"); err != nil {
+		t.Fatal(err)
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err = DigestFromZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want.Digest) {
+		t.Errorf("implied directories: (GOT): %x; (WNT): %x", got, want.Digest)
+	}
+}
+
+func TestDigestFromZipBails(t *testing.T) {
+	t.Run("NotZip", func(t *testing.T) {
+		data := []byte("not a zip archive")
+		if got, err := DigestFromZip(bytes.NewReader(data), int64(len(data))); err == nil {
+			t.Errorf("(GOT): %x; (WNT): error", got)
+		}
+	})
+
+	t.Run("OutsideRoot", func(t *testing.T) {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		if _, err := zw.Create("../a.go"); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := DigestFromZip(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err == nil {
+			t.Errorf("(GOT): %x; (WNT): error", got)
+		}
+	})
+}
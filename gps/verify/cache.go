@@ -0,0 +1,182 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// digestCacheVersion identifies the format of the file written by
+// DigestCache.Save. Files with any other version are ignored.
+const digestCacheVersion = 1
+
+// DigestCache is a persistent cache of directory digests, which allows
+// DigestFromDirectory to be called repeatedly on unchanged directories
+// without reading the contents of their files.
+//
+// The cache records whole-directory digests rather than per-file digests,
+// because that is the only way to reproduce DigestFromDirectory exactly. Its
+// digest is a single SHA256 hash over the pathnames and contents of all of
+// the files, written one after another, so it cannot be assembled from
+// digests of the individual files. Instead, the cache records a fingerprint
+// of the pathname, type, size, and modification time of every node in the
+// directory alongside its digest. When the fingerprint of the directory is
+// unchanged, the recorded digest is returned; otherwise, the digest is
+// computed as usual and recorded, which reads every file in the directory
+// again, not only those that changed. A cached digest is therefore always
+// identical to the one DigestFromDirectory returns, unless a file was
+// modified without changing either its size or its modification time.
+//
+// A DigestCache is safe for concurrent use by multiple goroutines.
+type DigestCache struct {
+	osPathname string
+
+	mu      sync.Mutex
+	entries map[string]digestCacheEntry // keyed by absolute directory pathname
+	dirty   bool                        // true iff entries changed since being loaded or saved
+}
+
+// digestCacheEntry records the digest of a directory, and the fingerprint of
+// the directory when the digest was computed.
+type digestCacheEntry struct {
+	Fingerprint []byte `json:"fingerprint"`
+	Digest      []byte `json:"digest"`
+}
+
+// digestCacheFile is the format of the file written by DigestCache.Save.
+type digestCacheFile struct {
+	Version int                         `json:"version"`
+	Entries map[string]digestCacheEntry `json:"entries"`
+}
+
+// OpenDigestCache returns a DigestCache persisted in the specified file. The
+// file need not exist; it is created by Save. A file written by an
+// incompatible version of this package is ignored.
+func OpenDigestCache(osPathname string) (*DigestCache, error) {
+	cache := &DigestCache{
+		osPathname: osPathname,
+		entries:    make(map[string]digestCacheEntry),
+	}
+
+	data, err := ioutil.ReadFile(osPathname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cache, nil
+		}
+		return nil, newDigestError("ReadFile", osPathname, err)
+	}
+
+	var file digestCacheFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, errors.Wrapf(err, "cannot parse digest cache %q", osPathname)
+	}
+	if file.Version == digestCacheVersion && file.Entries != nil {
+		cache.entries = file.Entries
+	}
+	return cache, nil
+}
+
+// DigestFromDirectory returns a hash of the specified directory contents,
+// identical to the one returned by the DigestFromDirectory function, reusing
+// the digest recorded in the cache when the directory has not changed.
+func (cache *DigestCache) DigestFromDirectory(osDirname string) (VersionedDigest, error) {
+	osDirname, err := filepath.Abs(osDirname)
+	if err != nil {
+		return VersionedDigest{}, newDigestError("Abs", osDirname, err)
+	}
+
+	fingerprint, err := fingerprintDirectory(osDirname)
+	if err != nil {
+		return VersionedDigest{}, err
+	}
+
+	cache.mu.Lock()
+	entry, ok := cache.entries[osDirname]
+	cache.mu.Unlock()
+	if ok && bytes.Equal(entry.Fingerprint, fingerprint) {
+		return VersionedDigest{HashVersion: HashVersion, Digest: entry.Digest}, nil
+	}
+
+	digest, err := DigestFromDirectory(osDirname)
+	if err != nil {
+		return VersionedDigest{}, err
+	}
+
+	cache.mu.Lock()
+	cache.entries[osDirname] = digestCacheEntry{Fingerprint: fingerprint, Digest: digest.Digest}
+	cache.dirty = true
+	cache.mu.Unlock()
+	return digest, nil
+}
+
+// Save writes the cache to its file, unless it has not changed since it was
+// opened or last saved. The file is replaced atomically, so that a concurrent
+// OpenDigestCache never reads a partially written file.
+func (cache *DigestCache) Save() error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if !cache.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(digestCacheFile{Version: digestCacheVersion, Entries: cache.entries})
+	if err != nil {
+		return errors.Wrap(err, "cannot marshal digest cache")
+	}
+
+	fh, err := ioutil.TempFile(filepath.Dir(cache.osPathname), filepath.Base(cache.osPathname))
+	if err != nil {
+		return newDigestError("TempFile", cache.osPathname, err)
+	}
+	_, err = fh.Write(data)
+	if er := fh.Close(); err == nil {
+		err = er
+	}
+	if err == nil {
+		err = os.Rename(fh.Name(), cache.osPathname)
+	}
+	if err != nil {
+		_ = os.Remove(fh.Name())
+		return newDigestError("Save", cache.osPathname, err)
+	}
+
+	cache.dirty = false
+	return nil
+}
+
+// fingerprintDirectory returns a hash of the pathname, type, size, and
+// modification time of every file system node DigestFromDirectory would write
+// to the hash of the specified directory.
+func fingerprintDirectory(osDirname string) ([]byte, error) {
+	h := sha256.New()
+	scratch := make([]byte, 8)
+
+	err := walkDigestEntries(osDirname, defaultDigestOptions().walk, func(entry digestEntry) error {
+		writeBytesWithNull(h, []byte(filepath.ToSlash(entry.osRelative)))
+		binary.LittleEndian.PutUint32(scratch, uint32(entry.modeType))
+		writeBytesWithNull(h, scratch[:4])
+		if entry.isRegular {
+			binary.LittleEndian.PutUint64(scratch, uint64(entry.info.Size()))
+			writeBytesWithNull(h, scratch)
+			binary.LittleEndian.PutUint64(scratch, uint64(entry.info.ModTime().UnixNano()))
+			writeBytesWithNull(h, scratch)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
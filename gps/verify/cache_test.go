@@ -0,0 +1,128 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDigestCache(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		"a.go":     "package a\n",
+		"sub/b.go": "package sub\r\n",
+	})
+	defer os.RemoveAll(dir)
+	cacheDir, err := ioutil.TempDir("", "dep-verify-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+	cachePathname := filepath.Join(cacheDir, "digests.json")
+
+	cold, err := DigestFromDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := OpenDigestCache(cachePathname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		got, err := cache.DigestFromDirectory(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Digest, cold.Digest) || got.HashVersion != cold.HashVersion {
+			t.Fatalf("run %d:\n(GOT):\n\t%v\n(WNT):\n\t%v", i, got, cold)
+		}
+	}
+	if err := cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Change the contents of a file without changing its size or modification
+	// time, which only a cache hit fails to notice.
+	osPathname := filepath.Join(dir, "a.go")
+	fi, err := os.Stat(osPathname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(osPathname, []byte("package b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(osPathname, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenDigestCache(cachePathname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reopened.DigestFromDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Digest, cold.Digest) {
+		t.Errorf("Expected digest to be read from the saved cache\n(GOT):\n\t%v\n(WNT):\n\t%v", got, cold)
+	}
+
+	// Once the modification time changes, the digest is recomputed.
+	later := fi.ModTime().Add(time.Second)
+	if err := os.Chtimes(osPathname, later, later); err != nil {
+		t.Fatal(err)
+	}
+	got, err = reopened.DigestFromDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := DigestFromDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(want.Digest, cold.Digest) {
+		t.Fatal("Expected changed file to change the digest")
+	}
+	if !bytes.Equal(got.Digest, want.Digest) {
+		t.Errorf("\n(GOT):\n\t%v\n(WNT):\n\t%v", got, want)
+	}
+
+	// Adding a file changes the fingerprint as well.
+	if err := ioutil.WriteFile(filepath.Join(dir, "sub/c.go"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err = reopened.DigestFromDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err = DigestFromDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Digest, want.Digest) {
+		t.Errorf("\n(GOT):\n\t%v\n(WNT):\n\t%v", got, want)
+	}
+}
+
+func TestOpenDigestCacheBailsOnCorruptFile(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "dep-verify-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+	cachePathname := filepath.Join(cacheDir, "digests.json")
+	if err := ioutil.WriteFile(cachePathname, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenDigestCache(cachePathname); err == nil {
+		t.Error("expected error for corrupt cache file")
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// DepthMismatch describes a locked dependency whose code appears to have been
+// vendored one directory level deeper or shallower than its pathname in the
+// lock file, which CheckDepTree reports confusingly: as DigestMismatchInLock
+// when the code is deeper, because the dependency's directory holds only the
+// directory the code is in, and as NotInTree when the code is shallower.
+type DepthMismatch struct {
+	// Path is the slash-separated pathname of the dependency in the lock
+	// file.
+	Path string
+
+	// Found lists the slash-separated pathnames of the directories which
+	// appear to hold the dependency's code, in lexicographical order.
+	Found []string
+}
+
+// FindDepthMismatches returns the locked dependencies at the specified
+// slash-separated pathnames, relative to the specified vendor directory, whose
+// code appears to be one directory level deeper or shallower than expected,
+// sorted by pathname.
+//
+// A dependency's code appears to be deeper when its directory holds nothing
+// other than directories, some of which hold other nodes, such as when the
+// code of `github.com/alice/alice1` was vendored as
+// `github.com/alice/alice1/v2`. It appears to be shallower when its directory
+// does not exist, but the directory enclosing it holds nodes other than
+// directories, such as when the code of `github.com/alice/alice1` was vendored
+// as `github.com/alice`. Directories which are themselves locked dependencies
+// are never considered to hold another dependency's code. The names in
+// DefaultSkipDirs are ignored, as they are by CheckDepTree.
+func FindDepthMismatches(osDirname string, slashPathnames []string) ([]DepthMismatch, error) {
+	locked := make(map[string]bool, len(slashPathnames))
+	for _, slashPathname := range slashPathnames {
+		locked[slashPathname] = true
+	}
+	sorted := append([]string(nil), slashPathnames...)
+	sort.Strings(sorted)
+
+	var mismatches []DepthMismatch
+	for _, slashPathname := range sorted {
+		osPathname := filepath.Join(osDirname, filepath.FromSlash(slashPathname))
+		fi, err := os.Lstat(osPathname)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, newDigestError("Lstat", osPathname, err)
+		}
+
+		var found []string
+		switch {
+		case err == nil && fi.IsDir():
+			holdsFiles, subdirs, err := dirLayout(osPathname)
+			if err != nil {
+				return nil, err
+			}
+			if holdsFiles {
+				continue
+			}
+			for _, subdir := range subdirs {
+				slashSubdir := path.Join(slashPathname, subdir)
+				if locked[slashSubdir] {
+					continue
+				}
+				subdirHoldsFiles, _, err := dirLayout(filepath.Join(osPathname, subdir))
+				if err != nil {
+					return nil, err
+				}
+				if subdirHoldsFiles {
+					found = append(found, slashSubdir)
+				}
+			}
+		case err != nil:
+			slashParent := path.Dir(slashPathname)
+			if slashParent == "." || locked[slashParent] {
+				continue
+			}
+			osParent := filepath.Join(osDirname, filepath.FromSlash(slashParent))
+			if fi, err := os.Lstat(osParent); err != nil || !fi.IsDir() {
+				continue
+			}
+			parentHoldsFiles, _, err := dirLayout(osParent)
+			if err != nil {
+				return nil, err
+			}
+			if parentHoldsFiles {
+				found = append(found, slashParent)
+			}
+		}
+
+		if len(found) > 0 {
+			mismatches = append(mismatches, DepthMismatch{Path: slashPathname, Found: found})
+		}
+	}
+	return mismatches, nil
+}
+
+// dirLayout returns whether the specified directory holds any nodes other than
+// directories, along with the sorted names of the directories it holds. The
+// names in DefaultSkipDirs are ignored.
+func dirLayout(osDirname string) (bool, []string, error) {
+	children, err := sortedDirChildren(osDirname)
+	if err != nil {
+		return false, nil, err
+	}
+
+	var holdsFiles bool
+	var subdirs []string
+	for _, child := range children {
+		switch {
+		case DefaultSkipDirs[child.Name()]:
+			// skip
+		case child.Type().IsDir():
+			subdirs = append(subdirs, child.Name())
+		default:
+			holdsFiles = true
+		}
+	}
+	return holdsFiles, subdirs, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestFindDepthMismatches(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/v2/a1.go": "package alice1\n", // one level deeper
+		"github.com/alice/alice2/a2.go":    "package alice2\n",
+		"github.com/bob/b.go":              "package bob1\n", // one level shallower
+		"github.com/charlie/c1/x/y/c.go":   "package c1\n",   // two levels deeper
+		"github.com/dave/dave1/d1.go":      "package dave1\n",
+		"github.com/dave/dave1/sub/s.go":   "package sub\n",
+		"github.com/eve/eve1/.git/HEAD":    "ref: refs/heads/master\n",
+		"github.com/eve/eve1/v2/e1.go":     "package eve1\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+
+	got, err := FindDepthMismatches(vendorRoot, []string{
+		"github.com/eve/eve1",
+		"github.com/alice/alice1",
+		"github.com/alice/alice2",
+		"github.com/bob/bob1",
+		"github.com/charlie/c1",
+		"github.com/dave/dave1",
+		"github.com/frank/frank1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DepthMismatch{
+		{Path: "github.com/alice/alice1", Found: []string{"github.com/alice/alice1/v2"}},
+		{Path: "github.com/bob/bob1", Found: []string{"github.com/bob"}},
+		{Path: "github.com/eve/eve1", Found: []string{"github.com/eve/eve1/v2"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", got, want)
+	}
+
+	// CheckDepTree has no way to tell these apart from other mismatches.
+	status, err := CheckDepTree(vendorRoot, map[string]VersionedDigest{
+		"github.com/alice/alice1": {HashVersion: HashVersion, Digest: []byte{1}},
+		"github.com/bob/bob1":     {HashVersion: HashVersion, Digest: []byte{1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status["github.com/alice/alice1"] != DigestMismatchInLock || status["github.com/bob/bob1"] != NotInTree {
+		t.Errorf("(GOT): %v", status)
+	}
+}
+
+func TestFindDepthMismatchesSkipsLocked(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/sub/a1.go": "package sub\n",
+		"github.com/bob/b.go":               "package bob\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+
+	// Neither the nested nor the enclosing directory is another project's
+	// code when it is itself locked.
+	got, err := FindDepthMismatches(vendorRoot, []string{
+		"github.com/alice/alice1",
+		"github.com/alice/alice1/sub",
+		"github.com/bob",
+		"github.com/bob/bob1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("(GOT): %v; (WNT): []", got)
+	}
+}
@@ -6,29 +6,126 @@ package verify
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"go/build"
 	"hash"
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
 
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/blake2b"
 )
 
 // HashVersion is an arbitrary number that identifies the hash algorithm used by
-// the directory hasher.
+// the directory hasher. The digests of a version never change, because the
+// order and framing in which nodes are hashed, described by
+// DigestFromDirectory, are fixed for each version.
 //
-//   1: SHA256, as implemented in crypto/sha256
+//	1: SHA256, as implemented in crypto/sha256
 const HashVersion = 1
 
 const osPathSeparator = string(filepath.Separator)
 
+// DefaultSkipDirs is the set of file system node names which are skipped by
+// DigestFromDirectory and CheckDepTree, along with everything beneath them.
+// These are nested `vendor` directories, and the directories typically used
+// by Version Control Systems (VCS).
+//
+// Changing the contents of DefaultSkipDirs changes the digests computed by,
+// and expected by, every function in this package that does not accept its
+// own set of names to skip.
+var DefaultSkipDirs = map[string]bool{
+	vendorDirname: true,
+	".bzr":        true,
+	".git":        true,
+	".hg":         true,
+	".svn":        true,
+}
+
+// vendorDirname is the name of nested vendor directories, which are skipped
+// unless a walk is configured to include them.
+const vendorDirname = "vendor"
+
+// HashAlgo identifies one of the hash algorithms the directory hasher is able
+// to use.
+type HashAlgo uint8
+
+const (
+	// SHA256 selects SHA256, as implemented in crypto/sha256. It is the
+	// algorithm identified by HashVersion, and the one used by
+	// DigestFromDirectory and CheckDepTree.
+	SHA256 HashAlgo = iota
+
+	// SHA512 selects SHA512, as implemented in crypto/sha512.
+	SHA512
+
+	// BLAKE2b256 selects the 256-bit variant of BLAKE2b, as implemented in
+	// golang.org/x/crypto/blake2b.
+	BLAKE2b256
+)
+
+func (algo HashAlgo) String() string {
+	switch algo {
+	case SHA256:
+		return "sha256"
+	case SHA512:
+		return "sha512"
+	case BLAKE2b256:
+		return "blake2b-256"
+	}
+	return "unknown"
+}
+
+// size returns the number of bytes in digests produced by the algorithm, or 0
+// for an unknown algorithm.
+func (algo HashAlgo) size() int {
+	switch algo {
+	case SHA256:
+		return sha256.Size
+	case SHA512:
+		return sha512.Size
+	case BLAKE2b256:
+		return blake2b.Size256
+	}
+	return 0
+}
+
+// newHashFunc returns the hash constructor for the specified algorithm.
+func newHashFunc(algo HashAlgo) (func() hash.Hash, error) {
+	switch algo {
+	case SHA256:
+		return sha256.New, nil
+	case SHA512:
+		return sha512.New, nil
+	case BLAKE2b256:
+		return newBlake2b256, nil
+	}
+	return nil, errors.Errorf("unknown hash algorithm: %d", algo)
+}
+
+// newBlake2b256 returns a new unkeyed BLAKE2b-256 hash.
+func newBlake2b256() hash.Hash {
+	// blake2b.New256 only returns an error when the key is longer than 64
+	// bytes, which cannot happen without a key.
+	h, _ := blake2b.New256(nil)
+	return h
+}
+
 // lineEndingReader is a `io.Reader` that converts CRLF sequences to LF.
 //
 // When cloning or checking out repositories, some Version Control Systems,
@@ -44,6 +141,9 @@ const osPathSeparator = string(filepath.Separator)
 type lineEndingReader struct {
 	src             io.Reader // source io.Reader from which this reads
 	prevReadEndedCR bool      // used to track whether final byte of previous Read was CR
+	convertLoneCR   bool      // true iff CR not followed by LF is also converted to LF
+	heldByte        byte      // byte following a lone CR which did not fit in the previous Read's buffer
+	hasHeldByte     bool      // true iff heldByte is yet to be emitted
 }
 
 // newLineEndingReader returns a new lineEndingReader that reads from the
@@ -58,6 +158,21 @@ var crlf = []byte("\r\n")
 // specified slice of bytes. It converts all CRLF byte sequences to LF, and
 // handles cases where CR and LF straddle across two Read operations.
 func (f *lineEndingReader) Read(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	if f.hasHeldByte {
+		// The held byte is neither CR nor LF, so it is emitted as it is.
+		buf[0] = f.heldByte
+		f.hasHeldByte = false
+		return 1, nil
+	}
+	if f.prevReadEndedCR && len(buf) == 1 {
+		// There is no room to read from the source while reserving a byte
+		// for the trailing CR from the previous Read.
+		return f.readAfterCR(buf)
+	}
+
 	buflen := len(buf)
 	if f.prevReadEndedCR {
 		// Read one fewer bytes so we have room if the first byte of the
@@ -115,10 +230,30 @@ func (f *lineEndingReader) Read(buf []byte) (int, error) {
 		if f.prevReadEndedCR = buf[nr-1] == '\r'; f.prevReadEndedCR {
 			nr-- // pretend byte was never read from source
 		}
-	} else if f.prevReadEndedCR {
+
+		// Every CR remaining in the buffer is now known not to be followed by
+		// LF, because CRLF sequences were removed and a trailing CR is held
+		// back until the next Read.
+		if f.convertLoneCR {
+			for i := bytes.IndexByte(buf[:nr], '\r'); i != -1; i = bytes.IndexByte(buf[:nr], '\r') {
+				buf[i] = '\n'
+			}
+		}
+
+		// When the source returned its final bytes along with an error, the
+		// trailing CR will never be followed by LF, so emit it now, in the
+		// room left by holding it back.
+		if f.prevReadEndedCR && er != nil {
+			buf[nr] = f.loneCR()
+			nr++
+			f.prevReadEndedCR = false
+		}
+	} else if f.prevReadEndedCR && er != nil {
 		// Reading from source returned nothing, but this struct is sitting on a
-		// trailing CR from previous Read, so let's give it to client now.
-		buf[0] = '\r'
+		// trailing CR from previous Read, so let's give it to client now. A
+		// source which returns neither bytes nor an error may yet return LF,
+		// so the CR remains held in that case.
+		buf[0] = f.loneCR()
 		nr = 1
 		er = nil
 		f.prevReadEndedCR = false // prevent infinite loop
@@ -126,12 +261,159 @@ func (f *lineEndingReader) Read(buf []byte) (int, error) {
 	return nr, er
 }
 
+// readAfterCR fills a buffer of a single byte while the trailing CR from the
+// previous Read is held back, by reading a single byte from the source to
+// determine whether the CR is followed by LF.
+//
+// When the CR is not followed by LF, both the CR and the byte read need to be
+// emitted, but only the CR fits in the buffer, so the byte read is held back
+// in turn, as is any error returned along with it. Like the rest of the
+// standard library, this relies on the source returning the same error again
+// on the next Read.
+func (f *lineEndingReader) readAfterCR(buf []byte) (int, error) {
+	nr, er := f.src.Read(buf)
+	if nr == 0 {
+		if er == nil {
+			return 0, nil // the CR remains held, as the source may yet return LF
+		}
+		buf[0] = f.loneCR()
+		f.prevReadEndedCR = false
+		return 1, nil
+	}
+
+	switch buf[0] {
+	case '\n':
+		f.prevReadEndedCR = false // CRLF becomes LF, which is already in the buffer
+		return 1, er
+	case '\r':
+		// The held CR was a lone CR, and the CR just read is held back in its
+		// place.
+		buf[0] = f.loneCR()
+		return 1, nil
+	default:
+		f.heldByte, f.hasHeldByte = buf[0], true
+		f.prevReadEndedCR = false
+		buf[0] = f.loneCR()
+		return 1, nil
+	}
+}
+
+// loneCR returns the byte emitted in place of a CR not followed by LF.
+func (f *lineEndingReader) loneCR() byte {
+	if f.convertLoneCR {
+		return '\n'
+	}
+	return '\r'
+}
+
+// utf8BOM is the UTF-8 encoding of the byte order mark, U+FEFF.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// bomStrippingReader is an io.Reader that removes a UTF-8 byte order mark from
+// the start of its source io.Reader, and conveys all other bytes unchanged.
+type bomStrippingReader struct {
+	src     io.Reader // source io.Reader from which this reads
+	checked bool      // true iff start of source has been checked for a BOM
+	head    [3]byte   // storage for bytes read from source while checking for a BOM
+	pending []byte    // bytes read from source while checking for a BOM, not yet returned
+}
+
+// Read consumes bytes from the structure's source io.Reader to fill the
+// specified slice of bytes. The first Read ensures the first three bytes of the
+// source have been read, even when that takes several Read operations on the
+// source, in order to decide whether they are a BOM.
+func (f *bomStrippingReader) Read(buf []byte) (int, error) {
+	if !f.checked {
+		f.checked = true
+		nr, err := io.ReadFull(f.src, f.head[:])
+		if nr < len(utf8BOM) || !bytes.Equal(f.head[:], utf8BOM) {
+			f.pending = f.head[:nr]
+		}
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+	}
+	if len(f.pending) > 0 {
+		nr := copy(buf, f.pending)
+		f.pending = f.pending[nr:]
+		return nr, nil
+	}
+	return f.src.Read(buf)
+}
+
+// trailingWhitespaceReader is an io.Reader that removes the spaces and tabs
+// which immediately precede each LF byte from its source io.Reader, and
+// conveys all other bytes unchanged. Spaces and tabs which are not followed by
+// a LF, such as those at the end of the source, are conveyed.
+type trailingWhitespaceReader struct {
+	src     io.Reader // source io.Reader from which this reads
+	scratch []byte    // storage for bytes read from source
+	pending []byte    // spaces and tabs read from source, dropped when a LF follows them
+	out     []byte    // bytes processed, of which those from off on are not yet returned
+	off     int       // index of the first byte of out not yet returned
+	err     error     // error returned by source, returned once out is drained
+}
+
+// Read consumes bytes from the structure's source io.Reader to fill the
+// specified slice of bytes. A run of spaces and tabs is held back, across as
+// many Read operations as it spans, until the byte following it is read, which
+// decides whether the run is removed.
+func (f *trailingWhitespaceReader) Read(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	for f.off == len(f.out) {
+		f.out, f.off = f.out[:0], 0
+		if f.err != nil {
+			if len(f.pending) == 0 {
+				return 0, f.err
+			}
+			f.out, f.pending = append(f.out, f.pending...), f.pending[:0]
+			break
+		}
+		if cap(f.scratch) < len(buf) {
+			f.scratch = make([]byte, len(buf))
+		}
+		nr, err := f.src.Read(f.scratch[:len(buf)])
+		f.err = err
+		for _, b := range f.scratch[:nr] {
+			switch b {
+			case ' ', '\t':
+				f.pending = append(f.pending, b)
+			case '\n':
+				f.pending = f.pending[:0]
+				f.out = append(f.out, b)
+			default:
+				f.out = append(f.out, f.pending...)
+				f.pending = f.pending[:0]
+				f.out = append(f.out, b)
+			}
+		}
+		if nr == 0 && err == nil {
+			return 0, nil // let the caller decide whether to read again
+		}
+	}
+	nw := copy(buf, f.out[f.off:])
+	f.off += nw
+	return nw, nil
+}
+
+// nullByte is written to the hash after each field by writeBytesWithNull. It
+// is never modified.
+var nullByte = []byte{0}
+
 // writeBytesWithNull appends the specified data to the specified hash, followed by
 // the NULL byte, in order to make accidental hash collisions less likely.
+//
+// The data and the NULL byte are written separately rather than appending the
+// NULL byte to the data, which would allocate when the data has no spare
+// capacity, and otherwise overwrite the byte following the data in the
+// caller's backing array.
 func writeBytesWithNull(h hash.Hash, data []byte) {
 	// Ignore return values from writing to the hash, because hash write always
 	// returns nil error.
-	_, _ = h.Write(append(data, 0))
+	_, _ = h.Write(data)
+	_, _ = h.Write(nullByte)
 }
 
 // dirWalkClosure is used to reduce number of allocation involved in closing
@@ -139,8 +421,758 @@ func writeBytesWithNull(h hash.Hash, data []byte) {
 type dirWalkClosure struct {
 	someCopyBufer []byte // allocate once and reuse for each file copy
 	someModeBytes []byte // allocate once and reuse for each node
-	someDirLen    int
+	someSizeBytes []byte // allocate once and reuse for each file, when sizes are fixed width
 	someHash      hash.Hash
+
+	// ctx is checked for cancellation before each node is written to the hash,
+	// and before the contents of each file are copied to the hash.
+	ctx context.Context
+
+	// stats, when not nil, accumulates counts of the nodes and bytes written
+	// to the hash.
+	stats *DigestStats
+
+	// bytesHashed is the number of bytes of file contents written to the hash
+	// so far.
+	bytesHashed int64
+
+	digestOptions
+}
+
+// digestOptions determines how a directory tree is written to a hash. The
+// zero value walks every node, and therefore ought to be initialized from
+// defaultDigestOptions.
+type digestOptions struct {
+	// mmapThreshold is the size at and above which files are memory mapped
+	// rather than read, or zero when files are always read.
+	mmapThreshold int64
+
+	// walk determines which file system nodes are written to the hash.
+	walk walkOptions
+
+	// convertLoneCR causes CR bytes which are not followed by LF to be
+	// converted to LF when line endings are normalized.
+	convertLoneCR bool
+
+	// stripBOM causes a UTF-8 byte order mark at the start of a file to be
+	// removed before its contents are written to the hash.
+	stripBOM bool
+
+	// includePerm causes the permission bits of each regular file to be
+	// written to the hash, after its type.
+	includePerm bool
+
+	// includeModTime causes the modification time of each node to be written
+	// to the hash, after its type.
+	includeModTime bool
+
+	// symlinkTargets causes the normalized referent of each symbolic link
+	// passed to the walk function, and the type of the node it refers to, to
+	// be written to the hash after its type.
+	symlinkTargets bool
+
+	// stripTrailingWhitespace causes the spaces and tabs at the end of each
+	// line to be removed after line endings are normalized.
+	stripTrailingWhitespace bool
+
+	// wrapReader, when not nil, transforms the reader of each file's contents,
+	// after they are normalized.
+	wrapReader func(io.Reader) io.Reader
+
+	// raw causes file contents to be written to the hash exactly as they are
+	// read, rather than with their line endings normalized.
+	raw bool
+
+	// omitSize causes the size of each file's contents not to be written to
+	// the hash after them.
+	omitSize bool
+
+	// sizeSeparator causes a NULL byte to be written to the hash between
+	// each file's contents and its size.
+	sizeSeparator bool
+
+	// fixedWidthSize causes the size of each file's contents to be written to
+	// the hash as 8 big-endian bytes, rather than as a NULL terminated decimal
+	// string.
+	fixedWidthSize bool
+
+	// detectChanges causes a regular file whose size or modification time
+	// changes while it is read to be an error.
+	detectChanges bool
+
+	// generated, when not nil, causes regular files whose first line it
+	// matches to be skipped.
+	generated *regexp.Regexp
+
+	// maxBytes, when positive, is the number of bytes of file contents which
+	// are able to be written to the hash before ErrSizeLimitExceeded is
+	// returned.
+	maxBytes int64
+
+	// walkFunc, when not nil, is invoked with each node written to the hash,
+	// before it is written. An error it returns aborts the walk.
+	walkFunc func(slashRelative string, info os.FileInfo) error
+
+	// onOpenError, when not nil, is invoked when a regular file cannot be
+	// opened. When it returns true, the file is hashed as though it were
+	// empty, rather than failing.
+	onOpenError func(osPathname string, err error) bool
+
+	// salt, when not empty, is written to the hash, followed by a NULL byte,
+	// before any node of the tree.
+	salt string
+}
+
+// defaultDigestOptions returns the options used by DigestFromDirectory.
+func defaultDigestOptions() digestOptions {
+	return digestOptions{walk: walkOptions{skipDirs: DefaultSkipDirs}}
+}
+
+// copyBufferPool holds the buffers used to copy file contents to a hash, so
+// that hashing many directories in turn, as CheckDepTree does, reuses them
+// rather than allocating a new one for every directory.
+var copyBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 4*1024) // only allocate a single page
+		return &buf
+	},
+}
+
+// newDirWalkClosure returns a dirWalkClosure that writes to the specified hash.
+// Its release method ought to be called once it is no longer needed.
+func newDirWalkClosure(h hash.Hash) *dirWalkClosure {
+	return &dirWalkClosure{
+		someCopyBufer: *copyBufferPool.Get().(*[]byte),
+		someModeBytes: make([]byte, 4), // scratch place to store encoded os.FileMode (uint32)
+		someSizeBytes: make([]byte, 8), // scratch place to store encoded file size (uint64)
+		someHash:      h,
+		ctx:           context.Background(),
+		digestOptions: defaultDigestOptions(),
+	}
+}
+
+// release returns the closure's copy buffer to the pool. The closure must not
+// be used afterwards.
+func (closure *dirWalkClosure) release() {
+	buf := closure.someCopyBufer
+	copyBufferPool.Put(&buf)
+	closure.someCopyBufer = nil
+}
+
+// writeEntry writes the relative pathname and the type of the specified node
+// to the hash.
+func (closure *dirWalkClosure) writeEntry(entry digestEntry) {
+	// Write the relative pathname to hash because the hash is a function of
+	// the node names, node types, and node contents. Added benefit is that
+	// empty directories, named pipes, sockets, and devices. Use
+	// `filepath.ToSlash` to ensure relative pathname is os-agnostic.
+	writeBytesWithNull(closure.someHash, []byte(filepath.ToSlash(entry.osRelative)))
+	closure.writeModeType(entry.modeType)
+}
+
+// writeModeType writes the type of a file system node to the hash.
+func (closure *dirWalkClosure) writeModeType(modeType os.FileMode) {
+	binary.LittleEndian.PutUint32(closure.someModeBytes, uint32(modeType)) // encode the type of mode
+	writeBytesWithNull(closure.someHash, closure.someModeBytes)            // and write to hash
+}
+
+// writeFile writes the contents of the specified regular file to the hash,
+// followed by its size.
+func (closure *dirWalkClosure) writeFile(osPathname string) error {
+	fh, err := closure.walk.open(osPathname)
+	if err != nil {
+		if closure.onOpenError != nil && closure.onOpenError(osPathname, err) {
+			closure.writeSize(0) // nothing was written to the hash, so it is as though the file were empty
+			return nil
+		}
+		return newDigestError("Open", osPathname, err)
+	}
+	return closure.writeOpenFile(fh, osPathname)
+}
+
+// writeOpenFile writes the contents of the specified open regular file to the
+// hash, followed by its size, and closes it.
+func (closure *dirWalkClosure) writeOpenFile(fh io.ReadCloser, osPathname string) error {
+	if err := closure.ctx.Err(); err != nil {
+		_ = fh.Close()
+		return err
+	}
+
+	var before os.FileInfo
+	if closure.detectChanges {
+		var err error
+		if before, err = closure.statOpenFile(fh, osPathname); err != nil {
+			_ = fh.Close()
+			return newDigestError("Stat", osPathname, err)
+		}
+	}
+
+	var src io.Reader = fh
+	if closure.walk.retry.retries > 0 {
+		src = &retryingReader{src: fh, policy: &closure.walk.retry}
+	}
+	if osFile, ok := fh.(*os.File); ok && closure.mmapThreshold > 0 {
+		if data, unmap, ok := closure.mmap(osFile); ok {
+			defer unmap()
+			src = bytes.NewReader(data)
+		}
+	}
+	var counter *countingReader
+	if closure.detectChanges {
+		counter = &countingReader{src: src}
+		src = counter
+	}
+
+	written, err := closure.writeContents(src)
+	err = newCopyError(osPathname, written, err)
+	if err == nil && closure.detectChanges {
+		err = closure.checkUnchanged(fh, osPathname, before, counter.n)
+	}
+
+	// Close the file handle to the open file without masking
+	// possible previous error value.
+	if er := fh.Close(); err == nil {
+		err = newDigestError("Close", osPathname, er)
+	}
+	return err
+}
+
+// countingReader counts the bytes read from its source.
+type countingReader struct {
+	src io.Reader
+	n   int64 // number of bytes read so far
+}
+
+func (r *countingReader) Read(buf []byte) (int, error) {
+	n, err := r.src.Read(buf)
+	r.n += int64(n)
+	return n, err
+}
+
+// statOpenFile returns the file info of the specified open regular file, or,
+// when the file of a custom file system cannot describe itself, that of the
+// node at its pathname.
+func (closure *dirWalkClosure) statOpenFile(fh io.ReadCloser, osPathname string) (os.FileInfo, error) {
+	if sf, ok := fh.(interface {
+		Stat() (os.FileInfo, error)
+	}); ok {
+		return sf.Stat()
+	}
+	return closure.walk.lstat(osPathname)
+}
+
+// checkUnchanged returns an error matching ErrFileChangedDuringHash when the
+// specified number of bytes read from the specified open file is not its size
+// as described by the file info obtained before it was read, or when the file
+// info obtained now describes a different size or modification time.
+func (closure *dirWalkClosure) checkUnchanged(fh io.ReadCloser, osPathname string, before os.FileInfo, read int64) error {
+	after, err := closure.statOpenFile(fh, osPathname)
+	if err != nil {
+		return newDigestError("Stat", osPathname, err)
+	}
+	if read != before.Size() || after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) {
+		return newDigestError("hash", osPathname, ErrFileChangedDuringHash)
+	}
+	return nil
+}
+
+// sizeLimitingReader returns ErrSizeLimitExceeded once more than the specified
+// number of bytes are read from its source, so that hashing an unexpectedly
+// large tree is abandoned without the remainder of the tree being read.
+type sizeLimitingReader struct {
+	src       io.Reader
+	remaining int64 // number of bytes able to be read before the limit is exceeded
+}
+
+func (r *sizeLimitingReader) Read(buf []byte) (int, error) {
+	n, err := r.src.Read(buf)
+	if r.remaining -= int64(n); r.remaining < 0 {
+		return n, ErrSizeLimitExceeded
+	}
+	return n, err
+}
+
+// openUnlessGenerated opens the specified regular file, unless its first line
+// matches the closure's pattern of generated files, in which case the file is
+// closed again and the second return value is true. Only as much of the first
+// line as fits in the closure's copy buffer is matched, and a CR ending it is
+// not. When the file cannot be opened, no file and no error are returned, so
+// that the error is handled by writeFile as usual.
+func (closure *dirWalkClosure) openUnlessGenerated(osPathname string) (io.ReadCloser, bool, error) {
+	fh, err := closure.walk.open(osPathname)
+	if err != nil {
+		return nil, false, nil
+	}
+
+	// Reading at an offset leaves the file's offset at the start of the file,
+	// so its contents are able to be hashed, or memory mapped, from there. A
+	// file of a custom file system which cannot be read at an offset is read
+	// from its start instead, and the bytes read are hashed ahead of the rest.
+	var n int
+	if ra, ok := fh.(io.ReaderAt); ok {
+		for retries := 0; ; retries++ {
+			if n, err = ra.ReadAt(closure.someCopyBufer, 0); err == io.EOF || !closure.walk.retry.again(retries, err) {
+				break
+			}
+		}
+	} else {
+		n, err = io.ReadFull(&retryingReader{src: fh, policy: &closure.walk.retry}, closure.someCopyBufer)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		head := append([]byte(nil), closure.someCopyBufer[:n]...) // the buffer is reused while hashing
+		fh = &peekedFile{Reader: io.MultiReader(bytes.NewReader(head), fh), Closer: fh}
+	}
+	if err != nil && err != io.EOF {
+		_ = fh.Close()
+		return nil, false, newDigestError("Read", osPathname, err)
+	}
+	line := closure.someCopyBufer[:n]
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = bytes.TrimSuffix(line[:i], []byte{'\r'})
+	}
+	if closure.generated.Match(line) {
+		_ = fh.Close()
+		return nil, true, nil
+	}
+	return fh, false, nil
+}
+
+// peekedFile is an open file whose first bytes were read ahead, and are read
+// again from its reader before the rest of the file.
+type peekedFile struct {
+	io.Reader
+	io.Closer
+}
+
+// writeContents writes the normalized contents of the specified reader to the
+// hash, followed by their size, returning the number of bytes written, and
+// any error from reading them.
+func (closure *dirWalkClosure) writeContents(src io.Reader) (int64, error) {
+	if closure.stripBOM {
+		src = &bomStrippingReader{src: src}
+	}
+	if !closure.raw {
+		ler := newLineEndingReader(src)
+		ler.convertLoneCR = closure.convertLoneCR
+		src = ler
+	}
+	if closure.stripTrailingWhitespace {
+		src = &trailingWhitespaceReader{src: src}
+	}
+	if closure.wrapReader != nil {
+		src = closure.wrapReader(src)
+	}
+	if closure.maxBytes > 0 {
+		src = &sizeLimitingReader{src: src, remaining: closure.maxBytes - closure.bytesHashed}
+	}
+	bytesWritten, err := io.CopyBuffer(closure.someHash, src, closure.someCopyBufer) // fast copy of file contents to hash
+	closure.bytesHashed += bytesWritten
+	closure.writeSize(bytesWritten)
+	if closure.stats != nil {
+		closure.stats.Bytes += bytesWritten
+	}
+	return bytesWritten, err
+}
+
+// mmap memory maps the specified open file when it is at least as large as the
+// closure's threshold. The final return value is false when the file is too
+// small or cannot be mapped, in which case it ought to be read instead.
+func (closure *dirWalkClosure) mmap(fh *os.File) ([]byte, func() error, bool) {
+	fi, err := fh.Stat()
+	if err != nil || fi.Size() == 0 || fi.Size() < closure.mmapThreshold {
+		return nil, nil, false
+	}
+	data, unmap, err := mmapFile(fh, fi.Size())
+	if err != nil {
+		return nil, nil, false
+	}
+	return data, unmap, true
+}
+
+// writeModTime writes the specified modification time of a node to the hash,
+// as the decimal number of nanoseconds since the Unix epoch, so that it does
+// not depend on the time zone in effect.
+func (closure *dirWalkClosure) writeModTime(modTime time.Time) {
+	writeBytesWithNull(closure.someHash, []byte(strconv.FormatInt(modTime.UTC().UnixNano(), 10))) // 10: format time as base 10 integer
+}
+
+// writeSize writes the size of a file's contents to the hash, after its
+// contents.
+func (closure *dirWalkClosure) writeSize(size int64) {
+	if closure.sizeSeparator {
+		_, _ = closure.someHash.Write(nullByte)
+	}
+	if closure.omitSize {
+		return
+	}
+	if closure.fixedWidthSize {
+		binary.BigEndian.PutUint64(closure.someSizeBytes, uint64(size))
+		_, _ = closure.someHash.Write(closure.someSizeBytes)
+		return
+	}
+	writeBytesWithNull(closure.someHash, []byte(strconv.FormatInt(size, 10))) // 10: format file size as base 10 integer
+}
+
+// digestEntry describes a file system node which contributes to the digest of
+// a directory.
+type digestEntry struct {
+	osPathname string      // pathname of the node
+	osRelative string      // os-specific pathname of the node relative to the directory being hashed
+	modeType   os.FileMode // type of the node, as written to the hash
+	isRegular  bool        // true iff the node is a file whose contents are written to the hash
+	perm       os.FileMode // permission bits of the node
+	info       os.FileInfo // file info of the node, or of its referent when symbolic links are followed
+}
+
+// walkOptions determines which file system nodes are visited by
+// walkDigestEntries.
+type walkOptions struct {
+	// skipDirs is the set of node names which are skipped, along with
+	// everything beneath them.
+	skipDirs map[string]bool
+
+	// includeVendor causes nested vendor directories to be walked, even when
+	// vendorDirname is in skipDirs. VCS directories are still skipped.
+	includeVendor bool
+
+	// includeRootVendor causes only a vendor directory directly beneath the
+	// walked directory to be walked, even when vendorDirname is in skipDirs,
+	// as it is in the vendor directory of a vendored project.
+	includeRootVendor bool
+
+	// includeSymlinks causes symbolic links which are not followed to be
+	// passed to the walk function, with a mode type of os.ModeSymlink, rather
+	// than ignored.
+	includeSymlinks bool
+
+	// followSymlinks causes symbolic links to be resolved, and their referents
+	// walked in their place, under the pathname of the link.
+	followSymlinks bool
+
+	// ignores holds patterns matching nodes which are skipped, along with
+	// everything beneath them.
+	ignores []ignorePattern
+
+	// filter, when not nil, is invoked with the slash-separated relative
+	// pathname and file info of each node other than the walked directory
+	// itself, which is skipped, along with everything beneath it, when the
+	// filter returns false.
+	filter func(slashRelative string, info os.FileInfo) bool
+
+	// buildContext, when not nil, causes Go source files which would not be
+	// built in the context, because of their names or build constraints, to
+	// be skipped.
+	buildContext *build.Context
+
+	// maxDepth, when positive, is the greatest level at which a directory is
+	// able to be nested beneath the walked directory, whose own level is 0,
+	// before ErrMaxDepthExceeded is returned.
+	maxDepth int
+
+	// rejectCaseCollisions causes a directory containing nodes whose names
+	// differ only in case to be an error.
+	rejectCaseCollisions bool
+
+	// fsys, when not nil, is the file system in which nodes are found and
+	// read, in place of the one provided by the os package.
+	fsys FileSystem
+
+	// retry determines how the operations on each node which fail with
+	// transient errors are retried.
+	retry retryPolicy
+
+	// onSkip, when not nil, is invoked with the relative pathname of each
+	// node which is skipped, and of each node other than a regular file or a
+	// directory, whose contents are not written to the hash. The nodes
+	// beneath a skipped directory are not reported.
+	onSkip func(osRelative string, info os.FileInfo)
+}
+
+// walkDigestEntries walks the specified directory, invoking the callback for
+// each file system node that contributes to its digest, in the same order in
+// which those nodes are written to the hash.
+//
+// Nodes are visited in the same order as filepath.Walk visits them, and the
+// errors returned by the callback are handled the same way, so that following
+// symbolic links is the only way in which this differs from filepath.Walk.
+func walkDigestEntries(osDirname string, opts walkOptions, fn func(digestEntry) error) error {
+	return walkDigestEntriesFrom(osDirname, nil, opts, fn)
+}
+
+// walkDigestEntriesFrom walks the specified directory exactly as
+// walkDigestEntries does, given the file info of the directory as returned by
+// os.Lstat, so that a caller which already has it need not have it obtained
+// again. When the file info is nil, it is obtained as usual.
+func walkDigestEntriesFrom(osDirname string, info os.FileInfo, opts walkOptions, fn func(digestEntry) error) error {
+	osDirname = filepath.Clean(osDirname)
+	if info == nil {
+		var err error
+		if info, err = opts.lstat(osDirname); err != nil {
+			if os.IsNotExist(err) {
+				return newDigestError(opFindRoot, osDirname, err)
+			}
+			return newDigestError("Lstat", osDirname, err)
+		}
+	}
+
+	w := &digestWalker{opts: opts, fn: fn, someDirLen: prefixLength(osDirname, osPathSeparator)}
+	err := w.walk(osDirname, info, nil)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// prefixLength returns the length of the prefix to remove from the pathnames
+// of the nodes beneath the directory at the specified clean pathname, which
+// uses the specified separator, to make them relative to that directory. The
+// prefix includes the separator following the directory's pathname, unless
+// the pathname already ends with one, as does the pathname of a root
+// directory, such as `/` or `C:\`.
+func prefixLength(osDirname, separator string) int {
+	if strings.HasSuffix(osDirname, separator) {
+		return len(osDirname)
+	}
+	return len(osDirname) + len(separator)
+}
+
+// digestWalker holds the state of a single walkDigestEntries operation.
+type digestWalker struct {
+	opts       walkOptions
+	fn         func(digestEntry) error
+	someDirLen int // length of the walked directory's pathname, plus separator unless it ends with one
+}
+
+// walk visits the specified node, and when it is a directory, every node
+// beneath it. The ancestors are the file info of the directories enclosing the
+// node, used to detect symbolic link cycles.
+func (w *digestWalker) walk(osPathname string, info os.FileInfo, ancestors []os.FileInfo) error {
+	if info.Mode()&os.ModeSymlink != 0 && w.opts.followSymlinks {
+		referentInfo, err := w.opts.stat(osPathname)
+		if err != nil {
+			return newDigestError("Stat", osPathname, err)
+		}
+		info = referentInfo
+	}
+
+	if err := w.visit(osPathname, info); err != nil || !info.IsDir() {
+		if err == filepath.SkipDir && info.IsDir() {
+			return nil
+		}
+		return err
+	}
+
+	if w.opts.followSymlinks {
+		// A directory can only be its own ancestor when it is reached through
+		// a symbolic link, which would otherwise cause it to be walked
+		// forever.
+		dirInfo, err := w.opts.comparable(osPathname, info)
+		if err != nil {
+			return newDigestError("Stat", osPathname, err)
+		}
+		for _, ancestor := range ancestors {
+			if w.opts.sameFile(ancestor, dirInfo) {
+				return errors.Errorf("cannot hash symlink cycle: %q refers to one of its ancestors", osPathname)
+			}
+		}
+		ancestors = append(ancestors, dirInfo)
+	}
+
+	children, err := w.opts.dirChildren(osPathname)
+	if err != nil {
+		return err
+	}
+	if w.opts.rejectCaseCollisions {
+		osChildrenNames := make([]string, len(children))
+		for i, child := range children {
+			osChildrenNames[i] = child.Name()
+		}
+		if err = checkCaseCollisions(osPathname, osChildrenNames); err != nil {
+			return err
+		}
+	}
+
+	// Only the children of the directories enclosing the node being visited
+	// are held, and only by name, so that the memory used while walking grows
+	// with the depth of the tree and the width of its directories, rather than
+	// with the number of nodes in it. The pathname of each child is joined
+	// only when the child is visited, and each child is released once it has
+	// been walked.
+	for i, child := range children {
+		children[i] = nil
+		osChildPathname := filepath.Join(osPathname, child.Name())
+		childInfo, err := child.Info()
+		if err != nil {
+			return newDigestError("Lstat", osChildPathname, err)
+		}
+		// SkipDir returned for a node that is not a directory skips the
+		// remaining children of its directory.
+		if err = w.walk(osChildPathname, childInfo, ancestors); err != nil && (!childInfo.IsDir() || err != filepath.SkipDir) {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkCaseCollisions returns an error when any of the specified names of the
+// children of the specified directory are equal under Unicode case folding, as
+// they are compared by strings.EqualFold. Such children cannot coexist on a
+// case-insensitive file system, so the directory cannot be reproduced, and
+// hashed, on every platform.
+func checkCaseCollisions(osDirname string, osChildrenNames []string) error {
+	folded := make(map[string]string, len(osChildrenNames))
+	for _, osChildName := range osChildrenNames {
+		key := foldName(osChildName)
+		if other, ok := folded[key]; ok {
+			return errors.Errorf("cannot hash %q: %q and %q differ only in case", osDirname, other, osChildName)
+		}
+		folded[key] = osChildName
+	}
+	return nil
+}
+
+// foldName returns the specified name with each rune replaced by the smallest
+// rune it is equal to under simple Unicode case folding, so that two names
+// fold to the same string exactly when strings.EqualFold reports them equal.
+func foldName(name string) string {
+	return strings.Map(func(r rune) rune {
+		smallest := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if f < smallest {
+				smallest = f
+			}
+		}
+		return smallest
+	}, name)
+}
+
+// visit invokes the walk function for the specified node, unless the node is
+// skipped, in which case it returns SkipDir when everything beneath the node
+// is also skipped.
+func (w *digestWalker) visit(osPathname string, info os.FileInfo) error {
+	var osRelative string
+	if len(osPathname) > w.someDirLen {
+		osRelative = osPathname[w.someDirLen:]
+	}
+
+	// Completely ignore symlinks.
+	isSymlink := info.Mode()&os.ModeSymlink != 0
+	if isSymlink && !w.opts.includeSymlinks {
+		w.skipped(osRelative, info)
+		return nil
+	}
+
+	if skip, err := w.opts.skipNode(osRelative, info.IsDir()); skip {
+		w.skipped(osRelative, info)
+		return err
+	}
+
+	if w.opts.filter != nil && osRelative != "" && !w.opts.filter(filepath.ToSlash(osRelative), info) {
+		w.skipped(osRelative, info)
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+
+	if isSymlink {
+		return w.fn(digestEntry{osPathname: osPathname, osRelative: osRelative, modeType: os.ModeSymlink, info: info})
+	}
+
+	if w.opts.buildContext != nil && info.Mode().IsRegular() && filepath.Ext(osPathname) == ".go" {
+		match, err := w.opts.buildContext.MatchFile(filepath.Dir(osPathname), filepath.Base(osPathname))
+		if err != nil {
+			return newDigestError("MatchFile", osPathname, err)
+		}
+		if !match {
+			w.skipped(osRelative, info)
+			return nil
+		}
+	}
+
+	if w.opts.maxDepth > 0 && info.IsDir() && osRelative != "" && strings.Count(osRelative, osPathSeparator) >= w.opts.maxDepth {
+		return newDigestError("descend into", osPathname, ErrMaxDepthExceeded)
+	}
+
+	mt, isRegular := digestModeType(info.Mode())
+	if !isRegular && mt != os.ModeDir {
+		w.skipped(osRelative, info)
+	}
+	return w.fn(digestEntry{
+		osPathname: osPathname,
+		osRelative: osRelative,
+		modeType:   mt,
+		isRegular:  isRegular,
+		perm:       info.Mode().Perm(),
+		info:       info,
+	})
+}
+
+// skipped reports the node at the specified relative pathname to the skip
+// callback, if there is one.
+func (w *digestWalker) skipped(osRelative string, info os.FileInfo) {
+	if w.opts.onSkip != nil {
+		w.opts.onSkip(osRelative, info)
+	}
+}
+
+// skipNode reports whether the file system node at the specified relative
+// pathname is excluded from the digest. When it is, the returned error is the
+// value the walk function ought to return for the node, which is SkipDir when
+// everything beneath the node is also excluded.
+func (opts walkOptions) skipNode(osRelative string, isDir bool) (bool, error) {
+	if name := filepath.Base(osRelative); opts.skipDirs[name] && !(name == vendorDirname && (opts.includeVendor || opts.includeRootVendor && osRelative == name)) {
+		return true, filepath.SkipDir
+	}
+
+	if matchIgnorePatterns(opts.ignores, filepath.ToSlash(osRelative), isDir) {
+		if isDir {
+			return true, filepath.SkipDir
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// digestModeType returns the type of a file system node with the specified
+// mode, as written to the hash, and whether it is a file whose contents are
+// written to the hash.
+func digestModeType(mode os.FileMode) (os.FileMode, bool) {
+	// We could make our own enum-like data type for encoding the file type,
+	// but Go's runtime already gives us architecture independent file
+	// modes, as discussed in `os/types.go`:
+	//
+	//    Go's runtime FileMode type has same definition on all systems, so
+	//    that information about files can be moved from one system to
+	//    another portably.
+	var mt os.FileMode
+
+	// We only care about the bits that identify the type of a file system
+	// node, and can ignore append, exclusive, temporary, setuid, setgid,
+	// permission bits, and sticky bits, which are coincident to bits which
+	// declare type of the file system node.
+	modeType := mode & os.ModeType
+	var shouldSkip bool // skip some types of file system nodes
+
+	switch {
+	case modeType&os.ModeDir > 0:
+		mt = os.ModeDir
+		// This func does not need to enumerate children, because
+		// walkDigestEntries will do that for us.
+		shouldSkip = true
+	case modeType&os.ModeNamedPipe > 0:
+		mt = os.ModeNamedPipe
+		shouldSkip = true
+	case modeType&os.ModeSocket > 0:
+		mt = os.ModeSocket
+		shouldSkip = true
+	case modeType&os.ModeDevice > 0:
+		mt = os.ModeDevice
+		shouldSkip = true
+	}
+
+	return mt, !shouldSkip
 }
 
 // DigestFromDirectory returns a hash of the specified directory contents, which
@@ -149,7 +1181,7 @@ type dirWalkClosure struct {
 //
 // This function ignores any file system node named `vendor`, `.bzr`, `.git`,
 // `.hg`, and `.svn`, as these are typically used as Version Control System
-// (VCS) directories.
+// (VCS) directories. These names are listed in DefaultSkipDirs.
 //
 // Other than the `vendor` and VCS directories mentioned above, the calculated
 // hash includes the pathname to every discovered file system node, whether it
@@ -157,112 +1189,539 @@ type dirWalkClosure struct {
 //
 // Symbolic links are excluded, as they are not considered valid elements in the
 // definition of a Go module.
+//
+// The nodes are written to the hash in depth-first order, with the children of
+// each directory sorted by name, as filepath.Walk visits them, so that only
+// the directories enclosing the node being hashed need to be held in memory,
+// however wide the tree is.
+//
+// The digests of a HashVersion never change, so the order and framing in which
+// nodes are written to the hash are guaranteed to remain as follows, and any
+// change to them requires a new HashVersion:
+//
+//   - The directory itself is written first, then each of its children, each
+//     followed by everything beneath it before the next child is written.
+//   - Children are sorted by the bytes of their names, so `B.txt` precedes
+//     `a`, and everything beneath `a` precedes its sibling `a.b`, although
+//     `a.b/c` would precede `a/b` were whole pathnames sorted instead.
+//   - Each node is written as its slash-separated pathname relative to the
+//     directory, which is empty for the directory itself, followed by a NUL
+//     byte, then by the type bits of its mode, os.ModeType, as four bytes in
+//     little-endian order, followed by another NUL byte.
+//   - A regular file's node is followed by its contents with each CRLF sequence
+//     converted to LF, then by the number of bytes of those converted contents
+//     in decimal, followed by a NUL byte.
+//
+// The directory may be specified by either an absolute or a relative pathname,
+// including the root directory of a file system, and the hash does not depend
+// on which: the pathnames written to it are always relative to the directory.
+// Nor does it depend on the name of the directory itself, whose own relative
+// pathname is empty, so every empty directory has the same digest, as does
+// every directory with the same contents.
+//
+// When the specified directory does not exist, the returned error matches
+// ErrRootNotFound.
 func DigestFromDirectory(osDirname string) (VersionedDigest, error) {
-	osDirname = filepath.Clean(osDirname)
+	return DigestFromDirectoryContext(context.Background(), osDirname)
+}
+
+// DigestFromDirectoryWithSkipDirs returns a hash of the specified directory
+// contents, exactly as DigestFromDirectory does, but skips the file system
+// nodes whose names are in the specified set, rather than those in
+// DefaultSkipDirs.
+//
+// The result is the raw digest rather than a VersionedDigest, because only
+// CheckDepTreeWithSkipDirs, given the same set, is able to verify it.
+func DigestFromDirectoryWithSkipDirs(osDirname string, skipDirs map[string]bool) ([]byte, error) {
+	return NewDigester(WithSkipDirs(skipDirs)).Digest(osDirname)
+}
 
-	// Create a single hash instance for the entire operation, rather than a new
-	// hash for each node we encounter.
+// DigestFromDirectoryWithVendor returns a hash of the specified directory
+// contents, exactly as DigestFromDirectory does when includeVendor is false.
+// When includeVendor is true, nested `vendor` directories and their contents
+// are also written to the hash, which is useful for projects that ship their
+// own vendored dependencies. VCS directories are skipped either way.
+//
+// As with DigestFromDirectoryWithHash, the result is the raw digest rather
+// than a VersionedDigest, since CheckDepTree cannot verify the digests which
+// include nested `vendor` directories.
+func DigestFromDirectoryWithVendor(osDirname string, includeVendor bool) ([]byte, error) {
+	return NewDigester(WithVendor(includeVendor)).Digest(osDirname)
+}
 
-	closure := dirWalkClosure{
-		someCopyBufer: make([]byte, 4*1024), // only allocate a single page
-		someModeBytes: make([]byte, 4),      // scratch place to store encoded os.FileMode (uint32)
-		someDirLen:    len(osDirname) + len(osPathSeparator),
-		someHash:      sha256.New(),
+// DigestVendorRoot returns a single hash of the entire dependency tree rooted
+// at the specified vendor directory, which changes whenever any file of any
+// dependency changes, so that it can be used as a quick check of whether
+// anything in the tree changed since the hash was computed.
+//
+// The nodes beneath the vendor directory are skipped as CheckDepTree skips
+// them: VCS directories are skipped at every depth, as are the `vendor`
+// directories nested within dependencies, and symbolic links are excluded.
+// Unlike DigestFromDirectory, however, a `vendor` directory directly beneath
+// the vendor directory itself is hashed, as it is when a vendored tree that
+// was itself vendored is copied into a vendor directory. Every other node is
+// hashed, whether or not it belongs to a dependency, such as a manifest of
+// vendored modules stored in the vendor directory.
+//
+// The result is the raw digest rather than a VersionedDigest, because it
+// differs from the one DigestFromDirectory returns for a vendor directory with
+// such a `vendor` directory, and cannot be compared against the digests of
+// individual dependencies.
+func DigestVendorRoot(osDirname string) ([]byte, error) {
+	d := NewDigester()
+	d.walk.includeRootVendor = true
+	return d.Digest(osDirname)
+}
+
+// DigestFromDirectoryRaw returns a hash of the specified directory contents,
+// exactly as DigestFromDirectory does, except that the contents of each file
+// are written to the hash exactly as they are stored, without converting CRLF
+// line endings to LF. This is useful for trees whose files must be compared
+// byte for byte, such as binary files or fixtures in which CRLF is
+// significant.
+//
+// The raw and normalized digests of a tree differ whenever any of its files
+// contains a CRLF sequence, so raw digests cannot be verified by CheckDepTree,
+// and are returned as such rather than as a VersionedDigest.
+func DigestFromDirectoryRaw(osDirname string) ([]byte, error) {
+	return NewDigester(WithRawContents(true)).Digest(osDirname)
+}
+
+// DigestFromDirectoryConvertingLoneCR returns a hash of the specified
+// directory contents, exactly as DigestFromDirectory does, except that when
+// line endings are normalized, a CR not followed by LF, as used by classic Mac
+// OS, is also converted to LF. A file is then hashed identically whether its
+// lines end with LF, CRLF, or CR.
+//
+// These digests differ from those of DigestFromDirectory whenever any file
+// contains a lone CR, so they cannot be verified by CheckDepTree, and are not
+// returned as a VersionedDigest.
+func DigestFromDirectoryConvertingLoneCR(osDirname string) ([]byte, error) {
+	return NewDigester(WithLoneCRConversion(true)).Digest(osDirname)
+}
+
+// DigestFromDirectoryStrippingBOM returns a hash of the specified directory
+// contents, exactly as DigestFromDirectory does, except that a UTF-8 byte order
+// mark at the very start of a file, as some editors write, is removed before
+// the file's contents are written to the hash. The recorded size of such a
+// file excludes the byte order mark. Byte order marks anywhere else in a file
+// are hashed unchanged.
+//
+// These digests differ from those of DigestFromDirectory whenever any file
+// starts with a byte order mark, so they cannot be verified by CheckDepTree,
+// and are not returned as a VersionedDigest.
+func DigestFromDirectoryStrippingBOM(osDirname string) ([]byte, error) {
+	return NewDigester(WithBOMStripping(true)).Digest(osDirname)
+}
+
+// DigestFromDirectoryWithPerm returns a hash of the specified directory
+// contents, exactly as DigestFromDirectory does, except that the permission
+// bits of each regular file are also written to the hash, so that, for
+// instance, making a script executable changes the digest. The permission bits
+// of directories are not included, nor are any other mode bits.
+//
+// Permission bits depend on the umask in effect when a tree is written, and on
+// platforms such as Windows only approximate Unix permissions, so these digests
+// are only comparable between trees written the same way. They always differ
+// from those of DigestFromDirectory, so they cannot be verified by
+// CheckDepTree, and are not returned as a VersionedDigest.
+func DigestFromDirectoryWithPerm(osDirname string) ([]byte, error) {
+	return NewDigester(WithPerm(true)).Digest(osDirname)
+}
+
+// DigestFromDirectoryWithIgnores returns a hash of the specified directory
+// contents, exactly as DigestFromDirectory does, except that file system nodes
+// matching any of the specified ignore patterns are excluded from the hash. An
+// ignored directory is excluded along with everything beneath it.
+//
+// The patterns follow a subset of the conventions of `.gitignore` files, using
+// the syntax of path.Match, and are matched against slash-separated pathnames
+// relative to the specified directory:
+//
+//   - A pattern without a solidus, such as `*_test.go`, matches the name of a
+//     node at any depth.
+//   - A pattern containing a solidus, such as `internal/testdata` or
+//     `/fixtures`, matches the entire relative pathname of a node. A leading
+//     solidus is optional.
+//   - A pattern with a trailing solidus, such as `testdata/`, only matches
+//     directories.
+//
+// Ignore patterns only add to the nodes skipped because their names are in
+// DefaultSkipDirs; they cannot cause a skipped node to be hashed. Because
+// CheckDepTree hashes every node that is not skipped, the result is the raw
+// digest rather than a VersionedDigest.
+func DigestFromDirectoryWithIgnores(osDirname string, ignores []string) ([]byte, error) {
+	return NewDigester(WithIgnores(ignores)).Digest(osDirname)
+}
+
+// packageOnlyIgnores are the ignore patterns matching the nodes excluded by
+// DigestPackageOnly.
+var packageOnlyIgnores = []string{"*_test.go", "testdata/"}
+
+// DigestPackageOnly returns a hash of the specified directory contents, exactly
+// as DigestFromDirectory does, except that only the contents which are able to
+// be built as part of the Go packages within it are hashed, so that the hash
+// does not change when only their tests do. Test files, whose names end in
+// `_test.go`, are excluded, as are directories named `testdata`, along with
+// everything beneath them, at any depth.
+//
+// Because it excludes these nodes, the hash cannot be verified by CheckDepTree,
+// so it is returned as a raw digest rather than a VersionedDigest.
+func DigestPackageOnly(osDirname string) ([]byte, error) {
+	return NewDigester(WithIgnores(packageOnlyIgnores)).Digest(osDirname)
+}
+
+// hiddenIgnores are the ignore patterns matching the nodes excluded by
+// DigestExcludingHidden.
+var hiddenIgnores = []string{".*"}
+
+// DigestExcludingHidden returns a hash of the specified directory contents,
+// exactly as DigestFromDirectory does, except that hidden nodes, whose names
+// begin with a dot, such as `.travis.yml` and `.github`, are excluded, along
+// with everything beneath them, at any depth, just as the VCS directories in
+// DefaultSkipDirs always are. A Digester excludes the same nodes when created
+// with WithIgnores and the pattern `.*`.
+//
+// Because it excludes these nodes, the hash cannot be verified by CheckDepTree,
+// so it is returned as a raw digest rather than a VersionedDigest.
+func DigestExcludingHidden(osDirname string) ([]byte, error) {
+	return NewDigester(WithIgnores(hiddenIgnores)).Digest(osDirname)
+}
+
+// ListHidden returns the slash-separated pathnames, relative to the specified
+// directory, of the hidden nodes which DigestFromDirectory hashes, whose names
+// begin with a dot, in the order in which they are hashed, such as to enforce
+// a policy that vendored dependencies hold no such nodes. The nodes which are
+// already skipped, such as VCS directories, are not listed, nor are the nodes
+// beneath a hidden directory.
+func ListHidden(osDirname string) ([]string, error) {
+	var hidden []string
+	err := walkDigestEntries(osDirname, walkOptions{skipDirs: DefaultSkipDirs}, func(entry digestEntry) error {
+		if entry.osRelative == "" || !strings.HasPrefix(filepath.Base(entry.osRelative), ".") {
+			return nil
+		}
+		hidden = append(hidden, filepath.ToSlash(entry.osRelative))
+		if entry.modeType == os.ModeDir {
+			return filepath.SkipDir // everything beneath it is hidden too
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return hidden, nil
+}
 
-	err := filepath.Walk(osDirname, func(osPathname string, info os.FileInfo, err error) error {
-		if err != nil {
+// DigestFromDirectoryWithSkipped returns a hash of the specified directory
+// contents, exactly as DigestFromDirectory does, along with the slash-separated
+// pathnames, relative to the specified directory, of the file system nodes
+// which do not fully contribute to the hash, in the order they were
+// encountered. These are the nodes which are skipped, such as VCS and vendor
+// directories and symbolic links, and the nodes such as named pipes and
+// devices whose pathnames are written to the hash but whose contents are not.
+// The nodes beneath a skipped directory are not listed.
+func DigestFromDirectoryWithSkipped(osDirname string) ([]byte, []string, error) {
+	var skipped []string
+	closure := newDirWalkClosure(sha256.New())
+	defer closure.release()
+	closure.walk.onSkip = func(osRelative string, _ os.FileInfo) {
+		skipped = append(skipped, filepath.ToSlash(osRelative))
+	}
+
+	digest, err := closure.digest(osDirname)
+	if err != nil {
+		return nil, skipped, err
+	}
+	return digest, skipped, nil
+}
+
+// DigestFromReader returns a hash of the contents of the specified reader,
+// computed exactly as DigestFromDirectory computes the hash of a regular file
+// when it is passed that file's pathname, but without the contents ever
+// touching the file system. The hash version is HashVersion.
+func DigestFromReader(r io.Reader) ([]byte, error) {
+	closure := newDirWalkClosure(sha256.New())
+	defer closure.release()
+
+	closure.writeEntry(digestEntry{isRegular: true})
+	if written, err := closure.writeContents(r); err != nil {
+		return nil, errors.Wrapf(err, "cannot Copy at offset %d", written)
+	}
+	return closure.someHash.Sum(nil), nil
+}
+
+// DigestFromDirectoryContext returns a hash of the specified directory
+// contents, exactly as DigestFromDirectory does, unless the specified context
+// is cancelled before the hash is complete, in which case it returns the
+// context's error.
+func DigestFromDirectoryContext(ctx context.Context, osDirname string) (VersionedDigest, error) {
+	return versionedDigest(NewDigester().DigestContext(ctx, osDirname))
+}
+
+// DigestHexFromDirectory returns the hexadecimal encoding of the hash of the
+// specified directory contents, as computed by DigestFromDirectory.
+//
+// The result is always the full hexadecimal encoding of the digest, even for
+// a directory that has no contents, so it is never the empty string unless an
+// error is returned.
+func DigestHexFromDirectory(osDirname string) (string, error) {
+	digest, err := DigestFromDirectory(osDirname)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest.Digest), nil
+}
+
+// CheckDigest reports whether the hash of the specified directory contents,
+// as computed by DigestFromDirectory, matches the specified digest, which is
+// never the case when the digest was computed by another version of the
+// hashing algorithm. The digests are compared in constant time, so that the
+// time taken does not reveal how much of them match.
+func CheckDigest(osDirname string, expected VersionedDigest) (bool, error) {
+	got, err := DigestFromDirectory(osDirname)
+	if err != nil {
+		return false, err
+	}
+	if expected.HashVersion != got.HashVersion {
+		return false, nil
+	}
+	return subtle.ConstantTimeCompare(got.Digest, expected.Digest) == 1, nil
+}
+
+// DigestFromDirectoryWithHashAlgo returns a hash of the specified directory
+// contents, exactly as DigestFromDirectory does, but using the specified hash
+// algorithm.
+func DigestFromDirectoryWithHashAlgo(osDirname string, algo HashAlgo) ([]byte, error) {
+	return NewDigester(WithHashAlgo(algo)).Digest(osDirname)
+}
+
+// TaggedDigestFromDirectory returns a hash of the specified directory contents
+// computed using the specified hash algorithm, prefixed by a single byte
+// identifying that algorithm, so that the digest describes how it was made.
+//
+// A tagged digest is always exactly one byte longer than the digests produced
+// by its algorithm, which is how CheckDepTree and CheckDepTreeWithHashAlgo
+// tell tagged digests apart from untagged ones.
+func TaggedDigestFromDirectory(osDirname string, algo HashAlgo) ([]byte, error) {
+	digest, err := DigestFromDirectoryWithHashAlgo(osDirname, algo)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{byte(algo)}, digest...), nil
+}
+
+// splitTaggedDigest returns the hash algorithm and untagged digest of a digest
+// produced by TaggedDigestFromDirectory. The final return value is false when
+// the specified digest is not tagged.
+func splitTaggedDigest(digest []byte) (HashAlgo, []byte, bool) {
+	if len(digest) == 0 {
+		return 0, nil, false
+	}
+	algo := HashAlgo(digest[0])
+	if size := algo.size(); size == 0 || size != len(digest)-1 {
+		return 0, nil, false
+	}
+	return algo, digest[1:], true
+}
+
+// DigestFromDirectoryWithHash returns a hash of the specified directory
+// contents, exactly as DigestFromDirectory does, but using a hash instance
+// created by the specified constructor rather than SHA256.
+//
+// Because the hash algorithm is chosen by the caller, the result is the raw
+// digest rather than a VersionedDigest: HashVersion only describes digests
+// produced by DigestFromDirectory itself.
+func DigestFromDirectoryWithHash(osDirname string, newHash func() hash.Hash) ([]byte, error) {
+	return NewDigester(WithHash(newHash)).Digest(osDirname)
+}
+
+// DigestFromDirectoryWithMmap returns a hash of the specified directory
+// contents identical to the one returned by DigestFromDirectory, but memory
+// maps each regular file at least as large as the specified threshold rather
+// than reading it, which can be faster for large files. Files that cannot be
+// memory mapped, including all files on platforms that do not support it, are
+// read as usual.
+func DigestFromDirectoryWithMmap(osDirname string, threshold int64) (VersionedDigest, error) {
+	return versionedDigest(NewDigester(WithMmap(threshold)).Digest(osDirname))
+}
+
+// digest writes the specified directory to the closure's hash, and returns the
+// resulting digest.
+func (closure *dirWalkClosure) digest(osDirname string) ([]byte, error) {
+	return closure.digestFrom(osDirname, nil)
+}
+
+// digestFrom writes the specified directory to the closure's hash exactly as
+// digest does, given the file info of the directory as returned by os.Lstat,
+// or nil when it has not already been obtained.
+func (closure *dirWalkClosure) digestFrom(osDirname string, info os.FileInfo) ([]byte, error) {
+	if closure.salt != "" {
+		writeBytesWithNull(closure.someHash, []byte(closure.salt))
+	}
+	err := walkDigestEntriesFrom(osDirname, info, closure.walk, func(entry digestEntry) error {
+		if err := closure.ctx.Err(); err != nil {
 			return err
 		}
+		var fh io.ReadCloser
+		if entry.isRegular && closure.generated != nil {
+			var generated bool
+			var err error
+			if fh, generated, err = closure.openUnlessGenerated(entry.osPathname); err != nil {
+				return err
+			}
+			if generated {
+				if closure.walk.onSkip != nil {
+					closure.walk.onSkip(entry.osRelative, entry.info)
+				}
+				return nil
+			}
+		}
+		if closure.walkFunc != nil {
+			if err := closure.walkFunc(filepath.ToSlash(entry.osRelative), entry.info); err != nil {
+				if fh != nil {
+					_ = fh.Close()
+				}
+				return walkFuncError{err}
+			}
+		}
+		closure.writeEntry(entry)
+		closure.countEntry(entry)
+		if closure.includeModTime {
+			closure.writeModTime(entry.info.ModTime())
+		}
+		if entry.modeType == os.ModeSymlink && closure.symlinkTargets {
+			return closure.writeSymlinkTarget(entry.osPathname)
+		}
+		if !entry.isRegular {
+			return nil // nothing more to do for some of the node types
+		}
+		if closure.includePerm {
+			closure.writeModeType(entry.perm)
+		}
+		if fh != nil {
+			return closure.writeOpenFile(fh, entry.osPathname)
+		}
+		return closure.writeFile(entry.osPathname)
+	})
+	if wfe, ok := err.(walkFuncError); ok {
+		return nil, wfe.err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return closure.someHash.Sum(nil), nil
+}
+
+// Names of the types of the nodes to which symbolic links refer, as written to
+// the hash by writeSymlinkTarget.
+var (
+	symlinkToNothing = []byte("none")
+	symlinkToFile    = []byte("file")
+	symlinkToDir     = []byte("dir")
+	symlinkToOther   = []byte("other")
+)
 
-		// Completely ignore symlinks.
-		if info.Mode()&os.ModeSymlink != 0 {
-			return nil
-		}
+// writeSymlinkTarget writes the normalized referent of the specified symbolic
+// link to the hash, followed by the type of the node to which it refers, or
+// by symlinkToNothing when there is no such node.
+func (closure *dirWalkClosure) writeSymlinkTarget(osPathname string) error {
+	referent, err := closure.walk.readlink(osPathname)
+	if err != nil {
+		return newDigestError("Readlink", osPathname, err)
+	}
+	writeBytesWithNull(closure.someHash, []byte(normalizeReferent(referent)))
+
+	kind := symlinkToNothing
+	fi, err := closure.walk.stat(osPathname)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return newDigestError("Stat", osPathname, err)
+	case fi.Mode().IsRegular():
+		kind = symlinkToFile
+	case fi.IsDir():
+		kind = symlinkToDir
+	default:
+		kind = symlinkToOther
+	}
+	writeBytesWithNull(closure.someHash, kind)
+	return nil
+}
 
-		var osRelative string
-		if len(osPathname) > closure.someDirLen {
-			osRelative = osPathname[closure.someDirLen:]
-		}
+// walkFuncError wraps an error returned by the walk function of a
+// dirWalkClosure, so that it aborts the walk even when it is SkipDir, and is
+// able to be returned as it was.
+type walkFuncError struct {
+	err error
+}
 
-		switch filepath.Base(osRelative) {
-		case "vendor", ".bzr", ".git", ".hg", ".svn":
-			return filepath.SkipDir
-		}
+func (e walkFuncError) Error() string { return e.err.Error() }
 
-		// We could make our own enum-like data type for encoding the file type,
-		// but Go's runtime already gives us architecture independent file
-		// modes, as discussed in `os/types.go`:
-		//
-		//    Go's runtime FileMode type has same definition on all systems, so
-		//    that information about files can be moved from one system to
-		//    another portably.
-		var mt os.FileMode
-
-		// We only care about the bits that identify the type of a file system
-		// node, and can ignore append, exclusive, temporary, setuid, setgid,
-		// permission bits, and sticky bits, which are coincident to bits which
-		// declare type of the file system node.
-		modeType := info.Mode() & os.ModeType
-		var shouldSkip bool // skip some types of file system nodes
-
-		switch {
-		case modeType&os.ModeDir > 0:
-			mt = os.ModeDir
-			// This func does not need to enumerate children, because
-			// filepath.Walk will do that for us.
-			shouldSkip = true
-		case modeType&os.ModeNamedPipe > 0:
-			mt = os.ModeNamedPipe
-			shouldSkip = true
-		case modeType&os.ModeSocket > 0:
-			mt = os.ModeSocket
-			shouldSkip = true
-		case modeType&os.ModeDevice > 0:
-			mt = os.ModeDevice
-			shouldSkip = true
-		}
-
-		// Write the relative pathname to hash because the hash is a function of
-		// the node names, node types, and node contents. Added benefit is that
-		// empty directories, named pipes, sockets, and devices. Use
-		// `filepath.ToSlash` to ensure relative pathname is os-agnostic.
-		writeBytesWithNull(closure.someHash, []byte(filepath.ToSlash(osRelative)))
-
-		binary.LittleEndian.PutUint32(closure.someModeBytes, uint32(mt)) // encode the type of mode
-		writeBytesWithNull(closure.someHash, closure.someModeBytes)      // and write to hash
-
-		if shouldSkip {
-			return nil // nothing more to do for some of the node types
-		}
+// DigestStats describes the work done while computing the digest of a
+// directory.
+type DigestStats struct {
+	Files       int   // regular files whose contents were written to the hash
+	Directories int   // directories written to the hash, including the hashed directory itself
+	Symlinks    int   // symbolic links encountered, whether or not they were written to the hash
+	Bytes       int64 // bytes of file contents written to the hash, after normalization
+}
 
-		// If we get here, node is a regular file.
-		fh, err := os.Open(osPathname)
-		if err != nil {
-			return errors.Wrap(err, "cannot Open")
-		}
+// countEntry adds the specified node to the closure's statistics, if it has
+// any.
+func (closure *dirWalkClosure) countEntry(entry digestEntry) {
+	if closure.stats == nil {
+		return
+	}
+	switch {
+	case entry.isRegular:
+		closure.stats.Files++
+	case entry.modeType == os.ModeDir:
+		closure.stats.Directories++
+	case entry.modeType == os.ModeSymlink:
+		closure.stats.Symlinks++
+	}
+}
 
-		var bytesWritten int64
-		bytesWritten, err = io.CopyBuffer(closure.someHash, newLineEndingReader(fh), closure.someCopyBufer) // fast copy of file contents to hash
-		err = errors.Wrap(err, "cannot Copy")                                                               // errors.Wrap only wraps non-nil, so skip extra check
-		writeBytesWithNull(closure.someHash, []byte(strconv.FormatInt(bytesWritten, 10)))                   // 10: format file size as base 10 integer
+// ListDigestInputs returns the slash-separated pathnames, relative to the
+// specified directory, of the file system nodes DigestFromDirectory writes to
+// the hash of the directory, in the order it writes them, without reading the
+// contents of any file. The directory itself is listed first, as the empty
+// string. Symbolic links are not listed, because DigestFromDirectory ignores
+// them; DigestFromDirectoryWithSkipped lists them along with the other nodes
+// which are skipped.
+func ListDigestInputs(osDirname string) ([]string, error) {
+	var slashPathnames []string
+	err := walkDigestEntries(osDirname, defaultDigestOptions().walk, func(entry digestEntry) error {
+		slashPathnames = append(slashPathnames, filepath.ToSlash(entry.osRelative))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return slashPathnames, nil
+}
 
-		// Close the file handle to the open file without masking
-		// possible previous error value.
-		if er := fh.Close(); err == nil {
-			err = errors.Wrap(er, "cannot Close")
+// DigestFromDirectoryWithStats returns a hash of the specified directory
+// contents, exactly as DigestFromDirectory does, along with statistics of the
+// work done to compute it. When an error prevents the hash from being
+// completed, the statistics describe the work done before the error.
+func DigestFromDirectoryWithStats(osDirname string) ([]byte, DigestStats, error) {
+	var stats DigestStats
+	closure := newDirWalkClosure(sha256.New())
+	defer closure.release()
+	closure.stats = &stats
+	closure.walk.onSkip = func(_ string, info os.FileInfo) {
+		if info.Mode()&os.ModeSymlink != 0 {
+			stats.Symlinks++
 		}
-		return err
-	})
+	}
 
+	digest, err := closure.digest(osDirname)
 	if err != nil {
-		return VersionedDigest{}, err
+		return nil, stats, err
 	}
-
-	return VersionedDigest{
-		HashVersion: HashVersion,
-		Digest:      closure.someHash.Sum(nil),
-	}, nil
+	return digest, stats, nil
 }
 
 // VendorStatus represents one of a handful of possible status conditions for a
@@ -295,6 +1754,25 @@ const (
 	// the digest being compared against is not the same as the one used by the
 	// current program.
 	HashVersionMismatch
+
+	// SymlinkInTree is used when the file system node for a dependency listed
+	// in the lock file is a symbolic link rather than a directory. Symbolic
+	// links are never followed while computing digests, so the dependency
+	// cannot be verified.
+	SymlinkInTree
+
+	// EmptyTreeInLock is used when the directory of a dependency listed in
+	// the lock file exists, but holds nothing that is hashed, so its digest
+	// does not match the one in the lock file. While this is a special case of
+	// DigestMismatchInLock, it usually means the dependency was never written
+	// to the directory, such as when vendoring was interrupted.
+	EmptyTreeInLock
+
+	// Unchanged is used by CheckDepTreeSince when no file system node within
+	// the directory of a dependency listed in the lock file was modified
+	// since the specified time, so its digest was not computed, and the
+	// dependency is assumed to still match the lock file as it did then.
+	Unchanged
 )
 
 func (ls VendorStatus) String() string {
@@ -311,19 +1789,148 @@ func (ls VendorStatus) String() string {
 		return "mismatch"
 	case HashVersionMismatch:
 		return "hasher changed"
+	case SymlinkInTree:
+		return "symlink in tree"
+	case EmptyTreeInLock:
+		return "empty tree"
+	case Unchanged:
+		return "unchanged"
 	}
 	return "unknown"
 }
 
+// MarshalJSON encodes the vendor status condition as a JSON string, using the
+// same text String returns. Unknown conditions result in an error.
+func (ls VendorStatus) MarshalJSON() ([]byte, error) {
+	text := ls.String()
+	if text == "unknown" {
+		return nil, errors.Errorf("cannot marshal unknown vendor status: %d", ls)
+	}
+	return json.Marshal(text)
+}
+
+// UnmarshalJSON decodes a vendor status condition from a JSON string, which
+// must be the text String returns for one of the known conditions. As with
+// other types, decoding JSON null leaves the condition unchanged.
+func (ls *VendorStatus) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return errors.Wrap(err, "cannot unmarshal vendor status")
+	}
+	for candidate := NotInLock; candidate.String() != "unknown"; candidate++ {
+		if candidate.String() == text {
+			*ls = candidate
+			return nil
+		}
+	}
+	return errors.Errorf("cannot unmarshal unknown vendor status: %q", text)
+}
+
+// FormatStatuses returns the specified vendor status conditions as text, one
+// line per file system node, sorted by pathname, in the form "status\tpath".
+// The same conditions always result in the same text, which makes it suitable
+// for comparing the results of verifying a tree in golden files.
+func FormatStatuses(status map[string]VendorStatus) string {
+	slashPathnames := make([]string, 0, len(status))
+	for slashPathname := range status {
+		slashPathnames = append(slashPathnames, slashPathname)
+	}
+	sort.Strings(slashPathnames)
+
+	var buf bytes.Buffer
+	for _, slashPathname := range slashPathnames {
+		fmt.Fprintf(&buf, "%s\t%s\n", status[slashPathname], slashPathname)
+	}
+	return buf.String()
+}
+
+// SummarizeStatuses returns the number of file system nodes with each vendor
+// status condition, such as to report how many dependencies matched. Every
+// known condition is present, with a count of zero when no node has it, so
+// that summaries are able to be rendered the same way for every tree.
+// Conditions which are not known are counted as they are.
+func SummarizeStatuses(status map[string]VendorStatus) map[VendorStatus]int {
+	counts := make(map[VendorStatus]int)
+	for candidate := NotInLock; candidate.String() != "unknown"; candidate++ {
+		counts[candidate] = 0
+	}
+	for _, ls := range status {
+		counts[ls]++
+	}
+	return counts
+}
+
+// StatusesEqual reports whether the specified vendor status conditions are
+// identical: whether both have the same pathnames, each with the same status.
+func StatusesEqual(a, b map[string]VendorStatus) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for slashPathname, ls := range a {
+		if other, ok := b[slashPathname]; !ok || other != ls {
+			return false
+		}
+	}
+	return true
+}
+
+// StatusesDiff returns a line describing each difference between the first,
+// actual, and second, expected, vendor status conditions, sorted by pathname,
+// in the form "path: (GOT): status; (WNT): status". A pathname which is only
+// in one of them has the status "absent" in the other. The result is empty
+// when StatusesEqual reports the conditions equal.
+func StatusesDiff(got, want map[string]VendorStatus) []string {
+	slashPathnames := make([]string, 0, len(got))
+	for slashPathname := range got {
+		slashPathnames = append(slashPathnames, slashPathname)
+	}
+	for slashPathname := range want {
+		if _, ok := got[slashPathname]; !ok {
+			slashPathnames = append(slashPathnames, slashPathname)
+		}
+	}
+	sort.Strings(slashPathnames)
+
+	describe := func(status map[string]VendorStatus, slashPathname string) string {
+		if ls, ok := status[slashPathname]; ok {
+			return ls.String()
+		}
+		return "absent"
+	}
+
+	var diffs []string
+	for _, slashPathname := range slashPathnames {
+		gotStatus, gotOK := got[slashPathname]
+		wantStatus, wantOK := want[slashPathname]
+		if gotOK == wantOK && gotStatus == wantStatus {
+			continue
+		}
+		diffs = append(diffs, fmt.Sprintf("%s: (GOT): %s; (WNT): %s", slashPathname, describe(got, slashPathname), describe(want, slashPathname)))
+	}
+	return diffs
+}
+
 // fsnode is used to track which file system nodes are required by the lock
 // file. When a directory is found whose name matches one of the declared
 // projects in the lock file, e.g., "github.com/alice/alice1", an fsnode is
 // created for that directory, but not for any of its children. All other file
 // system nodes encountered will result in a fsnode created to represent it.
 type fsnode struct {
-	osRelative           string // os-specific relative path of a resource under vendor root
-	isRequiredAncestor   bool   // true iff this node or one of its descendants is in the lock file
-	myIndex, parentIndex int    // index of this node and its parent in the tree's slice
+	osRelative           string       // os-specific relative path of a resource under vendor root
+	isRequiredAncestor   bool         // true iff this node or one of its descendants is in the lock file
+	myIndex, parentIndex int          // index of this node and its parent in the tree's slice
+	info                 os.FileInfo  // file info of directories, used to detect symlink cycles
+	lstatInfo            os.FileInfo  // file info of locked dependencies, without following symlinks
+	contents             treeContents // regular files within this node, when they are counted
+}
+
+// treeContents describes the regular files within a file system node.
+type treeContents struct {
+	files int   // number of regular files
+	bytes int64 // total size of the regular files, as stored
 }
 
 // VersionedDigest comprises both a hash digest, and a simple integer indicating
@@ -363,6 +1970,68 @@ func ParseVersionedDigest(input string) (VersionedDigest, error) {
 	return vd, nil
 }
 
+// CanonicalizeDigests returns a copy of the specified expected digest sums
+// whose keys are expressed as CheckDepTree requires them to be, so that lock
+// files assembled by hand or by older tools verify as intended. In each key,
+// both the solidus, `/`, and the reverse solidus, `\`, separate elements,
+// which are joined with the solidus by the copy, and empty and "." elements,
+// such as those of a leading "./", of a trailing separator, and of repeated
+// separators, are removed.
+//
+// Any other malformed key is an error, rather than being guessed at, so that
+// it cannot silently be merged with the key of another dependency: a key with
+// a leading separator, which is absolute, a key with a ".." element, and a key
+// with no elements left, which names no dependency. Two keys which are
+// canonicalized to the same key are an error too.
+func CanonicalizeDigests(wantDigests map[string]VersionedDigest) (map[string]VersionedDigest, error) {
+	// Keys are visited in order, so that a collision is always reported the
+	// same way.
+	keys := make([]string, 0, len(wantDigests))
+	for key := range wantDigests {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	canonical := make(map[string]VersionedDigest, len(wantDigests))
+	original := make(map[string]string, len(wantDigests))
+	for _, key := range keys {
+		slashPathname, err := canonicalizeKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := original[slashPathname]; ok {
+			return nil, errors.Errorf("cannot canonicalize %q: %q is canonicalized to the same key, %q", key, other, slashPathname)
+		}
+		original[slashPathname] = key
+		canonical[slashPathname] = wantDigests[key]
+	}
+	return canonical, nil
+}
+
+// canonicalizeKey returns the specified key of expected digest sums as
+// described by CanonicalizeDigests.
+func canonicalizeKey(key string) (string, error) {
+	slashPathname := strings.Replace(key, `\`, "/", -1)
+	if strings.HasPrefix(slashPathname, "/") {
+		return "", errors.Errorf("cannot canonicalize %q: pathname is absolute", key)
+	}
+
+	var elements []string
+	for _, element := range strings.Split(slashPathname, "/") {
+		switch element {
+		case "", ".":
+			continue
+		case "..":
+			return "", errors.Errorf("cannot canonicalize %q: pathname has a %q element", key, element)
+		}
+		elements = append(elements, element)
+	}
+	if len(elements) == 0 {
+		return "", errors.Errorf("cannot canonicalize %q: pathname names no dependency", key)
+	}
+	return strings.Join(elements, "/"), nil
+}
+
 // CheckDepTree verifies a dependency tree according to expected digest sums,
 // and returns an associative array of file system nodes and their respective
 // vendor status conditions.
@@ -372,15 +2041,510 @@ func ParseVersionedDigest(input string) (VersionedDigest, error) {
 // solidus character, `/`, as its path separator. For example, even on a GOOS
 // platform where the file system path separator is a character other than
 // solidus, one particular dependency would be represented as
-// "github.com/alice/alice1".
+// "github.com/alice/alice1". CanonicalizeDigests expresses keys this way.
 func CheckDepTree(osDirname string, wantDigests map[string]VersionedDigest) (map[string]VendorStatus, error) {
+	return CheckDepTreeContext(context.Background(), osDirname, wantDigests)
+}
+
+// CheckDepTreeContext verifies a dependency tree exactly as CheckDepTree does,
+// unless the specified context is cancelled before verification is complete,
+// in which case it returns the context's error and no vendor status
+// conditions.
+func CheckDepTreeContext(ctx context.Context, osDirname string, wantDigests map[string]VersionedDigest) (map[string]VendorStatus, error) {
+	checker := depTreeChecker{ctx: ctx, digester: NewDigester()}
+	return checker.check(osDirname, wantDigests)
+}
+
+// CheckDepTreeHex verifies a dependency tree exactly as CheckDepTree does, but
+// accepts each expected digest as the hexadecimal encoding of a digest of the
+// current HashVersion, as recorded by lock files which store digests that way.
+// An empty string is an empty digest, reported as EmptyDigestInLock. When any
+// expected digest is not valid hexadecimal, an error naming its dependency is
+// returned before the tree is verified.
+func CheckDepTreeHex(osDirname string, wantDigests map[string]string) (map[string]VendorStatus, error) {
+	slashPathnames := make([]string, 0, len(wantDigests))
+	for slashPathname := range wantDigests {
+		slashPathnames = append(slashPathnames, slashPathname)
+	}
+	sort.Strings(slashPathnames) // report the same dependency every time
+
+	decoded := make(map[string]VersionedDigest, len(wantDigests))
+	for _, slashPathname := range slashPathnames {
+		digest, err := hex.DecodeString(wantDigests[slashPathname])
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot decode digest of %q", slashPathname)
+		}
+		decoded[slashPathname] = VersionedDigest{HashVersion: HashVersion, Digest: digest}
+	}
+	return CheckDepTree(osDirname, decoded)
+}
+
+// ProjectStatus is the vendor status condition of a single file system node
+// in a dependency tree, identified by its slash-separated pathname relative to
+// the root of the tree.
+type ProjectStatus struct {
+	Path   string
+	Status VendorStatus
+}
+
+// CheckDepTreeSorted verifies a dependency tree exactly as CheckDepTree does,
+// but returns the vendor status conditions as a slice sorted by pathname, so
+// that they can be reported in a deterministic order.
+func CheckDepTreeSorted(osDirname string, wantDigests map[string]VersionedDigest) ([]ProjectStatus, error) {
+	status, err := CheckDepTree(osDirname, wantDigests)
+	if err != nil {
+		return nil, err
+	}
+	return sortedStatuses(status), nil
+}
+
+// sortedStatuses returns the specified vendor status conditions sorted by
+// pathname.
+func sortedStatuses(status map[string]VendorStatus) []ProjectStatus {
+	sorted := make([]ProjectStatus, 0, len(status))
+	for slashPathname, ls := range status {
+		sorted = append(sorted, ProjectStatus{Path: slashPathname, Status: ls})
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+	return sorted
+}
+
+// CheckDepTreeWithSkipDirs verifies a dependency tree exactly as CheckDepTree
+// does, but skips the file system nodes whose names are in the specified set,
+// rather than those in DefaultSkipDirs, both while walking the tree and while
+// computing the digest of each dependency. The expected digests must have
+// been computed by DigestFromDirectoryWithSkipDirs using the same set.
+func CheckDepTreeWithSkipDirs(osDirname string, wantDigests map[string]VersionedDigest, skipDirs map[string]bool) (map[string]VendorStatus, error) {
+	return NewDigester(WithSkipDirs(skipDirs)).CheckDepTree(osDirname, wantDigests)
+}
+
+// CheckDepTreeWithHashAlgo verifies a dependency tree exactly as CheckDepTree
+// does, but computes the digest of each dependency using the specified hash
+// algorithm.
+//
+// The expected digests must have been produced using the same algorithm,
+// unless they were produced by TaggedDigestFromDirectory, in which case each
+// dependency is hashed using the algorithm identified by its tag. The hash
+// version of a digest does not record which algorithm produced it, so
+// comparing untagged digests produced by one algorithm against digests
+// computed by another results in DigestMismatchInLock rather than
+// HashVersionMismatch.
+func CheckDepTreeWithHashAlgo(osDirname string, wantDigests map[string]VersionedDigest, algo HashAlgo) (map[string]VendorStatus, error) {
+	return NewDigester(WithHashAlgo(algo)).CheckDepTree(osDirname, wantDigests)
+}
+
+// CheckDepTreeSince verifies a dependency tree exactly as CheckDepTree does,
+// except that a dependency is reported as Unchanged, without its digest being
+// computed, when the most recent modification time of the file system nodes
+// within its directory, including the directory itself, is before the
+// specified time, such as the time at which the tree was last verified. Only
+// the dependencies modified since then are verified, which makes verifying a
+// large tree repeatedly much faster. A zero time verifies every dependency.
+//
+// This trusts modification times, exactly as a TreeCache does: creating,
+// removing or renaming a node updates the modification time of its parent
+// directory, so such changes are noticed, but a file whose contents are
+// modified without updating its modification time, or whose modification
+// time is set back, is reported as Unchanged. Dependencies in the lock file
+// whose digests are empty, or of another hash version, are still reported
+// as such, because that is known without inspecting the tree.
+func CheckDepTreeSince(osDirname string, wantDigests map[string]VersionedDigest, since time.Time) (map[string]VendorStatus, error) {
+	checker := depTreeChecker{ctx: context.Background(), digester: NewDigester(), since: since}
+	return checker.check(osDirname, wantDigests)
+}
+
+// CheckDepTreeWithProgress verifies a dependency tree exactly as CheckDepTree
+// does, invoking the specified callback with the status of each dependency in
+// the lock file as soon as that status is known, which allows long running
+// verifications to report their progress.
+//
+// The callback is invoked from the calling goroutine, never concurrently, and
+// in a deterministic order: first for each dependency found in the tree, in
+// the order in which the tree is walked, then for each dependency not found,
+// in lexicographical order. It is not invoked for nodes that are NotInLock.
+func CheckDepTreeWithProgress(osDirname string, wantDigests map[string]VersionedDigest, onProject func(string, VendorStatus)) (map[string]VendorStatus, error) {
+	checker := depTreeChecker{
+		ctx:       context.Background(),
+		digester:  NewDigester(),
+		onProject: onProject,
+	}
+	return checker.check(osDirname, wantDigests)
+}
+
+// CheckDepTreeStream verifies a dependency tree exactly as CheckDepTree does,
+// but rather than returning the vendor status conditions, sends each of them
+// to the specified channel as soon as it is known, so that a consumer is
+// able to report them while the tree is still being verified. The channel is
+// closed once the tree has been verified, or verification has failed, in
+// which case the error is returned once the channel is closed. The statuses
+// sent before the channel is closed are exactly those CheckDepTree would
+// return, each sent once.
+//
+// Every send blocks until the status is received. Statuses are sent in a
+// deterministic order: first the status of each dependency found in the
+// tree, in the order in which the tree is walked, then the status of each
+// dependency not found, in lexicographical order, and finally each node that
+// is NotInLock, in lexicographical order, which are only known once the whole
+// tree has been walked.
+func CheckDepTreeStream(osDirname string, wantDigests map[string]VersionedDigest, out chan<- ProjectStatus) error {
+	defer close(out)
+
+	checker := depTreeChecker{
+		ctx:      context.Background(),
+		digester: NewDigester(),
+		onProject: func(slashPathname string, ls VendorStatus) {
+			out <- ProjectStatus{Path: slashPathname, Status: ls}
+		},
+		reportNotInLock: true,
+	}
+	_, err := checker.check(osDirname, wantDigests)
+	return err
+}
+
+// CheckDepTreeCollectErrors verifies a dependency tree exactly as CheckDepTree
+// does, except that rather than failing on the first file system node that
+// cannot be read, it continues with the remaining nodes, and returns every
+// error encountered along with the vendor status conditions.
+//
+// Each locked dependency that cannot be read, or which is beneath a directory
+// that cannot be read, is reported as DigestMismatchInLock, because it cannot
+// be shown to match its expected digest. Nodes that cannot be read and are not
+// in the lock file are left out of the statuses. Errors that prevent the tree
+// from being verified at all, such as the tree not being a directory, cause
+// nil statuses to be returned.
+func CheckDepTreeCollectErrors(osDirname string, wantDigests map[string]VersionedDigest) (map[string]VendorStatus, []error) {
+	checker := depTreeChecker{
+		ctx:           context.Background(),
+		digester:      NewDigester(),
+		collectErrors: true,
+	}
+	status, err := checker.check(osDirname, wantDigests)
+	if err != nil {
+		return nil, append(checker.errs, err)
+	}
+	return status, checker.errs
+}
+
+// DepTreeResult describes the outcome of verifying a single file system node
+// in a dependency tree.
+type DepTreeResult struct {
+	// Status is the vendor status condition of the node.
+	Status VendorStatus
+
+	// Digest is the digest computed for the node from the file system, in the
+	// same form as the expected digest it was compared against. Its Digest
+	// field is nil when no digest was computed, either because the node is
+	// not a locked dependency, or because its status was determined without
+	// hashing it, as with NotInTree, EmptyDigestInLock, HashVersionMismatch,
+	// and SymlinkInTree.
+	Digest VersionedDigest
+
+	// Files is the number of regular files within a node that is NotInLock,
+	// including the node itself when it is a regular file, and Bytes is their
+	// total size as stored, which indicate how much is in the vendor
+	// directory that the lock file does not account for. The nodes beneath
+	// skipped directories, such as VCS directories, are not counted, nor are
+	// symbolic links. Both are zero for nodes with any other status.
+	Files int
+	Bytes int64
+}
+
+// CheckDepTreeDetailed verifies a dependency tree exactly as CheckDepTree
+// does, but also returns the digest computed for each dependency, so that
+// mismatched digests can be updated without hashing the tree a second time,
+// and how many files are within each node that is NotInLock.
+func CheckDepTreeDetailed(osDirname string, wantDigests map[string]VersionedDigest) (map[string]DepTreeResult, error) {
+	checker := depTreeChecker{
+		ctx:         context.Background(),
+		digester:    NewDigester(),
+		gotDigests:  make(map[string]VersionedDigest),
+		gotContents: make(map[string]treeContents),
+	}
+	status, err := checker.check(osDirname, wantDigests)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]DepTreeResult, len(status))
+	for slashPathname, ls := range status {
+		contents := checker.gotContents[slashPathname]
+		results[slashPathname] = DepTreeResult{
+			Status: ls,
+			Digest: checker.gotDigests[slashPathname],
+			Files:  contents.files,
+			Bytes:  contents.bytes,
+		}
+	}
+	return results, nil
+}
+
+// CheckProjects verifies only the specified dependencies of the dependency tree
+// rooted at the specified directory, rather than the entire tree. Each
+// dependency receives the same status CheckDepTree would give it, or NotInTree
+// when the file system has no node for it, but unlike CheckDepTree, nothing is
+// reported about the nodes of the tree which are not listed, so that a subset
+// of a lock file's dependencies is able to be verified without the rest of the
+// tree being reported as NotInLock.
+func CheckProjects(osDirname string, wantDigests map[string]VersionedDigest) (map[string]VendorStatus, error) {
+	checker := depTreeChecker{ctx: context.Background(), digester: NewDigester()}
+
+	slashPathnames := make([]string, 0, len(wantDigests))
+	for slashPathname := range wantDigests {
+		slashPathnames = append(slashPathnames, slashPathname)
+	}
+	sort.Strings(slashPathnames)
+
+	slashStatus := make(map[string]VendorStatus, len(wantDigests))
+	for _, slashPathname := range slashPathnames {
+		osPathname := filepath.Join(osDirname, filepath.FromSlash(slashPathname))
+		fi, err := os.Lstat(osPathname)
+		if os.IsNotExist(err) {
+			slashStatus[slashPathname] = NotInTree
+			continue
+		}
+		if err != nil {
+			return nil, newDigestError("Lstat", osPathname, err)
+		}
+		ls, err := checker.projectStatus(osPathname, slashPathname, fi, wantDigests[slashPathname])
+		if err != nil {
+			return nil, err
+		}
+		slashStatus[slashPathname] = ls
+	}
+	return slashStatus, nil
+}
+
+// DigestProjects returns the digest of each of the dependencies at the
+// specified slash-separated pathnames, relative to the specified vendor
+// directory, keyed by pathname, such as to record in a new lock file. The
+// digests are computed exactly as CheckDepTree computes them to verify the
+// dependencies. When a dependency's directory does not exist, the returned
+// error matches ErrRootNotFound. A dependency whose node is not a directory,
+// such as a symbolic link, which CheckDepTree would never find to match a
+// digest, is also an error.
+func DigestProjects(osDirname string, slashPathnames []string) (map[string]VersionedDigest, error) {
+	checker := depTreeChecker{ctx: context.Background(), digester: NewDigester()}
+
+	digests := make(map[string]VersionedDigest, len(slashPathnames))
+	for _, slashPathname := range slashPathnames {
+		osPathname := filepath.Join(osDirname, filepath.FromSlash(slashPathname))
+		fi, err := os.Lstat(osPathname)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, newDigestError(opFindRoot, osPathname, err)
+			}
+			return nil, newDigestError("Lstat", osPathname, err)
+		}
+		if !fi.IsDir() {
+			return nil, errors.Errorf("cannot hash dependency %q: not a directory", slashPathname)
+		}
+		digest, err := checker.computeDigest(osPathname, fi, checker.digester.newHash)
+		if err != nil {
+			return nil, err
+		}
+		digests[slashPathname] = VersionedDigest{HashVersion: HashVersion, Digest: digest}
+	}
+	return digests, nil
+}
+
+// depTreeChecker holds the configuration used while verifying a dependency
+// tree.
+type depTreeChecker struct {
+	// ctx is checked for cancellation before each directory is inspected,
+	// and while computing the digest of each dependency.
+	ctx context.Context
+
+	// digester computes the digest of each dependency. The names it skips
+	// are also skipped while walking the tree.
+	digester *Digester
+
+	// gotDigests, when not nil, receives the digest computed for each
+	// dependency whose digest is computed.
+	gotDigests map[string]VersionedDigest
+
+	// gotContents, when not nil, receives the number of regular files within
+	// each node that is NotInLock, and their total size.
+	gotContents map[string]treeContents
+
+	// collectErrors causes errors which only prevent some nodes from being
+	// verified to be appended to errs, rather than returned.
+	collectErrors bool
+	errs          []error
+
+	// onProject, when not nil, is invoked with the status of each dependency
+	// as soon as it is known.
+	onProject func(string, VendorStatus)
+
+	// reportNotInLock causes onProject to also be invoked with each node that
+	// is NotInLock, in lexicographical order, once the tree has been walked.
+	reportNotInLock bool
+
+	// cache, when not nil, provides the digest of each dependency whose
+	// directory has not changed since its digest was last computed.
+	cache *TreeCache
+
+	// since, when not zero, causes a dependency none of whose nodes were
+	// modified at or after it to be reported as Unchanged, rather than its
+	// digest being computed.
+	since time.Time
+
+	// workers, when greater than one, is the number of dependencies whose
+	// statuses are computed concurrently, while the tree continues to be
+	// walked, rather than one at a time.
+	workers int
+
+	mu sync.Mutex // guards gotDigests when statuses are computed concurrently
+}
+
+// reportProject passes the status of the specified dependency to the progress
+// callback, if there is one.
+func (checker *depTreeChecker) reportProject(slashPathname string, ls VendorStatus) {
+	if checker.onProject != nil {
+		checker.onProject(slashPathname, ls)
+	}
+}
+
+// reportNotInTree passes the status of every dependency that was not found to
+// the progress callback, if there is one, in lexicographical order.
+func (checker *depTreeChecker) reportNotInTree(slashStatus map[string]VendorStatus) {
+	if checker.onProject == nil {
+		return
+	}
+	var missing []string
+	for slashPathname, ls := range slashStatus {
+		if ls == NotInTree {
+			missing = append(missing, slashPathname)
+		}
+	}
+	sort.Strings(missing)
+	for _, slashPathname := range missing {
+		checker.onProject(slashPathname, NotInTree)
+	}
+}
+
+// reportNotInLockNodes passes every node that is NotInLock to the progress
+// callback, in lexicographical order, when the checker reports them.
+func (checker *depTreeChecker) reportNotInLockNodes(slashStatus map[string]VendorStatus) {
+	if checker.onProject == nil || !checker.reportNotInLock {
+		return
+	}
+	var unlocked []string
+	for slashPathname, ls := range slashStatus {
+		if ls == NotInLock {
+			unlocked = append(unlocked, slashPathname)
+		}
+	}
+	sort.Strings(unlocked)
+	for _, slashPathname := range unlocked {
+		checker.onProject(slashPathname, NotInLock)
+	}
+}
+
+// digestStatus returns the vendor status condition of the dependency at the
+// specified pathname, given its expected digest, along with the digest
+// computed from the file system. The computed digest is tagged when the
+// expected digest is, and is nil when no digest was computed because the
+// expected digest is empty or was produced by a different hash version. The
+// file info of the dependency's directory, as returned by os.Lstat, is nil
+// when it has not already been obtained.
+func (checker *depTreeChecker) digestStatus(osPathname string, info os.FileInfo, expectedSum VersionedDigest) (VendorStatus, []byte, error) {
+	if expectedSum.HashVersion != HashVersion {
+		if expectedSum.IsEmpty() {
+			return EmptyDigestInLock, nil, nil
+		}
+		return HashVersionMismatch, nil, nil
+	}
+	if len(expectedSum.Digest) == 0 {
+		return EmptyDigestInLock, nil, nil
+	}
+	if !checker.since.IsZero() {
+		modTime, err := latestModTime(osPathname, info, checker.digester.walk)
+		if err != nil {
+			return 0, nil, err
+		}
+		if modTime.Before(checker.since) {
+			return Unchanged, nil, nil
+		}
+	}
+
+	newHash, wantSum := checker.digester.newHash, expectedSum.Digest
+	var tag []byte
+	if tagAlgo, untagged, ok := splitTaggedDigest(wantSum); ok {
+		// Tagged digests are trusted to name a valid algorithm.
+		newHash, _ = newHashFunc(tagAlgo)
+		wantSum = untagged
+		tag = []byte{byte(tagAlgo)}
+	}
+
+	var projectSum []byte
+	var err error
+	if checker.cache != nil {
+		projectSum, err = checker.cache.projectDigest(checker, osPathname, info, tag, newHash)
+	} else {
+		projectSum, err = checker.computeDigest(osPathname, info, newHash)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	gotSum := append(tag, projectSum...)
+	// The digests are compared in constant time, so that the time taken does
+	// not reveal how much of them match. Only their lengths, which are not
+	// secret, are compared first.
+	if len(projectSum) == len(wantSum) && subtle.ConstantTimeCompare(projectSum, wantSum) == 1 {
+		return NoMismatch, gotSum, nil
+	}
+	empty, err := checker.isEmptyTree(osPathname, info)
+	if err != nil {
+		return 0, nil, err
+	}
+	if empty {
+		return EmptyTreeInLock, gotSum, nil
+	}
+	return DigestMismatchInLock, gotSum, nil
+}
+
+// errTreeNotEmpty stops the walk of isEmptyTree at the first node found.
+var errTreeNotEmpty = errors.New("tree is not empty")
+
+// isEmptyTree reports whether the dependency at the specified pathname holds
+// no file system nodes which are hashed, other than its own directory. The
+// file info of the dependency's directory, as returned by os.Lstat, is nil
+// when it has not already been obtained.
+func (checker *depTreeChecker) isEmptyTree(osPathname string, info os.FileInfo) (bool, error) {
+	err := walkDigestEntriesFrom(osPathname, info, checker.digester.walk, func(entry digestEntry) error {
+		if entry.osRelative != "" {
+			return errTreeNotEmpty
+		}
+		return nil
+	})
+	if err == errTreeNotEmpty {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// computeDigest returns the digest of the dependency at the specified
+// pathname, computed with a hash returned by the specified function. The file
+// info of the dependency's directory, as returned by os.Lstat, is nil when it
+// has not already been obtained.
+func (checker *depTreeChecker) computeDigest(osPathname string, info os.FileInfo, newHash func() hash.Hash) ([]byte, error) {
+	closure := checker.digester.newClosure(newHash())
+	defer closure.release()
+	closure.ctx = checker.ctx
+
+	return closure.digestFrom(osPathname, info)
+}
+
+// check verifies the dependency tree rooted at the specified directory
+// according to the expected digest sums.
+func (checker *depTreeChecker) check(osDirname string, wantDigests map[string]VersionedDigest) (map[string]VendorStatus, error) {
 	osDirname = filepath.Clean(osDirname)
 
 	// Create associative array to store the results of calling this function.
 	slashStatus := make(map[string]VendorStatus)
 
 	// Ensure top level pathname is a directory
-	fi, err := os.Stat(osDirname)
+	fi, err := checker.digester.walk.stat(osDirname)
 	if err != nil {
 		// If the dir doesn't exist at all, that's OK - just consider all the
 		// wanted paths absent.
@@ -388,9 +2552,10 @@ func CheckDepTree(osDirname string, wantDigests map[string]VersionedDigest) (map
 			for path := range wantDigests {
 				slashStatus[path] = NotInTree
 			}
+			checker.reportNotInTree(slashStatus)
 			return slashStatus, nil
 		}
-		return nil, errors.Wrap(err, "cannot Stat")
+		return nil, newDigestError("Stat", osDirname, err)
 	}
 
 	if !fi.IsDir() {
@@ -400,7 +2565,7 @@ func CheckDepTree(osDirname string, wantDigests map[string]VersionedDigest) (map
 	// Initialize work queue with a node representing the specified directory
 	// name by declaring its relative pathname under the directory name as the
 	// empty string.
-	currentNode := &fsnode{osRelative: "", parentIndex: -1, isRequiredAncestor: true}
+	currentNode := &fsnode{osRelative: "", parentIndex: -1, isRequiredAncestor: true, info: fi}
 	queue := []*fsnode{currentNode} // queue of directories that must be inspected
 
 	// In order to identify all file system nodes that are not in the lock file,
@@ -448,6 +2613,16 @@ func CheckDepTree(osDirname string, wantDigests map[string]VersionedDigest) (map
 		slashStatus[slashPathname] = NotInTree
 	}
 
+	// When computing statuses concurrently, they are resolved in the order in
+	// which the dependencies were found once the walk is complete, so that
+	// the result, including which error is returned, is identical to that of
+	// computing them one at a time.
+	var pool *projectPool
+	if checker.workers > 1 {
+		pool = checker.startProjectPool()
+		defer pool.stop()
+	}
+
 	for len(queue) > 0 {
 		// Pop node from the top of queue (depth first traversal, reverse
 		// lexicographical order inside a directory), clearing the value stored
@@ -457,24 +2632,19 @@ func CheckDepTree(osDirname string, wantDigests map[string]VersionedDigest) (map
 		slashPathname := filepath.ToSlash(currentNode.osRelative)
 		osPathname := filepath.Join(osDirname, currentNode.osRelative)
 
+		if err := checker.ctx.Err(); err != nil {
+			return nil, err
+		}
+
 		if expectedSum, ok := wantDigests[slashPathname]; ok {
-			ls := EmptyDigestInLock
-			if expectedSum.HashVersion != HashVersion {
-				if !expectedSum.IsEmpty() {
-					ls = HashVersionMismatch
-				}
-			} else if len(expectedSum.Digest) > 0 {
-				projectSum, err := DigestFromDirectory(osPathname)
-				if err != nil {
-					return nil, errors.Wrap(err, "cannot compute dependency hash")
-				}
-				if bytes.Equal(projectSum.Digest, expectedSum.Digest) {
-					ls = NoMismatch
-				} else {
-					ls = DigestMismatchInLock
+			if pool != nil {
+				pool.submit(&projectJob{osPathname: osPathname, slashPathname: slashPathname, info: currentNode.lstatInfo, expectedSum: expectedSum})
+			} else {
+				ls, err := checker.projectStatus(osPathname, slashPathname, currentNode.lstatInfo, expectedSum)
+				if err = checker.resolveProject(slashStatus, slashPathname, ls, err); err != nil {
+					return nil, err
 				}
 			}
-			slashStatus[slashPathname] = ls
 
 			// Mark current nodes and all its parents as required.
 			for i := currentNode.myIndex; i != -1; i = nodes[i].parentIndex {
@@ -486,13 +2656,17 @@ func CheckDepTree(osDirname string, wantDigests map[string]VersionedDigest) (map
 			continue
 		}
 
-		osChildrenNames, err := sortedChildrenFromDirname(osPathname)
+		children, err := checker.digester.walk.dirChildren(osPathname)
 		if err != nil {
-			return nil, errors.Wrap(err, "cannot get sorted list of directory children")
+			if err = checker.collect(err); err != nil {
+				return nil, err
+			}
+			markUnverifiable(slashStatus, slashPathname, nodes, currentNode.myIndex)
+			continue
 		}
-		for _, osChildName := range osChildrenNames {
-			switch osChildName {
-			case ".", "..", "vendor", ".bzr", ".git", ".hg", ".svn":
+		for _, child := range children {
+			switch osChildName := child.Name(); {
+			case osChildName == ".", osChildName == "..", checker.digester.walk.skipDirs[osChildName]:
 				// skip
 			default:
 				osChildRelative := filepath.Join(currentNode.osRelative, osChildName)
@@ -502,18 +2676,95 @@ func CheckDepTree(osDirname string, wantDigests map[string]VersionedDigest) (map
 				// index set to the index of the current node.
 				otherNode := &fsnode{osRelative: osChildRelative, myIndex: len(nodes), parentIndex: currentNode.myIndex}
 
-				fi, err := os.Stat(osChildPathname)
+				// A locked project whose node is a symbolic link, whether
+				// or not its referent exists, is queued so it can be
+				// reported as such. The file info of a locked project is
+				// kept so that it need not be obtained again while
+				// computing its status and digest.
+				var fi os.FileInfo
+				if _, ok := wantDigests[filepath.ToSlash(osChildRelative)]; ok {
+					lfi, err := child.Info()
+					if err != nil {
+						if err = checker.collect(newDigestError("Lstat", osChildPathname, err)); err != nil {
+							return nil, err
+						}
+						markUnverifiable(slashStatus, filepath.ToSlash(osChildRelative), nodes, currentNode.myIndex)
+						continue
+					}
+					otherNode.lstatInfo = lfi
+					if lfi.Mode()&os.ModeSymlink == 0 {
+						fi = lfi // no link to follow, so os.Stat would return the same
+					} else if !checker.digester.walk.followSymlinks {
+						nodes = append(nodes, otherNode)
+						queue = append(queue, otherNode)
+						continue
+					}
+				}
+
+				// Only directories are inspected further, and the type of a
+				// node which is not a symbolic link is known from listing
+				// its directory, so only symbolic links must be followed.
+				op := "Lstat"
+				if fi == nil {
+					switch typ := child.Type(); {
+					case typ&os.ModeSymlink != 0:
+						op = "Stat"
+						fi, err = checker.digester.walk.stat(osChildPathname)
+					case typ.IsDir():
+						fi, err = child.Info()
+					case typ.IsRegular() && checker.gotContents != nil:
+						if fi, err = child.Info(); err == nil {
+							otherNode.contents = treeContents{files: 1, bytes: fi.Size()}
+						}
+					default:
+						nodes = append(nodes, otherNode)
+						continue
+					}
+				}
 				if err != nil {
-					return nil, errors.Wrap(err, "cannot Stat")
+					if err = checker.collect(newDigestError(op, osChildPathname, err)); err != nil {
+						return nil, err
+					}
+					markUnverifiable(slashStatus, filepath.ToSlash(osChildRelative), nodes, currentNode.myIndex)
+					continue
+				}
+				if fi.IsDir() {
+					// A directory can only be its own ancestor when it is
+					// reached through a symbolic link, which would otherwise
+					// cause the tree to be walked forever.
+					if fi, err = checker.digester.walk.comparable(osChildPathname, fi); err == nil {
+						err = checkAncestors(&checker.digester.walk, nodes, currentNode.myIndex, fi, osDirname, osChildPathname)
+					} else {
+						err = newDigestError("Stat", osChildPathname, err)
+					}
+					if err != nil {
+						if err = checker.collect(err); err != nil {
+							return nil, err
+						}
+						markUnverifiable(slashStatus, filepath.ToSlash(osChildRelative), nodes, currentNode.myIndex)
+						continue
+					}
 				}
 				nodes = append(nodes, otherNode) // Track all file system nodes...
 				if fi.IsDir() {
+					otherNode.info = fi
 					queue = append(queue, otherNode) // but only need to add directories to the work queue.
 				}
 			}
 		}
 	}
 
+	if pool != nil {
+		pool.wait()
+		for _, job := range pool.jobs {
+			if err := checker.resolveProject(slashStatus, job.slashPathname, job.ls, job.err); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	checker.reportNotInTree(slashStatus)
+
 	// Ignoring first node in the list, walk nodes from last to first. Whenever
 	// the current node is not required, but its parent is required, then the
 	// current node ought to be marked as `NotInLock`.
@@ -525,31 +2776,127 @@ func CheckDepTree(osDirname string, wantDigests map[string]VersionedDigest) (map
 
 		if !currentNode.isRequiredAncestor && nodes[currentNode.parentIndex].isRequiredAncestor {
 			slashStatus[filepath.ToSlash(currentNode.osRelative)] = NotInLock
+			if checker.gotContents != nil {
+				checker.gotContents[filepath.ToSlash(currentNode.osRelative)] = currentNode.contents
+			}
 		}
+
+		// Descendants always follow their ancestors in the slice, so each
+		// node's contents are complete by the time it is popped.
+		parent := nodes[currentNode.parentIndex]
+		parent.contents.files += currentNode.contents.files
+		parent.contents.bytes += currentNode.contents.bytes
 	}
 	currentNode, nodes = nil, nil
 
+	checker.reportNotInLockNodes(slashStatus)
 	return slashStatus, nil
 }
 
-// sortedChildrenFromDirname returns a lexicographically sorted list of child
-// nodes for the specified directory.
-func sortedChildrenFromDirname(osDirname string) ([]string, error) {
-	fh, err := os.Open(osDirname)
+// projectStatus returns the vendor status condition of the locked dependency
+// at the specified pathname, given its expected digest. The file info of the
+// dependency's node, as returned by os.Lstat, is nil when it has not already
+// been obtained.
+func (checker *depTreeChecker) projectStatus(osPathname, slashPathname string, fi os.FileInfo, expectedSum VersionedDigest) (VendorStatus, error) {
+	if fi == nil {
+		var err error
+		if fi, err = checker.digester.walk.lstat(osPathname); err != nil {
+			return 0, newDigestError("Lstat", osPathname, err)
+		}
+	}
+	if fi.Mode()&os.ModeSymlink != 0 && !checker.digester.walk.followSymlinks {
+		return SymlinkInTree, nil
+	}
+
+	ls, gotSum, err := checker.digestStatus(osPathname, fi, expectedSum)
 	if err != nil {
-		return nil, errors.Wrap(err, "cannot Open")
+		if ctxErr := checker.ctx.Err(); ctxErr != nil {
+			return 0, ctxErr
+		}
+		return 0, newDigestError("compute dependency hash", osPathname, err)
+	}
+	if ls == EmptyDigestInLock && checker.digester.rejectEmptyDigests {
+		return 0, errors.Errorf("cannot verify %q: digest in lock is empty", slashPathname)
+	}
+	if checker.gotDigests != nil && gotSum != nil {
+		checker.mu.Lock()
+		checker.gotDigests[slashPathname] = VersionedDigest{
+			HashVersion: HashVersion,
+			Digest:      gotSum,
+		}
+		checker.mu.Unlock()
 	}
+	return ls, nil
+}
 
-	osChildrenNames, err := fh.Readdirnames(0) // 0: read names of all children
+// resolveProject records the status of the locked dependency at the specified
+// pathname, as computed by projectStatus, and reports it to the progress
+// callback. When the status could not be computed, the dependency is recorded
+// as DigestMismatchInLock if the error is collected, and otherwise the error
+// is returned.
+func (checker *depTreeChecker) resolveProject(slashStatus map[string]VendorStatus, slashPathname string, ls VendorStatus, err error) error {
 	if err != nil {
-		return nil, errors.Wrap(err, "cannot Readdirnames")
+		if err = checker.collect(err); err != nil {
+			return err
+		}
+		ls = DigestMismatchInLock // cannot be verified
+	}
+	slashStatus[slashPathname] = ls
+	checker.reportProject(slashPathname, ls)
+	return nil
+}
+
+// collect records the specified error and returns nil when the checker
+// collects errors, so that verification continues. Otherwise, or when the
+// checker's context was cancelled, it returns the error.
+func (checker *depTreeChecker) collect(err error) error {
+	if !checker.collectErrors || checker.ctx.Err() != nil {
+		return err
 	}
-	sort.Strings(osChildrenNames)
+	checker.errs = append(checker.errs, err)
+	return nil
+}
 
-	// Close the file handle to the open directory without masking possible
-	// previous error value.
-	if er := fh.Close(); err == nil {
-		err = errors.Wrap(er, "cannot Close")
+// markUnverifiable sets the status of every dependency at or beneath the
+// specified pathname, which could not be inspected, to DigestMismatchInLock.
+// When there are any such dependencies, the node at the specified parent index
+// and all its ancestors are marked as required.
+func markUnverifiable(slashStatus map[string]VendorStatus, slashPathname string, nodes []*fsnode, parentIndex int) {
+	var found bool
+	for slashDependency, ls := range slashStatus {
+		if ls != NotInTree {
+			continue
+		}
+		if slashPathname == "" || slashDependency == slashPathname || strings.HasPrefix(slashDependency, slashPathname+"/") {
+			slashStatus[slashDependency] = DigestMismatchInLock
+			found = true
+		}
 	}
-	return osChildrenNames, err
+	if found {
+		for i := parentIndex; i != -1; i = nodes[i].parentIndex {
+			nodes[i].isRequiredAncestor = true
+		}
+	}
+}
+
+// checkAncestors returns an error when the specified directory, found beneath
+// the node at the specified parent index, is the same as one of its ancestors
+// in the file system of the specified walk options.
+func checkAncestors(opts *walkOptions, nodes []*fsnode, parentIndex int, fi os.FileInfo, osDirname, osPathname string) error {
+	for i := parentIndex; i != -1; i = nodes[i].parentIndex {
+		if opts.sameFile(nodes[i].info, fi) {
+			return errors.Errorf("cannot verify symlink cycle: %q refers to its ancestor %q", osPathname, filepath.Join(osDirname, nodes[i].osRelative))
+		}
+	}
+	return nil
+}
+
+// dirChild describes a node listed in a directory by sortedDirChildren, as
+// os.DirEntry does on the versions of Go which provide it. Type returns only
+// the type bits of the node's mode, and Info returns the node's file info as
+// os.Lstat would.
+type dirChild interface {
+	Name() string
+	Type() os.FileMode
+	Info() (os.FileInfo, error)
 }
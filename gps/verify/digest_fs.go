@@ -0,0 +1,81 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build go1.16
+
+package verify
+
+import (
+	"crypto/sha256"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// DigestFromFS returns a hash of the contents of the specified directory of
+// the specified file system, such as an embed.FS, or a zip archive opened with
+// archive/zip, which is identical to the hash DigestFromDirectory returns for
+// a directory on disk with the same contents. The hash version is HashVersion.
+//
+// The directory is walked in the same order, and the same nodes are skipped.
+// Symbolic links are ignored entirely, just as they are by
+// DigestFromDirectory, although many fs.FS implementations never report them;
+// those which instead report the referent of a link produce a hash that
+// includes it.
+func DigestFromFS(fsys fs.FS, root string) ([]byte, error) {
+	root = path.Clean(root)
+	prefix := root + "/"
+	if root == "." {
+		prefix = ""
+	}
+
+	closure := newDirWalkClosure(sha256.New())
+	defer closure.release()
+
+	err := fs.WalkDir(fsys, root, func(slashPathname string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Completely ignore symlinks.
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		var slashRelative string
+		if slashPathname != root {
+			slashRelative = strings.TrimPrefix(slashPathname, prefix)
+		}
+
+		if skip, err := closure.walk.skipNode(slashRelative, d.IsDir()); skip {
+			return err
+		}
+
+		mt, isRegular := digestModeType(os.FileMode(d.Type()))
+		closure.writeEntry(digestEntry{osRelative: slashRelative, modeType: mt, isRegular: isRegular})
+		if !isRegular {
+			return nil
+		}
+
+		fh, err := fsys.Open(slashPathname)
+		if err != nil {
+			return newDigestError("Open", slashPathname, err)
+		}
+		written, err := closure.writeContents(fh)
+		err = newCopyError(slashPathname, written, err)
+
+		// Close the file handle to the open file without masking
+		// possible previous error value.
+		if er := fh.Close(); err == nil {
+			err = newDigestError("Close", slashPathname, er)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return closure.someHash.Sum(nil), nil
+}
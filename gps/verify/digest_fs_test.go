@@ -0,0 +1,50 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build go1.16
+
+package verify
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestDigestFromFS(t *testing.T) {
+	files := map[string]string{
+		"a.go":                         "package a\r\n",
+		"sub/b.go":                     "package sub\n",
+		"sub/empty.txt":                "",
+		".git/HEAD":                    "ref: refs/heads/master\n",
+		"vendor/github.com/bob/b/b.go": "package b\n",
+	}
+	dir := mkTestTree(t, files)
+	defer os.RemoveAll(dir)
+
+	fsys := fstest.MapFS{}
+	for slashPathname, contents := range files {
+		fsys["project/"+slashPathname] = &fstest.MapFile{Data: []byte(contents)}
+	}
+
+	for _, root := range []string{"project", "project/sub"} {
+		got, err := DigestFromFS(fsys, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := DigestFromDirectory(filepath.Join(dir, filepath.FromSlash(root[len("project"):])))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want.Digest) {
+			t.Errorf("%s:\n(GOT):\n\t%x\n(WNT):\n\t%x", root, got, want.Digest)
+		}
+	}
+
+	if _, err := DigestFromFS(fsys, "missing"); err == nil {
+		t.Error("expected error for missing root")
+	}
+}
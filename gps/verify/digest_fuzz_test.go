@@ -0,0 +1,81 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package verify
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// chunkingReader is a test io.Reader that returns the bytes of its source in
+// chunks of pseudo-random sizes, including empty chunks, though never two of
+// them in a row.
+type chunkingReader struct {
+	src      []byte
+	rnd      *rand.Rand
+	wasEmpty bool // true iff the previous chunk was empty
+}
+
+func (cr *chunkingReader) Read(buf []byte) (int, error) {
+	if len(cr.src) == 0 {
+		return 0, io.EOF
+	}
+	n := cr.rnd.Intn(len(cr.src) + 1)
+	if n == 0 && cr.wasEmpty {
+		n = 1
+	}
+	cr.wasEmpty = n == 0
+	if n > len(buf) {
+		n = len(buf)
+	}
+	n = copy(buf, cr.src[:n])
+	cr.src = cr.src[n:]
+	return n, nil
+}
+
+func FuzzLineEndingReader(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"\r",
+		"\r\n",
+		"a\r\n\r\nb",
+		"now is the time\r\nfor all good engineers\r",
+		"\r\r\n\n\r",
+	} {
+		f.Add([]byte(seed), int64(1))
+	}
+
+	f.Fuzz(func(t *testing.T, input []byte, seed int64) {
+		want := bytes.ReplaceAll(input, []byte("\r\n"), []byte("\n"))
+
+		rnd := rand.New(rand.NewSource(seed))
+		ler := newLineEndingReader(&chunkingReader{src: input, rnd: rnd})
+		var got []byte
+		var err error
+		for reads := 0; err == nil; reads++ {
+			// Every Read either consumes or emits a byte, except those of
+			// empty chunks, which are no more than one more than the
+			// others.
+			if reads > 4*len(input)+4 {
+				t.Fatalf("Input: %q; stalled after %q", input, got)
+			}
+			buf := make([]byte, 1+rnd.Intn(len(input)+1))
+			var n int
+			n, err = ler.Read(buf)
+			got = append(got, buf[:n]...)
+		}
+		if err != io.EOF {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("Input: %q; (GOT): %q; (WNT): %q", input, got, want)
+		}
+	})
+}
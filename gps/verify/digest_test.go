@@ -6,10 +6,25 @@ package verify
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
 )
 
 // crossBuffer is a test io.Reader that emits a few canned responses.
@@ -90,6 +105,318 @@ func TestLineEndingReader(t *testing.T) {
 	}
 }
 
+func TestLineEndingReaderConsecutiveCRLF(t *testing.T) {
+	// reference collapses CRLF sequences one byte at a time.
+	reference := func(input string) string {
+		var out []byte
+		for i := 0; i < len(input); i++ {
+			if input[i] == '\r' && i+1 < len(input) && input[i+1] == '\n' {
+				continue
+			}
+			out = append(out, input[i])
+		}
+		return string(out)
+	}
+
+	inputs := []string{
+		"a\r\n\r\nb",
+		"\r\n\r\n\r\n",
+		"\r\n\r\n\r\n\r\n\r\n\r\n\r\n\r\n",
+		"a\r\nb\r\nc\r\nd",
+		"\r\r\n\r\n\r",
+		"\n\r\n\r\n\n",
+		"\r\n\n\r\n\r\r\n",
+		"ab\r\n\r\ncd\r\n\r\n\r\nef",
+	}
+	// Also try every combination of up to eight CR, LF, and other bytes.
+	alphabet := "a\r\n"
+	for length := 1; length <= 8; length++ {
+		combination := make([]byte, length)
+		var generate func(int)
+		generate = func(i int) {
+			if i == length {
+				inputs = append(inputs, string(combination))
+				return
+			}
+			for j := 0; j < len(alphabet); j++ {
+				combination[i] = alphabet[j]
+				generate(i + 1)
+			}
+		}
+		generate(0)
+	}
+
+	for _, input := range inputs {
+		// A single Read with room for the entire input.
+		buf := make([]byte, len(input)+1)
+		n, err := newLineEndingReader(strings.NewReader(input)).Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, want := string(buf[:n]), reference(input)
+		// Only a trailing CR is held back until the next Read.
+		if strings.HasSuffix(input, "\r") {
+			want = want[:len(want)-1]
+		}
+		if got != want {
+			t.Errorf("Input: %q; (GOT): %q; (WNT): %q", input, got, want)
+		}
+
+		// The input split across two Reads at every offset.
+		for split := 0; split <= len(input); split++ {
+			got := string(streamThruLineEndingReader(t, []string{input[:split], input[split:]}))
+			if want := reference(input); got != want {
+				t.Errorf("Input: %q split at %d; (GOT): %q; (WNT): %q", input, split, got, want)
+			}
+		}
+	}
+}
+
+// stutteringReader is a test io.Reader that returns nothing, and no error,
+// before every byte it reads from its source.
+type stutteringReader struct {
+	src     io.Reader
+	stutter bool
+}
+
+func (sr *stutteringReader) Read(buf []byte) (int, error) {
+	if sr.stutter = !sr.stutter; sr.stutter {
+		return 0, nil
+	}
+	if len(buf) > 1 {
+		buf = buf[:1]
+	}
+	return sr.src.Read(buf)
+}
+
+func TestTrailingWhitespaceReader(t *testing.T) {
+	entries := []struct {
+		input, want string
+	}{
+		{"", ""},
+		{"\n", "\n"},
+		{" \n", "\n"},
+		{"a \t \nb\t\n", "a\nb\n"},
+		{"a b\n", "a b\n"},
+		{"  indented\n", "  indented\n"},
+		{"ends with spaces  ", "ends with spaces  "},
+		{"a \r\n", "a \r\n"},
+		{"a   \n\n   \n", "a\n\n\n"},
+		{strings.Repeat(" ", 10000) + "x \n", strings.Repeat(" ", 10000) + "x\n"},
+	}
+	sources := []struct {
+		name string
+		wrap func(io.Reader) io.Reader
+	}{
+		{"reader", func(r io.Reader) io.Reader { return r }},
+		{"one byte", iotest.OneByteReader},
+		{"data with EOF", iotest.DataErrReader},
+		{"stuttering", func(r io.Reader) io.Reader { return &stutteringReader{src: r} }},
+	}
+
+	for _, source := range sources {
+		// Runs of whitespace straddle the boundary between reads when the
+		// buffer is small.
+		for _, bufSize := range []int{1, 2, 3, 4096} {
+			for _, entry := range entries {
+				twr := &trailingWhitespaceReader{src: source.wrap(strings.NewReader(entry.input))}
+				var got []byte
+				buf := make([]byte, bufSize)
+				var err error
+				for reads := 0; err == nil; reads++ {
+					if reads > 4*len(entry.input)+4 {
+						t.Fatalf("%s, buffer of %d: Input: %q; stalled after %q", source.name, bufSize, entry.input, got)
+					}
+					var n int
+					n, err = twr.Read(buf)
+					got = append(got, buf[:n]...)
+				}
+				if err != io.EOF {
+					t.Errorf("%s, buffer of %d: Input: %q; (GOT): %v; (WNT): %v", source.name, bufSize, entry.input, err, io.EOF)
+				}
+				if string(got) != entry.want {
+					t.Errorf("%s, buffer of %d: Input: %q; (GOT): %q; (WNT): %q", source.name, bufSize, entry.input, got, entry.want)
+				}
+			}
+		}
+	}
+}
+
+func TestLineEndingReaderTinyBuffers(t *testing.T) {
+	inputs := []string{
+		"",
+		"\r",
+		"\n",
+		"\r\n",
+		"\r\r",
+		"\r\r\n",
+		"\r\n\r\n",
+		"a\r\nb",
+		"a\rb",
+		"a\r\rb\r\n",
+		"now is the time\r\nfor all\r good engineers\r\r\n\r\n",
+		"ends with CR\r",
+	}
+	sources := []struct {
+		name string
+		wrap func(io.Reader) io.Reader
+	}{
+		{"reader", func(r io.Reader) io.Reader { return r }},
+		{"one byte", iotest.OneByteReader},
+		{"data with EOF", iotest.DataErrReader},
+		{"stuttering", func(r io.Reader) io.Reader { return &stutteringReader{src: r} }},
+	}
+
+	for _, convertLoneCR := range []bool{false, true} {
+		for _, source := range sources {
+			for _, bufSize := range []int{1, 2, 3} {
+				for _, input := range inputs {
+					want := strings.Replace(input, "\r\n", "\n", -1)
+					if convertLoneCR {
+						want = strings.Replace(want, "\r", "\n", -1)
+					}
+
+					ler := newLineEndingReader(source.wrap(strings.NewReader(input)))
+					ler.convertLoneCR = convertLoneCR
+					var got []byte
+					buf := make([]byte, bufSize)
+					var err error
+					// Every Read that makes progress either emits a byte or
+					// consumes one, so this bounds the number of Reads in
+					// the absence of a stall.
+					for reads := 0; err == nil; reads++ {
+						if reads > 4*len(input)+4 {
+							t.Fatalf("%s, buffer of %d, lone CR conversion %v: Input: %q; stalled after %q", source.name, bufSize, convertLoneCR, input, got)
+						}
+						var n int
+						n, err = ler.Read(buf)
+						got = append(got, buf[:n]...)
+					}
+					if err != io.EOF {
+						t.Fatal(err)
+					}
+					if string(got) != want {
+						t.Errorf("%s, buffer of %d, lone CR conversion %v: Input: %q; (GOT): %q; (WNT): %q", source.name, bufSize, convertLoneCR, input, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestLineEndingReaderConvertingLoneCR(t *testing.T) {
+	testCases := []struct {
+		input  []string
+		output string
+	}{
+		{[]string{"\r"}, "\n"},
+		{[]string{"\r\n"}, "\n"},
+		{[]string{"\r\r"}, "\n\n"},
+		{[]string{"\rnow is the time\r"}, "\nnow is the time\n"},
+
+		// CR at end of buffer, followed by LF on the next Read
+		{[]string{"first\r", "\nsecond"}, "first\nsecond"},
+
+		// CR at end of buffer, followed by non-LF on the next Read
+		{[]string{"first\r", "second"}, "first\nsecond"},
+		{[]string{"first\r", "\rsecond\r"}, "first\n\nsecond\n"},
+
+		// mixed CRLF and CR, within and across Reads
+		{[]string{"one\r\ntwo\rthree\r\r\nfour"}, "one\ntwo\nthree\n\nfour"},
+		{[]string{"one\r", "\ntwo\r", "three\r\n", "four\r"}, "one\ntwo\nthree\nfour\n"},
+	}
+
+	for _, testCase := range testCases {
+		dst := new(bytes.Buffer)
+		ler := newLineEndingReader(&crossBuffer{iterations: testCase.input})
+		ler.convertLoneCR = true
+		if _, err := io.Copy(dst, ler); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := dst.Bytes(), []byte(testCase.output); !bytes.Equal(got, want) {
+			t.Errorf("Input: %#v; (GOT): %#q; (WNT): %#q", testCase.input, got, want)
+		}
+	}
+}
+
+func TestDigestFromDirectoryConvertingLoneCR(t *testing.T) {
+	var digests [][]byte
+	for _, contents := range []string{"one\ntwo\n", "one\r\ntwo\r\n", "one\rtwo\r"} {
+		dir := mkTestTree(t, map[string]string{"f.txt": contents})
+		defer os.RemoveAll(dir)
+
+		digest, err := DigestFromDirectoryConvertingLoneCR(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests = append(digests, digest)
+	}
+	for i := 1; i < len(digests); i++ {
+		if !bytes.Equal(digests[i], digests[0]) {
+			t.Errorf("%d: (GOT): %x; (WNT): %x", i, digests[i], digests[0])
+		}
+	}
+}
+
+func TestBOMStrippingReader(t *testing.T) {
+	testCases := []struct {
+		input  []string
+		output string
+	}{
+		{nil, ""},
+		{[]string{"\xEF\xBB\xBF"}, ""},
+		{[]string{"\xEF\xBB\xBFpackage a\n"}, "package a\n"},
+		{[]string{"\xEF", "\xBB", "\xBFpackage a\n"}, "package a\n"}, // BOM straddles Reads
+		{[]string{"\xEF\xBB"}, "\xEF\xBB"},                           // truncated BOM ought to convey
+		{[]string{"ab"}, "ab"},
+		{[]string{"package a\n", "\xEF\xBB\xBF"}, "package a\n\xEF\xBB\xBF"}, // only a leading BOM is removed
+		{[]string{"\xEF\xBB\xBF\xEF\xBB\xBF"}, "\xEF\xBB\xBF"},
+	}
+
+	for _, testCase := range testCases {
+		// Unlike crossBuffer, MultiReader does not discard the remainder of an
+		// iteration that does not fit in the buffer passed to Read.
+		var readers []io.Reader
+		for _, iteration := range testCase.input {
+			readers = append(readers, strings.NewReader(iteration))
+		}
+		got, err := ioutil.ReadAll(&bomStrippingReader{src: io.MultiReader(readers...)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []byte(testCase.output); !bytes.Equal(got, want) {
+			t.Errorf("Input: %#v; (GOT): %#q; (WNT): %#q", testCase.input, got, want)
+		}
+	}
+}
+
+func TestDigestFromDirectoryStrippingBOM(t *testing.T) {
+	withBOM := mkTestTree(t, map[string]string{"a.go": "\xEF\xBB\xBFpackage a\r\n"})
+	defer os.RemoveAll(withBOM)
+	withoutBOM := mkTestTree(t, map[string]string{"a.go": "package a\n"})
+	defer os.RemoveAll(withoutBOM)
+
+	got, err := DigestFromDirectoryStrippingBOM(withBOM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := DigestFromDirectory(withoutBOM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want.Digest) {
+		t.Errorf("\n(GOT):\n\t%#v\n(WNT):\n\t%#v", got, want.Digest)
+	}
+
+	dflt, err := DigestFromDirectory(withBOM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(dflt.Digest, want.Digest) {
+		t.Error("Expected BOM to change the digest by default")
+	}
+}
+
 ////////////////////////////////////////
 
 func getTestdataVerifyRoot(t *testing.T) string {
@@ -139,6 +466,288 @@ func TestDigestFromDirectory(t *testing.T) {
 	})
 }
 
+// TestDigestFromDirectoryGolden locks the order and framing in which nodes are
+// written to the hash, as documented by DigestFromDirectory, because digests
+// recorded in lock files must never change for the same HashVersion. When
+// this test fails, the change which caused it breaks every existing digest.
+func TestDigestFromDirectoryGolden(t *testing.T) {
+	osDirname := filepath.Join("testdata", "golden")
+	const want = "b28cce3e4360973ae1298d591478e0e099a9401424e51d522e94059fce01c7d4"
+
+	// Every node but the nested vendor directory, in the order hashed.
+	var seen []string
+	_, err := NewDigester(WithWalkFunc(func(slashRelative string, info os.FileInfo) error {
+		seen = append(seen, slashRelative)
+		return nil
+	})).Digest(osDirname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSeen := []string{"", "B.txt", "README.md", "a", "a/b.go", "a/deeper", "a/deeper/d.txt", "a.b", "a.b/c.go", "a.go", "empty.txt"}
+	if !reflect.DeepEqual(seen, wantSeen) {
+		t.Errorf("order: (GOT): %q; (WNT): %q", seen, wantSeen)
+	}
+
+	// The same digest follows from the documented framing of each node.
+	dir := func(slashRelative string) string { return slashRelative + "\x00\x00\x00\x00\x80\x00" }
+	file := func(slashRelative, contents string) string {
+		return fmt.Sprintf("%s\x00\x00\x00\x00\x00\x00%s%d\x00", slashRelative, contents, len(contents))
+	}
+	stream := dir("") +
+		file("B.txt", "uppercase names sort before lowercase ones\n") +
+		file("README.md", "golden fixture\n") +
+		dir("a") +
+		file("a/b.go", "package a\n") +
+		dir("a/deeper") +
+		file("a/deeper/d.txt", "deeper\n") +
+		dir("a.b") +
+		file("a.b/c.go", "package c\n") +
+		file("a.go", "package golden\n") +
+		file("empty.txt", "")
+	framed := sha256.Sum256([]byte(stream))
+
+	got, err := DigestFromDirectory(osDirname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := hex.EncodeToString(got.Digest); g != want {
+		t.Errorf("(GOT): %s; (WNT): %s", g, want)
+	}
+	if g, w := hex.EncodeToString(framed[:]), want; g != w {
+		t.Errorf("framing: (GOT): %s; (WNT): %s", g, w)
+	}
+}
+
+func TestDigestFromDirectoryWithHash(t *testing.T) {
+	osDirname := filepath.Join(getTestdataVerifyRoot(t), "launchpad.net/match")
+
+	want, err := DigestFromDirectory(osDirname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("SHA256", func(t *testing.T) {
+		got, err := DigestFromDirectoryWithHash(osDirname, sha256.New)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want.Digest) {
+			t.Errorf("\n(GOT):\n\t%#v\n(WNT):\n\t%#v", got, want.Digest)
+		}
+	})
+
+	t.Run("SHA512", func(t *testing.T) {
+		got, err := DigestFromDirectoryWithHash(osDirname, sha512.New)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g, w := len(got), sha512.Size; g != w {
+			t.Errorf("(GOT): %v; (WNT): %v", g, w)
+		}
+	})
+}
+
+func TestBlake2bDigester(t *testing.T) {
+	osDirname := filepath.Join(getTestdataVerifyRoot(t), "launchpad.net/match")
+
+	got, err := NewBlake2bDigester().Digest(osDirname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := DigestFromDirectoryWithHashAlgo(osDirname, BLAKE2b256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("\n(GOT):\n\t%#v\n(WNT):\n\t%#v", got, want)
+	}
+	if g, w := len(got), blake2b.Size256; g != w {
+		t.Errorf("(GOT): %v; (WNT): %v", g, w)
+	}
+}
+
+func TestCheckDigest(t *testing.T) {
+	osDirname := filepath.Join(getTestdataVerifyRoot(t), "launchpad.net/match")
+	match, err := hex.DecodeString("7e10062f08033c76aebca4c9ec736715702b008927bb619dc7c339460391b73b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatch := append([]byte(nil), match...)
+	mismatch[len(mismatch)-1] ^= 1
+
+	cases := []struct {
+		name     string
+		expected VersionedDigest
+		want     bool
+	}{
+		{"Match", VersionedDigest{HashVersion: HashVersion, Digest: match}, true},
+		{"Mismatch", VersionedDigest{HashVersion: HashVersion, Digest: mismatch}, false},
+		{"Truncated", VersionedDigest{HashVersion: HashVersion, Digest: match[:8]}, false},
+		{"Empty", VersionedDigest{HashVersion: HashVersion}, false},
+		{"HashVersionMismatch", VersionedDigest{HashVersion: HashVersion + 1, Digest: match}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := CheckDigest(osDirname, c.expected)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Errorf("(GOT): %v; (WNT): %v", got, c.want)
+			}
+		})
+	}
+
+	t.Run("RootNotFound", func(t *testing.T) {
+		got, err := CheckDigest(filepath.Join(osDirname, "missing"), VersionedDigest{HashVersion: HashVersion, Digest: match})
+		if got || err == nil {
+			t.Errorf("(GOT): %v, %v; (WNT): false, error", got, err)
+		}
+		if de, ok := err.(*DigestError); !ok || !de.Is(ErrRootNotFound) {
+			t.Errorf("(GOT): %v; (WNT): %v", err, ErrRootNotFound)
+		}
+	})
+}
+
+func TestDigestHexFromDirectory(t *testing.T) {
+	t.Run("Match", func(t *testing.T) {
+		got, err := DigestHexFromDirectory(filepath.Join(getTestdataVerifyRoot(t), "launchpad.net/match"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "7e10062f08033c76aebca4c9ec736715702b008927bb619dc7c339460391b73b"; got != want {
+			t.Errorf("(GOT): %v; (WNT): %v", got, want)
+		}
+	})
+
+	t.Run("EmptyDirectory", func(t *testing.T) {
+		osDirname, err := ioutil.TempDir("", "dep-verify-empty")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(osDirname)
+
+		got, err := DigestHexFromDirectory(osDirname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := hex.DecodeString(got); err != nil {
+			t.Error(err)
+		}
+		if g, w := len(got), hex.EncodedLen(sha256.Size); g != w {
+			t.Errorf("(GOT): %v; (WNT): %v", g, w)
+		}
+	})
+}
+
+// mkTestTree creates a temporary directory containing the specified files,
+// keyed by their slash-separated pathnames relative to the directory, and
+// returns its pathname.
+func mkTestTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	osDirname, err := ioutil.TempDir("", "dep-verify-tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for slashPathname, content := range files {
+		osPathname := filepath.Join(osDirname, filepath.FromSlash(slashPathname))
+		if err := os.MkdirAll(filepath.Dir(osPathname), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(osPathname, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return osDirname
+}
+
+func TestDigestFromDirectoryWithMmap(t *testing.T) {
+	// Place CRLF sequences so they straddle the boundaries of the buffer used
+	// to copy file contents to the hash.
+	large := bytes.Repeat([]byte("x"), 64*1024)
+	for i := 4*1024 - 1; i < len(large)-1; i += 4 * 1024 {
+		large[i], large[i+1] = '\r', '\n'
+	}
+
+	osDirname := mkTestTree(t, map[string]string{
+		"large.bin":   string(large),
+		"small.go":    "package small\r\n",
+		"empty":       "",
+		"sub/crlf.go": strings.Repeat("now is the time\r\n", 1000),
+	})
+	defer os.RemoveAll(osDirname)
+
+	want, err := DigestFromDirectory(osDirname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, threshold := range []int64{1, 16 * 1024, 1 << 30} {
+		got, err := DigestFromDirectoryWithMmap(osDirname, threshold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Digest, want.Digest) {
+			t.Errorf("threshold %d:\n(GOT):\n\t%v\n(WNT):\n\t%v", threshold, got, want)
+		}
+	}
+}
+
+// countdownContext is a context.Context that reports it has been cancelled
+// once its Err method has been called a given number of times.
+type countdownContext struct {
+	context.Context
+	remaining int
+}
+
+func (ctx *countdownContext) Err() error {
+	if ctx.remaining <= 0 {
+		return context.Canceled
+	}
+	ctx.remaining--
+	return nil
+}
+
+func TestDigestFromDirectoryContext(t *testing.T) {
+	osDirname := mkSyntheticTree(t, 1000)
+	defer os.RemoveAll(osDirname)
+
+	want, err := DigestFromDirectory(osDirname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Background", func(t *testing.T) {
+		got, err := DigestFromDirectoryContext(context.Background(), osDirname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Digest, want.Digest) {
+			t.Errorf("\n(GOT):\n\t%v\n(WNT):\n\t%v", got, want)
+		}
+	})
+
+	t.Run("CancelledMidTraversal", func(t *testing.T) {
+		ctx := &countdownContext{Context: context.Background(), remaining: 100}
+		_, err := DigestFromDirectoryContext(ctx, osDirname)
+		if got, want := err, context.Canceled; got != want {
+			t.Errorf("(GOT): %v; (WNT): %v", got, want)
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := DigestFromDirectoryContext(ctx, osDirname)
+		if got, want := err, context.Canceled; got != want {
+			t.Errorf("(GOT): %v; (WNT): %v", got, want)
+		}
+	})
+}
+
 func TestVerifyDepTree(t *testing.T) {
 	vendorRoot := getTestdataVerifyRoot(t)
 
@@ -234,18 +843,215 @@ func TestVerifyDepTree(t *testing.T) {
 	})
 }
 
-func BenchmarkDigestFromDirectory(b *testing.B) {
-	b.Skip("Eliding benchmark of user's Go source directory")
-
-	prefix := filepath.Join(os.Getenv("GOPATH"), "src")
+func TestCheckDepTreeWithHashAlgo(t *testing.T) {
+	vendorRoot := getTestdataVerifyRoot(t)
+	slashPathname := "github.com/alice/match"
 
-	for i := 0; i < b.N; i++ {
-		_, err := DigestFromDirectory(prefix)
-		if err != nil {
-			b.Fatal(err)
-		}
+	sha256Digest, err := DigestFromDirectory(filepath.Join(vendorRoot, slashPathname))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha512Digest, err := DigestFromDirectoryWithHashAlgo(filepath.Join(vendorRoot, slashPathname), SHA512)
+	if err != nil {
+		t.Fatal(err)
 	}
-}
+
+	testCases := []struct {
+		name   string
+		digest []byte
+		algo   HashAlgo
+		want   VendorStatus
+	}{
+		{"sha256", sha256Digest.Digest, SHA256, NoMismatch},
+		{"sha512", sha512Digest, SHA512, NoMismatch},
+		{"sha256-in-lock-sha512-on-disk", sha256Digest.Digest, SHA512, DigestMismatchInLock},
+		{"sha512-in-lock-sha256-on-disk", sha512Digest, SHA256, DigestMismatchInLock},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			wantDigests := map[string]VersionedDigest{
+				slashPathname: {HashVersion: HashVersion, Digest: tc.digest},
+			}
+			status, err := CheckDepTreeWithHashAlgo(vendorRoot, wantDigests, tc.algo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := status[slashPathname], tc.want; got != want {
+				t.Errorf("(GOT): %v; (WNT): %v", got, want)
+			}
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		if _, err := CheckDepTreeWithHashAlgo(vendorRoot, nil, HashAlgo(255)); err == nil {
+			t.Error("expected error for unknown hash algorithm")
+		}
+	})
+}
+
+func TestTaggedDigestRoundTrip(t *testing.T) {
+	vendorRoot := getTestdataVerifyRoot(t)
+	slashPathname := "github.com/alice/match"
+	osPathname := filepath.Join(vendorRoot, slashPathname)
+
+	untagged, err := DigestFromDirectory(osPathname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digests := map[string][]byte{"untagged-sha256": untagged.Digest}
+	for _, algo := range []HashAlgo{SHA256, SHA512, BLAKE2b256} {
+		tagged, err := TaggedDigestFromDirectory(osPathname, algo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(tagged), algo.size()+1; got != want {
+			t.Errorf("%v: (GOT): %v; (WNT): %v", algo, got, want)
+		}
+		if got, want := HashAlgo(tagged[0]), algo; got != want {
+			t.Errorf("(GOT): %v; (WNT): %v", got, want)
+		}
+		digests["tagged-"+algo.String()] = tagged
+	}
+
+	for name, digest := range digests {
+		t.Run(name, func(t *testing.T) {
+			wantDigests := map[string]VersionedDigest{
+				slashPathname: {HashVersion: HashVersion, Digest: digest},
+			}
+			status, err := CheckDepTree(vendorRoot, wantDigests)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := status[slashPathname], NoMismatch; got != want {
+				t.Errorf("(GOT): %v; (WNT): %v", got, want)
+			}
+		})
+	}
+
+	t.Run("tagged-mismatch", func(t *testing.T) {
+		tagged := append([]byte{byte(SHA512)}, make([]byte, sha512.Size)...)
+		wantDigests := map[string]VersionedDigest{
+			slashPathname: {HashVersion: HashVersion, Digest: tagged},
+		}
+		status, err := CheckDepTree(vendorRoot, wantDigests)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := status[slashPathname], DigestMismatchInLock; got != want {
+			t.Errorf("(GOT): %v; (WNT): %v", got, want)
+		}
+	})
+}
+
+func TestCheckDepTreeContext(t *testing.T) {
+	vendorRoot, wantDigests := mkSyntheticVendorTree(t, 50)
+	defer os.RemoveAll(vendorRoot)
+
+	t.Run("Background", func(t *testing.T) {
+		status, err := CheckDepTreeContext(context.Background(), vendorRoot, wantDigests)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for slashPathname := range wantDigests {
+			if got, want := status[slashPathname], NoMismatch; got != want {
+				t.Errorf("%q: (GOT): %v; (WNT): %v", slashPathname, got, want)
+			}
+		}
+	})
+
+	// Cancel while inspecting directories, and also while hashing one of the
+	// dependencies.
+	for _, remaining := range []int{10, 75} {
+		t.Run(fmt.Sprintf("CancelledAfter%d", remaining), func(t *testing.T) {
+			ctx := &countdownContext{Context: context.Background(), remaining: remaining}
+			status, err := CheckDepTreeContext(ctx, vendorRoot, wantDigests)
+			if got, want := err, context.Canceled; got != want {
+				t.Errorf("(GOT): %v; (WNT): %v", got, want)
+			}
+			if status != nil {
+				t.Errorf("Unexpected partial status: %v", status)
+			}
+		})
+	}
+}
+
+func TestSkipDirs(t *testing.T) {
+	skipDirs := map[string]bool{"tools": true}
+
+	t.Run("Digest", func(t *testing.T) {
+		withTools := mkTestTree(t, map[string]string{
+			"a.go":        "package a\n",
+			".git/HEAD":   "ref: refs/heads/master\n",
+			"tools/t.go":  "package tools\n",
+			"sub/b.go":    "package sub\n",
+			"sub/tools/x": "x",
+		})
+		defer os.RemoveAll(withTools)
+		withoutTools := mkTestTree(t, map[string]string{
+			"a.go":      "package a\n",
+			".git/HEAD": "ref: refs/heads/master\n",
+			"sub/b.go":  "package sub\n",
+		})
+		defer os.RemoveAll(withoutTools)
+
+		got, err := DigestFromDirectoryWithSkipDirs(withTools, skipDirs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := DigestFromDirectoryWithSkipDirs(withoutTools, map[string]bool{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("\n(GOT):\n\t%#v\n(WNT):\n\t%#v", got, want)
+		}
+
+		dflt, err := DigestFromDirectory(withTools)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(got, dflt.Digest) {
+			t.Error("Expected custom skip set to change the digest")
+		}
+	})
+
+	t.Run("CheckDepTree", func(t *testing.T) {
+		vendorRoot := mkTestTree(t, map[string]string{
+			"github.com/alice/alice1/a1.go":       "package alice1\n",
+			"github.com/alice/alice1/tools/t1.go": "package tools\n",
+			"tools/stray.go":                      "package stray\n",
+		})
+		defer os.RemoveAll(vendorRoot)
+
+		digest, err := DigestFromDirectoryWithSkipDirs(filepath.Join(vendorRoot, "github.com/alice/alice1"), skipDirs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, err := CheckDepTreeWithSkipDirs(vendorRoot, map[string]VersionedDigest{"github.com/alice/alice1": {HashVersion: HashVersion, Digest: digest}}, skipDirs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]VendorStatus{"github.com/alice/alice1": NoMismatch}
+		if !reflect.DeepEqual(status, want) {
+			t.Errorf("(GOT): %v; (WNT): %v", status, want)
+		}
+	})
+}
+
+func BenchmarkDigestFromDirectory(b *testing.B) {
+	b.Skip("Eliding benchmark of user's Go source directory")
+
+	prefix := filepath.Join(os.Getenv("GOPATH"), "src")
+
+	for i := 0; i < b.N; i++ {
+		_, err := DigestFromDirectory(prefix)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
 
 func BenchmarkVerifyDepTree(b *testing.B) {
 	b.Skip("Eliding benchmark of user's Go source directory")
@@ -259,3 +1065,1624 @@ func BenchmarkVerifyDepTree(b *testing.B) {
 		}
 	}
 }
+
+// mkSyntheticTree creates a temporary directory tree containing the specified
+// number of small files, spread across subdirectories, and returns its
+// pathname.
+func mkSyntheticTree(tb testing.TB, fileCount int) string {
+	tb.Helper()
+
+	osDirname, err := ioutil.TempDir("", "dep-verify-synthetic")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	content := bytes.Repeat([]byte("package synthetic // now is the time\r\n"), 32)
+	for i := 0; i < fileCount; i++ {
+		osSubdirname := filepath.Join(osDirname, fmt.Sprintf("d%03d", i%100))
+		if err := os.MkdirAll(osSubdirname, 0755); err != nil {
+			tb.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(osSubdirname, fmt.Sprintf("f%05d.go", i)), content, 0644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	return osDirname
+}
+
+func BenchmarkDigesters(b *testing.B) {
+	osDirname := mkSyntheticTree(b, 5000)
+	defer os.RemoveAll(osDirname)
+
+	digesters := []struct {
+		name     string
+		digester *Digester
+	}{
+		{"sha256", NewDigester()},
+		{"blake2b", NewBlake2bDigester()},
+	}
+
+	for _, d := range digesters {
+		b.Run(d.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := d.digester.Digest(osDirname); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// mkSyntheticVendorTree creates a temporary vendor directory containing the
+// specified number of small projects, and returns its pathname along with the
+// expected digests of those projects.
+func mkSyntheticVendorTree(tb testing.TB, projectCount int) (string, map[string]VersionedDigest) {
+	tb.Helper()
+
+	vendorRoot, err := ioutil.TempDir("", "dep-verify-vendor")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	wantDigests := make(map[string]VersionedDigest, projectCount)
+	for i := 0; i < projectCount; i++ {
+		slashPathname := fmt.Sprintf("github.com/user%03d/project", i)
+		osPathname := filepath.Join(vendorRoot, filepath.FromSlash(slashPathname))
+		if err := os.MkdirAll(osPathname, 0755); err != nil {
+			tb.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(osPathname, "project.go"), []byte("package project\n"), 0644); err != nil {
+			tb.Fatal(err)
+		}
+		if wantDigests[slashPathname], err = DigestFromDirectory(osPathname); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	return vendorRoot, wantDigests
+}
+
+func BenchmarkCheckDepTreeManyProjects(b *testing.B) {
+	vendorRoot, wantDigests := mkSyntheticVendorTree(b, 500)
+	defer os.RemoveAll(vendorRoot)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := CheckDepTree(vendorRoot, wantDigests); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkCheckDepTreeLargeTree measures verifying a tree of many locked
+// dependencies, each of which is a small project, so that the file system
+// calls made for each dependency's own node, which are shared between walking
+// the tree and computing the dependency's digest, dominate.
+func BenchmarkCheckDepTreeLargeTree(b *testing.B) {
+	vendorRoot, wantDigests := mkSyntheticVendorTree(b, 5000)
+	defer os.RemoveAll(vendorRoot)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := CheckDepTree(vendorRoot, wantDigests); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// digestInOrder returns the digest of the nodes at the specified relative
+// pathnames within the specified directory, written to the hash in the order
+// specified, exactly as DigestFromDirectory writes each of them.
+func digestInOrder(t *testing.T, osDirname string, slashRelatives []string) []byte {
+	t.Helper()
+
+	closure := newDirWalkClosure(sha256.New())
+	defer closure.release()
+	for _, slashRelative := range slashRelatives {
+		osPathname := filepath.Join(osDirname, filepath.FromSlash(slashRelative))
+		fi, err := os.Lstat(osPathname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mt, isRegular := digestModeType(fi.Mode())
+		closure.writeEntry(digestEntry{osPathname: osPathname, osRelative: filepath.FromSlash(slashRelative), modeType: mt, isRegular: isRegular})
+		if isRegular {
+			if err = closure.writeFile(osPathname); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return closure.someHash.Sum(nil)
+}
+
+func TestDigestFromDirectoryDepthFirst(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		"a/b/c.go": "package b\n",
+		"a/d.go":   "package a\n",
+		"e/f.go":   "package e\n",
+		"g.go":     "package g\n",
+	})
+	defer os.RemoveAll(dir)
+
+	depthFirst := []string{"", "a", "a/b", "a/b/c.go", "a/d.go", "e", "e/f.go", "g.go"}
+	breadthFirst := []string{"", "a", "e", "g.go", "a/b", "a/d.go", "e/f.go", "a/b/c.go"}
+
+	got, err := DigestFromDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := digestInOrder(t, dir, depthFirst); !bytes.Equal(got.Digest, want) {
+		t.Errorf("depth-first: (GOT): %x; (WNT): %x", got.Digest, want)
+	}
+	// The order in which the nodes are written is part of the digest, so
+	// the same nodes written breadth-first hash differently.
+	if other := digestInOrder(t, dir, breadthFirst); bytes.Equal(got.Digest, other) {
+		t.Errorf("breadth-first digest is the same as the depth-first one: %x", other)
+	}
+}
+
+// mkWideDeepTree creates a temporary directory tree in which each directory
+// down to the specified depth holds the specified number of subdirectories
+// and files, and returns its pathname.
+func mkWideDeepTree(tb testing.TB, width, depth int) string {
+	tb.Helper()
+
+	osDirname, err := ioutil.TempDir("", "dep-verify-wide-deep")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	var populate func(osDirname string, level int)
+	populate = func(osDirname string, level int) {
+		for i := 0; i < width; i++ {
+			if err := ioutil.WriteFile(filepath.Join(osDirname, fmt.Sprintf("f%03d.go", i)), []byte("package p\n"), 0644); err != nil {
+				tb.Fatal(err)
+			}
+			if level == depth {
+				continue
+			}
+			osSubdirname := filepath.Join(osDirname, fmt.Sprintf("a-rather-long-directory-name-%03d", i))
+			if err := os.Mkdir(osSubdirname, 0755); err != nil {
+				tb.Fatal(err)
+			}
+			populate(osSubdirname, level+1)
+		}
+	}
+	populate(osDirname, 1)
+
+	return osDirname
+}
+
+// BenchmarkDigestFromDirectoryWideDeepTree measures hashing a tree of tens of
+// thousands of nodes, logging the greatest growth of the live heap observed
+// while it is walked, which ought to depend on the depth and width of the
+// tree rather than on the number of nodes in it.
+func BenchmarkDigestFromDirectoryWideDeepTree(b *testing.B) {
+	osDirname := mkWideDeepTree(b, 8, 5) // 8 + 8^2 + ... + 8^5 files and ~5000 directories
+	defer os.RemoveAll(osDirname)
+
+	var peak uint64
+	var ms runtime.MemStats
+	digester := NewDigester(WithWalkFunc(func(slashRelative string, info os.FileInfo) error {
+		if info.IsDir() {
+			runtime.ReadMemStats(&ms)
+			if ms.HeapAlloc > peak {
+				peak = ms.HeapAlloc
+			}
+		}
+		return nil
+	}))
+
+	var growth uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		runtime.GC()
+		runtime.ReadMemStats(&ms)
+		base := ms.HeapAlloc
+		peak = base
+		b.StartTimer()
+
+		if _, err := digester.Digest(osDirname); err != nil {
+			b.Fatal(err)
+		}
+		if peak-base > growth {
+			growth = peak - base
+		}
+	}
+	b.StopTimer()
+	b.Logf("peak heap growth: %d bytes", growth)
+}
+
+func TestDigestFromDirectoryWithVendor(t *testing.T) {
+	withVendor := mkTestTree(t, map[string]string{
+		"a.go":                             "package a\n",
+		"sub/c.go":                         "package sub\n",
+		".git/HEAD":                        "ref: refs/heads/master\n",
+		"vendor/github.com/bob/b/b.go":     "package b\n",
+		"sub/vendor/github.com/eve/e/e.go": "package e\n",
+	})
+	defer os.RemoveAll(withVendor)
+	withoutVendor := mkTestTree(t, map[string]string{
+		"a.go":     "package a\n",
+		"sub/c.go": "package sub\n",
+	})
+	defer os.RemoveAll(withoutVendor)
+	withoutGit := mkTestTree(t, map[string]string{
+		"a.go":                             "package a\n",
+		"sub/c.go":                         "package sub\n",
+		"vendor/github.com/bob/b/b.go":     "package b\n",
+		"sub/vendor/github.com/eve/e/e.go": "package e\n",
+	})
+	defer os.RemoveAll(withoutGit)
+
+	t.Run("Excluded", func(t *testing.T) {
+		got, err := DigestFromDirectoryWithVendor(withVendor, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := DigestFromDirectory(withoutVendor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want.Digest) {
+			t.Errorf("\n(GOT):\n\t%#v\n(WNT):\n\t%#v", got, want.Digest)
+		}
+	})
+
+	t.Run("Included", func(t *testing.T) {
+		got, err := DigestFromDirectoryWithVendor(withVendor, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// withoutGit has nothing to skip, so hashing it with an empty skip set
+		// writes exactly the nodes expected to be hashed, vendor included.
+		want, err := DigestFromDirectoryWithSkipDirs(withoutGit, map[string]bool{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("\n(GOT):\n\t%#v\n(WNT):\n\t%#v", got, want)
+		}
+
+		excluded, err := DigestFromDirectory(withVendor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(got, excluded.Digest) {
+			t.Error("Expected including vendor to change the digest")
+		}
+	})
+}
+
+func TestCheckDepTreeSymlinkInTree(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go": "package alice1\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+	override := mkTestTree(t, map[string]string{
+		"b1.go": "package bob1\n",
+	})
+	defer os.RemoveAll(override)
+
+	if err := os.MkdirAll(filepath.Join(vendorRoot, "github.com/bob"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(override, filepath.Join(vendorRoot, "github.com/bob/bob1")); err != nil {
+		t.Skipf("cannot create symlink: %s", err)
+	}
+	if err := os.Symlink(filepath.Join(override, "missing"), filepath.Join(vendorRoot, "github.com/bob/bob2")); err != nil {
+		t.Fatal(err)
+	}
+
+	alice1, err := DigestFromDirectory(filepath.Join(vendorRoot, "github.com/alice/alice1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob1, err := DigestFromDirectory(override)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := CheckDepTree(vendorRoot, map[string]VersionedDigest{
+		"github.com/alice/alice1": alice1,
+		"github.com/bob/bob1":     bob1,
+		"github.com/bob/bob2":     bob1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]VendorStatus{
+		"github.com/alice/alice1": NoMismatch,
+		"github.com/bob/bob1":     SymlinkInTree,
+		"github.com/bob/bob2":     SymlinkInTree,
+	}
+	if !reflect.DeepEqual(status, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", status, want)
+	}
+	if got, want := SymlinkInTree.String(), "symlink in tree"; got != want {
+		t.Errorf("(GOT): %q; (WNT): %q", got, want)
+	}
+}
+
+func TestCheckDepTreeDetailed(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go": "package alice1\n",
+		"github.com/alice/alice2/a2.go": "package alice2\n",
+		"github.com/bob/bob1/b1.go":     "package bob1\n",
+		"github.com/eve/eve1/e1.go":     "package eve1\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+
+	digestOf := func(slashPathname string) VersionedDigest {
+		digest, err := DigestFromDirectory(filepath.Join(vendorRoot, filepath.FromSlash(slashPathname)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return digest
+	}
+	alice1, alice2 := digestOf("github.com/alice/alice1"), digestOf("github.com/alice/alice2")
+
+	results, err := CheckDepTreeDetailed(vendorRoot, map[string]VersionedDigest{
+		"github.com/alice/alice1": alice1,
+		"github.com/alice/alice2": alice1, // wrong on purpose
+		"github.com/bob/bob1":     {HashVersion: HashVersion},
+		"github.com/charlie/c1":   alice1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]DepTreeResult{
+		"github.com/alice/alice1": {Status: NoMismatch, Digest: alice1},
+		"github.com/alice/alice2": {Status: DigestMismatchInLock, Digest: alice2},
+		"github.com/bob/bob1":     {Status: EmptyDigestInLock},
+		"github.com/charlie/c1":   {Status: NotInTree},
+		"github.com/eve":          {Status: NotInLock, Files: 1, Bytes: int64(len("package eve1\n"))},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", results, want)
+	}
+}
+
+func TestCheckDepTreeDetailedNotInLockContents(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go":       "package alice1\n",
+		"github.com/alice/alice2/a2.go":       "a2",
+		"github.com/alice/alice2/sub/s.go":    "sub",
+		"github.com/alice/alice2/.git/HEAD":   "ref: refs/heads/master\n",
+		"github.com/alice/alice3/x/y/z.go":    "z",
+		"github.com/alice/alice3/x/y/empty":   "",
+		"github.com/alice/alice3/vendor/v.go": "vendored",
+		"launchpad.net/nifty/n1.go":           "nifty",
+		"orphan.txt":                          "orphaned",
+	})
+	defer os.RemoveAll(vendorRoot)
+	if err := os.Mkdir(filepath.Join(vendorRoot, "github.com/alice/empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	alice1, err := DigestFromDirectory(filepath.Join(vendorRoot, "github.com/alice/alice1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := CheckDepTreeDetailed(vendorRoot, map[string]VersionedDigest{"github.com/alice/alice1": alice1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]DepTreeResult{
+		"github.com/alice/alice1": {Status: NoMismatch, Digest: alice1},
+		"github.com/alice/alice2": {Status: NotInLock, Files: 2, Bytes: 5},
+		"github.com/alice/alice3": {Status: NotInLock, Files: 2, Bytes: 1},
+		"github.com/alice/empty":  {Status: NotInLock},
+		"launchpad.net":           {Status: NotInLock, Files: 1, Bytes: 5},
+		"orphan.txt":              {Status: NotInLock, Files: 1, Bytes: 8},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", results, want)
+	}
+
+	// The basic statuses are unchanged.
+	status, err := CheckDepTree(vendorRoot, map[string]VersionedDigest{"github.com/alice/alice1": alice1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for slashPathname, result := range want {
+		if status[slashPathname] != result.Status {
+			t.Errorf("%s: (GOT): %v; (WNT): %v", slashPathname, status[slashPathname], result.Status)
+		}
+	}
+}
+
+func TestCheckDepTreeWithProgress(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go": "package alice1\n",
+		"github.com/alice/alice2/a2.go": "package alice2\n",
+		"github.com/bob/bob1/b1.go":     "package bob1\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+
+	alice1, err := DigestFromDirectory(filepath.Join(vendorRoot, "github.com/alice/alice1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDigests := map[string]VersionedDigest{
+		"github.com/alice/alice1": alice1,
+		"github.com/alice/alice2": alice1,
+		"github.com/bob/bob1":     {HashVersion: HashVersion},
+		"github.com/zed/z1":       alice1,
+		"github.com/charlie/c1":   alice1,
+	}
+
+	var got []string
+	status, err := CheckDepTreeWithProgress(vendorRoot, wantDigests, func(slashPathname string, ls VendorStatus) {
+		got = append(got, slashPathname+": "+ls.String())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Found projects are reported in walk order, which is depth first and
+	// reverse lexicographical, followed by missing projects in order.
+	want := []string{
+		"github.com/bob/bob1: empty digest in lock",
+		"github.com/alice/alice2: mismatch",
+		"github.com/alice/alice1: match",
+		"github.com/charlie/c1: not in tree",
+		"github.com/zed/z1: not in tree",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\n(GOT):\n\t%v\n(WNT):\n\t%v", got, want)
+	}
+	if len(status) != len(wantDigests) {
+		t.Errorf("(GOT): %v; (WNT): %v", len(status), len(wantDigests))
+	}
+}
+
+func TestCheckDepTreeStream(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go":    "package alice1\n",
+		"github.com/alice/alice2/a2.go":    "package alice2\n",
+		"github.com/alice/notInLock/n.go":  "package notInLock\n",
+		"github.com/bob/bob1/b1.go":        "package bob1\n",
+		"launchpad.net/nifty/n1.go":        "package nifty\n",
+		"github.com/alice/alice1/sub/s.go": "package sub\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+
+	alice1, err := DigestFromDirectory(filepath.Join(vendorRoot, "github.com/alice/alice1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDigests := map[string]VersionedDigest{
+		"github.com/alice/alice1": alice1,
+		"github.com/alice/alice2": alice1,
+		"github.com/bob/bob1":     {HashVersion: HashVersion},
+		"github.com/zed/z1":       alice1,
+		"github.com/charlie/c1":   alice1,
+	}
+
+	out := make(chan ProjectStatus)
+	errc := make(chan error, 1)
+	go func() { errc <- CheckDepTreeStream(vendorRoot, wantDigests, out) }()
+	var got []ProjectStatus
+	for ps := range out {
+		got = append(got, ps)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ProjectStatus{
+		{"github.com/bob/bob1", EmptyDigestInLock},
+		{"github.com/alice/alice2", DigestMismatchInLock},
+		{"github.com/alice/alice1", NoMismatch},
+		{"github.com/charlie/c1", NotInTree},
+		{"github.com/zed/z1", NotInTree},
+		{"github.com/alice/notInLock", NotInLock},
+		{"launchpad.net", NotInLock},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\n(GOT):\n\t%v\n(WNT):\n\t%v", got, want)
+	}
+
+	// Together, the statuses are those CheckDepTree returns.
+	status, err := CheckDepTree(vendorRoot, wantDigests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed := make(map[string]VendorStatus, len(got))
+	for _, ps := range got {
+		streamed[ps.Path] = ps.Status
+	}
+	if !reflect.DeepEqual(streamed, status) {
+		t.Errorf("(GOT): %v; (WNT): %v", streamed, status)
+	}
+
+	// The channel is closed when verification fails.
+	notDir := filepath.Join(vendorRoot, "launchpad.net/nifty/n1.go")
+	out = make(chan ProjectStatus)
+	go func() { errc <- CheckDepTreeStream(notDir, wantDigests, out) }()
+	for range out {
+		t.Error("expected no statuses")
+	}
+	if err := <-errc; err == nil {
+		t.Error("expected error verifying a file")
+	}
+}
+
+func TestDigestFromReader(t *testing.T) {
+	for _, contents := range []string{"", "package a\n", "line one\r\nline two\r\n", "trailing\r"} {
+		dir := mkTestTree(t, map[string]string{"blob": contents})
+		defer os.RemoveAll(dir)
+
+		got, err := DigestFromReader(strings.NewReader(contents))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := DigestFromDirectory(filepath.Join(dir, "blob"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want.Digest) {
+			t.Errorf("%q:\n(GOT):\n\t%x\n(WNT):\n\t%x", contents, got, want.Digest)
+		}
+	}
+}
+
+func TestDiffTrees(t *testing.T) {
+	oldVendor := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go":     "package alice1\n",
+		"github.com/alice/alice2/a2.go":     "package alice2\n",
+		"github.com/bob/bob1/b1.go":         "package bob1\n",
+		"github.com/bob/bob2/b2.go":         "package bob2\n",
+		"github.com/bob/bob2/internal/i.go": "package internal\n",
+		"gopkg.in/yaml.v2/yaml.go":          "package yaml\n",
+		"github.com/dave/dave1/sub/s.go":    "package sub\n",
+		"modules.txt":                       "# not a project\n",
+	})
+	defer os.RemoveAll(oldVendor)
+	newVendor := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go":       "package alice1\n",
+		"github.com/alice/alice2/a2.go":       "package alice2 // changed\n",
+		"github.com/bob/bob2/b2.go":           "package bob2\n",
+		"github.com/bob/bob2/internal/i.go":   "package internal\n",
+		"github.com/charlie/charlie1/c1.go":   "package charlie1\n",
+		"gopkg.in/yaml.v2/v3/yaml.go":         "package yaml\n",
+		"github.com/dave/dave1/d.go":          "package dave1\n",
+		"github.com/dave/dave1/sub/s.go":      "package sub\n",
+		"golang.org/x/net/context/ctx.go":     "package context\n",
+		"golang.org/x/net/context/ctxhttp.go": "package context\n",
+	})
+	defer os.RemoveAll(newVendor)
+
+	got, err := DiffTrees(oldVendor, newVendor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]VendorStatus{
+		"github.com/alice/alice1":    NoMismatch,
+		"github.com/alice/alice2":    DigestMismatchInLock,
+		"github.com/bob/bob1":        NotInTree,
+		"github.com/bob/bob2":        NoMismatch,
+		"github.com/charlie":         NotInLock,
+		"gopkg.in/yaml.v2":           DigestMismatchInLock, // moved deeper
+		"github.com/dave/dave1/sub":  NoMismatch,
+		"github.com/dave/dave1/d.go": NotInLock, // added shallower
+		"golang.org":                 NotInLock,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", got, want)
+	}
+
+	t.Run("MissingOldTree", func(t *testing.T) {
+		got, err := DiffTrees(filepath.Join(oldVendor, "missing"), newVendor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for slashPathname, ls := range got {
+			if ls != NotInLock {
+				t.Errorf("%s: (GOT): %v; (WNT): %v", slashPathname, ls, NotInLock)
+			}
+		}
+	})
+}
+
+func TestDigestFromDirectoryRaw(t *testing.T) {
+	crlf := mkTestTree(t, map[string]string{"fixture.txt": "line one\r\nline two\r\n"})
+	defer os.RemoveAll(crlf)
+	lf := mkTestTree(t, map[string]string{"fixture.txt": "line one\nline two\n"})
+	defer os.RemoveAll(lf)
+
+	rawCRLF, err := DigestFromDirectoryRaw(crlf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawLF, err := DigestFromDirectoryRaw(lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(rawCRLF, rawLF) {
+		t.Error("Expected raw digests of trees differing only in line endings to differ")
+	}
+
+	// Without any CRLF sequences, raw and normalized digests are the same.
+	normalizedLF, err := DigestFromDirectory(lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rawLF, normalizedLF.Digest) {
+		t.Errorf("\n(GOT):\n\t%#v\n(WNT):\n\t%#v", rawLF, normalizedLF.Digest)
+	}
+}
+
+func TestDigestFromDirectoryWithPerm(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("executable bit is not stored on windows")
+	}
+
+	dir := mkTestTree(t, map[string]string{"build.sh": "#!/bin/sh\n"})
+	defer os.RemoveAll(dir)
+	osPathname := filepath.Join(dir, "build.sh")
+
+	digests := func() (withPerm []byte, withoutPerm VersionedDigest) {
+		withPerm, err := DigestFromDirectoryWithPerm(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		withoutPerm, err = DigestFromDirectory(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return withPerm, withoutPerm
+	}
+
+	beforePerm, before := digests()
+	if err := os.Chmod(osPathname, 0755); err != nil {
+		t.Fatal(err)
+	}
+	afterPerm, after := digests()
+
+	if bytes.Equal(beforePerm, afterPerm) {
+		t.Error("Expected executable bit to change the digest when permissions are included")
+	}
+	if !bytes.Equal(before.Digest, after.Digest) {
+		t.Errorf("\n(GOT):\n\t%v\n(WNT):\n\t%v", after, before)
+	}
+}
+
+func TestCheckDepTreeSymlinkCycle(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go": "package alice1\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+
+	// Two links back to an ancestor would double the work on every level if
+	// the cycle went undetected.
+	for _, name := range []string{"loop1", "loop2"} {
+		if err := os.Symlink("..", filepath.Join(vendorRoot, "github.com/alice", name)); err != nil {
+			t.Skipf("cannot create symlink: %s", err)
+		}
+	}
+
+	alice1, err := DigestFromDirectory(filepath.Join(vendorRoot, "github.com/alice/alice1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = CheckDepTree(vendorRoot, map[string]VersionedDigest{"github.com/alice/alice1": alice1})
+	if err == nil {
+		t.Fatal("expected error for symlink cycle")
+	}
+	if got, want := err.Error(), "symlink cycle"; !strings.Contains(got, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", got, want)
+	}
+}
+
+func TestCheckDepTreeCollectErrors(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go": "package alice1\n",
+		"github.com/bob/bob1/b1.go":     "package bob1\n",
+		"github.com/eve/eve1/e1.go":     "package eve1\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+
+	alice1, err := DigestFromDirectory(filepath.Join(vendorRoot, "github.com/alice/alice1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDigests := map[string]VersionedDigest{
+		"github.com/alice/alice1": alice1,
+		"github.com/bob/bob1":     alice1,
+	}
+
+	// A symlink to itself cannot be resolved, even by the super user.
+	if err := os.Symlink("loop", filepath.Join(vendorRoot, "github.com/alice/loop")); err != nil {
+		t.Skipf("cannot create symlink: %s", err)
+	}
+	want := map[string]VendorStatus{
+		"github.com/alice/alice1": NoMismatch,
+		"github.com/bob/bob1":     DigestMismatchInLock,
+		"github.com/eve":          NotInLock,
+	}
+	wantErrs := 1
+
+	// The super user is able to read directories without permission.
+	if os.Geteuid() != 0 {
+		unreadable := filepath.Join(vendorRoot, "github.com/eve")
+		if err := os.Chmod(unreadable, 0); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chmod(unreadable, 0755)
+		wantDigests["github.com/eve/eve1"] = alice1
+		delete(want, "github.com/eve")
+		want["github.com/eve/eve1"] = DigestMismatchInLock
+		wantErrs++
+	}
+
+	if _, err := CheckDepTree(vendorRoot, wantDigests); err == nil {
+		t.Fatal("expected CheckDepTree to fail")
+	}
+
+	got, errs := CheckDepTreeCollectErrors(vendorRoot, wantDigests)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", got, want)
+	}
+	if len(errs) != wantErrs {
+		t.Errorf("(GOT): %v; (WNT): %v errors", errs, wantErrs)
+	}
+}
+
+func TestVendorStatusJSON(t *testing.T) {
+	statuses := map[string]VendorStatus{
+		"github.com/alice/alice1": NoMismatch,
+		"github.com/alice/alice2": DigestMismatchInLock,
+		"github.com/bob/bob1":     EmptyDigestInLock,
+		"github.com/bob/bob2":     HashVersionMismatch,
+		"github.com/charlie":      NotInLock,
+		"github.com/eve/eve1":     NotInTree,
+		"github.com/eve/eve2":     SymlinkInTree,
+	}
+
+	data, err := json.Marshal(statuses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), `{"github.com/alice/alice1":"match","github.com/alice/alice2":"mismatch","github.com/bob/bob1":"empty digest in lock","github.com/bob/bob2":"hasher changed","github.com/charlie":"not in lock","github.com/eve/eve1":"not in tree","github.com/eve/eve2":"symlink in tree"}`; got != want {
+		t.Errorf("\n(GOT):\n\t%s\n(WNT):\n\t%s", got, want)
+	}
+
+	var got map[string]VendorStatus
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, statuses) {
+		t.Errorf("(GOT): %v; (WNT): %v", got, statuses)
+	}
+
+	var ls VendorStatus
+	for _, input := range []string{`"unknown"`, `"bogus"`, `2`} {
+		if err := json.Unmarshal([]byte(input), &ls); err == nil {
+			t.Errorf("%s: expected error", input)
+		}
+	}
+	ls = SymlinkInTree
+	if err := json.Unmarshal([]byte(`null`), &ls); err != nil || ls != SymlinkInTree {
+		t.Errorf("null: (GOT): %v, %v; (WNT): %v, %v", ls, err, SymlinkInTree, nil)
+	}
+	if _, err := json.Marshal(VendorStatus(200)); err == nil {
+		t.Error("expected error marshaling unknown status")
+	}
+}
+
+func TestFormatStatuses(t *testing.T) {
+	entries := []struct {
+		slashPathname string
+		status        VendorStatus
+	}{
+		{"github.com/alice/alice1", NoMismatch},
+		{"github.com/alice/alice2", DigestMismatchInLock},
+		{"github.com/bob/bob1", NotInTree},
+		{"github.com/charlie", NotInLock},
+		{"gopkg.in/yaml.v2", EmptyDigestInLock},
+	}
+	want := "match\tgithub.com/alice/alice1\n" +
+		"mismatch\tgithub.com/alice/alice2\n" +
+		"not in tree\tgithub.com/bob/bob1\n" +
+		"not in lock\tgithub.com/charlie\n" +
+		"empty digest in lock\tgopkg.in/yaml.v2\n"
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		status := make(map[string]VendorStatus)
+		for _, j := range rnd.Perm(len(entries)) {
+			status[entries[j].slashPathname] = entries[j].status
+		}
+		if got := FormatStatuses(status); got != want {
+			t.Fatalf("\n(GOT):\n%s\n(WNT):\n%s", got, want)
+		}
+	}
+
+	if got := FormatStatuses(nil); got != "" {
+		t.Errorf("(GOT): %q; (WNT): %q", got, "")
+	}
+}
+
+func TestCheckDepTreeSince(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go":        "package alice1\n",
+		"github.com/alice/alice2/a2.go":        "package alice2\n",
+		"github.com/bob/bob1/b1.go":            "package bob1\n",
+		"github.com/bob/bob2/deeper/deeper.go": "package deeper\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+
+	wantDigests := make(map[string]VersionedDigest)
+	for _, slashPathname := range []string{"github.com/alice/alice1", "github.com/alice/alice2", "github.com/bob/bob1", "github.com/bob/bob2"} {
+		digest, err := DigestFromDirectory(filepath.Join(vendorRoot, filepath.FromSlash(slashPathname)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantDigests[slashPathname] = digest
+	}
+	wantDigests["github.com/carol/carol1"] = wantDigests["github.com/bob/bob1"]
+
+	// Every dependency's digest is wrong in the lock file except alice1's, so
+	// only the dependencies whose digests are computed are reported as such.
+	for _, slashPathname := range []string{"github.com/alice/alice2", "github.com/bob/bob1", "github.com/bob/bob2"} {
+		vd := wantDigests[slashPathname]
+		vd.Digest = append([]byte(nil), vd.Digest...)
+		vd.Digest[0] ^= 0xff
+		wantDigests[slashPathname] = vd
+	}
+
+	// Set every modification time in the past, so that later changes are
+	// noticed even by file systems whose timestamps are coarse, except a
+	// single nested file of bob2, and the files of bob1.
+	since := time.Now().Add(-time.Hour)
+	stale, fresh := since.Add(-time.Hour), since.Add(time.Second)
+	err := filepath.Walk(vendorRoot, func(osPathname string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		modTime := stale
+		switch filepath.ToSlash(strings.TrimPrefix(osPathname, vendorRoot)) {
+		case "/github.com/bob/bob1/b1.go", "/github.com/bob/bob2/deeper/deeper.go":
+			modTime = fresh
+		}
+		return os.Chtimes(osPathname, modTime, modTime)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := CheckDepTreeSince(vendorRoot, wantDigests, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]VendorStatus{
+		"github.com/alice/alice1": Unchanged,
+		"github.com/alice/alice2": Unchanged, // its digest would not match
+		"github.com/bob/bob1":     DigestMismatchInLock,
+		"github.com/bob/bob2":     DigestMismatchInLock,
+		"github.com/carol/carol1": NotInTree,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", got, want)
+	}
+
+	// A zero time verifies every dependency, as CheckDepTree does.
+	got, err = CheckDepTreeSince(vendorRoot, wantDigests, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err = CheckDepTree(vendorRoot, wantDigests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, wnt := want["github.com/alice/alice1"], NoMismatch; got != wnt {
+		t.Errorf("(GOT): %v; (WNT): %v", got, wnt)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", got, want)
+	}
+}
+
+func TestCanonicalizeDigests(t *testing.T) {
+	alice1 := VersionedDigest{HashVersion: HashVersion, Digest: []byte{1}}
+	alice2 := VersionedDigest{HashVersion: HashVersion, Digest: []byte{2}}
+	bob1 := VersionedDigest{HashVersion: HashVersion, Digest: []byte{3}}
+
+	got, err := CanonicalizeDigests(map[string]VersionedDigest{
+		"github.com/alice/alice1/": alice1,
+		`github.com\alice\alice2`:  alice2,
+		"./github.com//bob/./bob1": bob1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]VersionedDigest{
+		"github.com/alice/alice1": alice1,
+		"github.com/alice/alice2": alice2,
+		"github.com/bob/bob1":     bob1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", got, want)
+	}
+
+	t.Run("Collision", func(t *testing.T) {
+		for _, other := range []string{"github.com/alice/alice1/", `github.com\alice\alice1`, "./github.com/alice/alice1", `.\github.com/alice\alice1\`} {
+			got, err := CanonicalizeDigests(map[string]VersionedDigest{
+				"github.com/alice/alice1": alice1,
+				other:                     alice2,
+			})
+			if err == nil {
+				t.Errorf("%q: (GOT): %v; (WNT): error", other, got)
+			}
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		for _, key := range []string{
+			"", ".", "./", "//",
+			"/github.com/alice/alice1", `\github.com\alice\alice1`,
+			"..", "../alice", "github.com/bob/../alice/alice1", `github.com\..\..\alice`, "github.com/alice/alice1/..",
+		} {
+			got, err := CanonicalizeDigests(map[string]VersionedDigest{key: alice1})
+			if err == nil {
+				t.Errorf("%q: (GOT): %v; (WNT): error", key, got)
+			}
+		}
+	})
+}
+
+func TestSummarizeStatuses(t *testing.T) {
+	got := SummarizeStatuses(map[string]VendorStatus{
+		"github.com/alice/alice1": NoMismatch,
+		"github.com/alice/alice2": NoMismatch,
+		"github.com/bob/bob1":     DigestMismatchInLock,
+		"launchpad.net/nifty":     NotInLock,
+	})
+	want := map[VendorStatus]int{
+		NotInLock:            1,
+		NotInTree:            0,
+		NoMismatch:           2,
+		EmptyDigestInLock:    0,
+		DigestMismatchInLock: 1,
+		HashVersionMismatch:  0,
+		SymlinkInTree:        0,
+		EmptyTreeInLock:      0,
+		Unchanged:            0,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", got, want)
+	}
+
+	got = SummarizeStatuses(nil)
+	for ls := range want {
+		want[ls] = 0
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", got, want)
+	}
+}
+
+func TestDigestFromDirectoryLargeSparseFile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping hashing a multi-gigabyte file in short mode")
+	}
+
+	dir, err := ioutil.TempDir("", "dep-verify-sparse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Larger than the largest 32-bit signed integer, so its size only fits in
+	// 64 bits, yet sparse, so it takes no room on most file systems.
+	const size = 1<<31 + 1
+	fh, err := os.Create(filepath.Join(dir, "big"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fh.Truncate(size)
+	if er := fh.Close(); err == nil {
+		err = er
+	}
+	if err != nil {
+		t.Skipf("cannot create sparse file: %s", err)
+	}
+
+	got, err := DigestFromDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := sha256.New()
+	for _, field := range [][]byte{{}, {0, 0, 0, 0x80}, []byte("big"), {0, 0, 0, 0}} {
+		writeBytesWithNull(h, field)
+	}
+	zeros := make([]byte, 1<<20)
+	for remaining := int64(size); remaining > 0; remaining -= int64(len(zeros)) {
+		if remaining < int64(len(zeros)) {
+			zeros = zeros[:remaining]
+		}
+		h.Write(zeros)
+	}
+	writeBytesWithNull(h, []byte("2147483649"))
+	if want := h.Sum(nil); !bytes.Equal(got.Digest, want) {
+		t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", got.Digest, want)
+	}
+}
+
+func TestDigestFromDirectoryWithStats(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		".git/HEAD": "ref: refs/heads/master\n",
+		"a.go":      "package a\r\n",
+		"sub/b.go":  "package sub\n",
+	})
+	defer os.RemoveAll(dir)
+	if err := os.Symlink("a.go", filepath.Join(dir, "link")); err != nil {
+		t.Skipf("cannot create symlink: %s", err)
+	}
+
+	want, err := DigestFromDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, stats, err := DigestFromDirectoryWithStats(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want.Digest) {
+		t.Errorf("\n(GOT):\n\t%#v\n(WNT):\n\t%#v", got, want.Digest)
+	}
+	if wantStats := (DigestStats{Files: 2, Directories: 2, Symlinks: 1, Bytes: 22}); stats != wantStats {
+		t.Errorf("(GOT): %+v; (WNT): %+v", stats, wantStats)
+	}
+
+	// The super user is able to read files without permission.
+	if os.Geteuid() == 0 {
+		return
+	}
+	unreadable := filepath.Join(dir, "sub/b.go")
+	if err := os.Chmod(unreadable, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(unreadable, 0644)
+
+	_, stats, err = DigestFromDirectoryWithStats(dir)
+	if err == nil {
+		t.Fatal("expected error for unreadable file")
+	}
+	if wantStats := (DigestStats{Files: 2, Directories: 2, Symlinks: 1, Bytes: 10}); stats != wantStats {
+		t.Errorf("(GOT): %+v; (WNT): %+v", stats, wantStats)
+	}
+}
+
+func TestCheckProjects(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go": "package alice1\n",
+		"github.com/alice/alice2/a2.go": "package alice2\n",
+		"github.com/bob/bob1/b1.go":     "package bob1\n",
+		"github.com/charlie/c.go":       "package charlie\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+
+	alice1, err := DigestFromDirectory(filepath.Join(vendorRoot, "github.com/alice/alice1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDigests := map[string]VersionedDigest{
+		"github.com/alice/alice1": alice1,
+		"github.com/bob/bob1":     alice1,
+		"github.com/eve/eve1":     alice1,
+	}
+
+	got, err := CheckProjects(vendorRoot, wantDigests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]VendorStatus{
+		"github.com/alice/alice1": NoMismatch,
+		"github.com/bob/bob1":     DigestMismatchInLock,
+		"github.com/eve/eve1":     NotInTree,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", got, want)
+	}
+
+	// CheckDepTree reports the unlisted directories as well.
+	status, err := CheckDepTree(vendorRoot, wantDigests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := status["github.com/charlie"]; !ok {
+		t.Errorf("Expected CheckDepTree to report unlisted directory: %v", status)
+	}
+}
+
+func TestCheckDepTreeSorted(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go": "package alice1\n",
+		"github.com/bob/bob1/b1.go":     "package bob1\n",
+		"launchpad.net/nifty/n1.go":     "package nifty\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+
+	alice1, err := DigestFromDirectory(filepath.Join(vendorRoot, "github.com/alice/alice1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDigests := map[string]VersionedDigest{
+		"github.com/alice/alice1": alice1,
+		"github.com/bob/bob1":     alice1,
+		"github.com/eve/eve1":     alice1,
+	}
+
+	got, err := CheckDepTreeSorted(vendorRoot, wantDigests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ProjectStatus{
+		{Path: "github.com/alice/alice1", Status: NoMismatch},
+		{Path: "github.com/bob/bob1", Status: DigestMismatchInLock},
+		{Path: "github.com/eve/eve1", Status: NotInTree},
+		{Path: "launchpad.net", Status: NotInLock},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", got, want)
+	}
+
+	if _, err = CheckDepTreeSorted(filepath.Join(vendorRoot, "github.com/alice/alice1/a1.go"), wantDigests); err == nil {
+		t.Error("Expected error verifying non directory")
+	}
+}
+
+func TestCheckDepTreeDigestLengthMismatch(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go": "package alice1\n",
+		"github.com/alice/alice2/a2.go": "package alice2\n",
+		"github.com/alice/alice3/a3.go": "package alice3\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+
+	alice1, err := DigestFromDirectory(filepath.Join(vendorRoot, "github.com/alice/alice1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := CheckDepTree(vendorRoot, map[string]VersionedDigest{
+		"github.com/alice/alice1": alice1,
+		"github.com/alice/alice2": {HashVersion: HashVersion, Digest: alice1.Digest[:len(alice1.Digest)-1]},
+		"github.com/alice/alice3": {HashVersion: HashVersion, Digest: append(append([]byte(nil), alice1.Digest...), 0)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]VendorStatus{
+		"github.com/alice/alice1": NoMismatch,
+		"github.com/alice/alice2": DigestMismatchInLock, // too short
+		"github.com/alice/alice3": DigestMismatchInLock, // too long
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", got, want)
+	}
+}
+
+func TestCheckDepTreeEmptyTree(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go":          "package alice1\n",
+		"github.com/bob/bob2/.git/HEAD":          "ref: refs/heads/master\n",
+		"github.com/bob/bob3/vendor/x/x.go":      "package x\n",
+		"github.com/charlie/charlie1/sub/c1.txt": "",
+	})
+	defer os.RemoveAll(vendorRoot)
+	if err := os.MkdirAll(filepath.Join(vendorRoot, "github.com/bob/bob1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(vendorRoot, "github.com/dave/dave1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	alice1, err := DigestFromDirectory(filepath.Join(vendorRoot, "github.com/alice/alice1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dave1, err := DigestFromDirectory(filepath.Join(vendorRoot, "github.com/dave/dave1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := CheckDepTree(vendorRoot, map[string]VersionedDigest{
+		"github.com/alice/alice1":     alice1,
+		"github.com/bob/bob1":         alice1,
+		"github.com/bob/bob2":         alice1,
+		"github.com/bob/bob3":         alice1,
+		"github.com/charlie/charlie1": alice1,
+		"github.com/dave/dave1":       dave1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]VendorStatus{
+		"github.com/alice/alice1":     NoMismatch,
+		"github.com/bob/bob1":         EmptyTreeInLock,
+		"github.com/bob/bob2":         EmptyTreeInLock, // nothing but skipped directories
+		"github.com/bob/bob3":         EmptyTreeInLock,
+		"github.com/charlie/charlie1": DigestMismatchInLock, // holds an empty file
+		"github.com/dave/dave1":       NoMismatch,           // locked while empty
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", got, want)
+	}
+}
+
+func TestCheckDepTreeHex(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go": "package alice1\n",
+		"github.com/bob/bob1/b1.go":     "package bob1\n",
+		"github.com/eve/eve1/e1.go":     "package eve1\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+
+	alice1, err := DigestHexFromDirectory(filepath.Join(vendorRoot, "github.com/alice/alice1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := CheckDepTreeHex(vendorRoot, map[string]string{
+		"github.com/alice/alice1": alice1,
+		"github.com/bob/bob1":     alice1,
+		"github.com/eve/eve1":     "",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]VendorStatus{
+		"github.com/alice/alice1": NoMismatch,
+		"github.com/bob/bob1":     DigestMismatchInLock,
+		"github.com/eve/eve1":     EmptyDigestInLock,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", got, want)
+	}
+
+	_, err = CheckDepTreeHex(vendorRoot, map[string]string{
+		"github.com/alice/alice1": alice1,
+		"github.com/bob/bob1":     "not hex",
+	})
+	if err == nil {
+		t.Fatal("Expected error decoding malformed digest")
+	}
+	if !strings.Contains(err.Error(), `"github.com/bob/bob1"`) {
+		t.Errorf("Expected error to name the dependency: %s", err)
+	}
+}
+
+func TestDigestVendorRoot(t *testing.T) {
+	files := map[string]string{
+		"github.com/alice/alice1/a1.go":     "package alice1\n",
+		"github.com/alice/alice1/sub/s.go":  "package sub\n",
+		"github.com/bob/bob1/b1.go":         "package bob1\n",
+		"launchpad.net/nifty/n1.go":         "package nifty\n",
+		"modules.txt":                       "# github.com/alice/alice1\n",
+		"github.com/bob/bob1/vendor/x/x.go": "package x\n",
+		"vendor/y/y.go":                     "package y\n",
+		"vendor/y/vendor/z/z.go":            "package z\n",
+		".git/HEAD":                         "ref: refs/heads/master\n",
+	}
+	vendorRoot := mkTestTree(t, files)
+	defer os.RemoveAll(vendorRoot)
+
+	original, err := DigestVendorRoot(vendorRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dflt, err := DigestFromDirectory(vendorRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(original, dflt.Digest) {
+		t.Error("Expected the vendor directory beneath the vendor root to change the digest")
+	}
+
+	for slashPathname, contents := range files {
+		osPathname := filepath.Join(vendorRoot, filepath.FromSlash(slashPathname))
+		if err = ioutil.WriteFile(osPathname, []byte(contents+"// changed\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := DigestVendorRoot(vendorRoot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// VCS directories and the vendor directories nested within
+		// dependencies are skipped, as they are by CheckDepTree, but not the
+		// vendor directory directly beneath the vendor root.
+		skipped := strings.HasPrefix(slashPathname, ".git/") || strings.Contains(slashPathname, "/vendor/")
+		if changed := !bytes.Equal(got, original); changed == skipped {
+			t.Errorf("%s: (GOT): changed %t; (WNT): changed %t", slashPathname, changed, !skipped)
+		}
+		if err = ioutil.WriteFile(osPathname, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := DigestVendorRoot(vendorRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, original) {
+		t.Errorf("(GOT): %x; (WNT): %x", got, original)
+	}
+}
+
+func TestWriteBytesWithNull(t *testing.T) {
+	// Each slice has spare capacity, which append would write the NULL byte
+	// into, except for the nil slice.
+	entries := []struct {
+		name    string
+		backing string
+		length  int
+	}{
+		{"nil", "", 0},
+		{"empty", "!", 0},
+		{"one spare byte", "pathname!", 8},
+		{"several spare bytes", "pathname!!!", 8},
+		{"referent", "../other/file!", 13},
+	}
+	for _, entry := range entries {
+		t.Run(entry.name, func(t *testing.T) {
+			var backing, data []byte
+			if entry.backing != "" {
+				backing = []byte(entry.backing)
+				data = backing[:entry.length]
+			}
+
+			h := sha256.New()
+			writeBytesWithNull(h, data)
+			if got := string(backing); got != entry.backing {
+				t.Errorf("(GOT): %q; (WNT): %q", got, entry.backing)
+			}
+
+			want := sha256.Sum256([]byte(entry.backing[:entry.length] + "\x00"))
+			if got := h.Sum(nil); !bytes.Equal(got, want[:]) {
+				t.Errorf("\n(GOT):\n\t%#v\n(WNT):\n\t%#v", got, want)
+			}
+		})
+	}
+}
+
+func BenchmarkWriteBytesWithNull(b *testing.B) {
+	h := sha256.New()
+	data := []byte("github.com/golang/dep/gps/verify/digest.go")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		writeBytesWithNull(h, data[:len(data):len(data)])
+	}
+}
+
+func TestDigestProjects(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go": "package alice1\n",
+		"github.com/bob/bob1/b1.go":     "package bob1\r\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+
+	slashPathnames := []string{"github.com/alice/alice1", "github.com/bob/bob1"}
+	digests, err := DigestProjects(vendorRoot, slashPathnames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(digests) != len(slashPathnames) {
+		t.Fatalf("(GOT): %v; (WNT): %v digests", len(digests), len(slashPathnames))
+	}
+	for _, slashPathname := range slashPathnames {
+		want, err := DigestFromDirectory(filepath.Join(vendorRoot, filepath.FromSlash(slashPathname)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := digests[slashPathname]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: (GOT): %v; (WNT): %v", slashPathname, got, want)
+		}
+	}
+
+	// The digests verify the tree they were computed from.
+	status, err := CheckDepTree(vendorRoot, digests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for slashPathname, ls := range status {
+		if ls != NoMismatch {
+			t.Errorf("%s: (GOT): %v; (WNT): %v", slashPathname, ls, NoMismatch)
+		}
+	}
+
+	_, err = DigestProjects(vendorRoot, []string{"github.com/alice/alice1", "github.com/eve/eve1"})
+	de, ok := err.(*DigestError)
+	if !ok || de.Op != opFindRoot || de.Pathname != filepath.Join(vendorRoot, "github.com/eve/eve1") {
+		t.Errorf("(GOT): %v; (WNT): missing root error", err)
+	}
+
+	// A dependency which is not a directory has no digest CheckDepTree
+	// would accept.
+	if _, err = DigestProjects(vendorRoot, []string{"github.com/alice/alice1/a1.go"}); err == nil {
+		t.Error("Expected error hashing dependency which is not a directory")
+	}
+}
+
+func TestStatusesDiff(t *testing.T) {
+	got := map[string]VendorStatus{
+		"github.com/alice/alice1": NoMismatch,
+		"github.com/alice/alice2": DigestMismatchInLock,
+		"github.com/bob/bob1":     NotInLock,
+	}
+	want := map[string]VendorStatus{
+		"github.com/alice/alice1": NoMismatch,
+		"github.com/alice/alice2": NoMismatch,
+		"github.com/charlie":      NotInTree,
+	}
+
+	if StatusesEqual(got, want) {
+		t.Error("expected statuses to differ")
+	}
+	wantDiffs := []string{
+		"github.com/alice/alice2: (GOT): mismatch; (WNT): match",
+		"github.com/bob/bob1: (GOT): not in lock; (WNT): absent",
+		"github.com/charlie: (GOT): absent; (WNT): not in tree",
+	}
+	if diffs := StatusesDiff(got, want); !reflect.DeepEqual(diffs, wantDiffs) {
+		t.Errorf("\n(GOT):\n\t%q\n(WNT):\n\t%q", diffs, wantDiffs)
+	}
+
+	same := map[string]VendorStatus{
+		"github.com/alice/alice1": NoMismatch,
+		"github.com/alice/alice2": DigestMismatchInLock,
+		"github.com/bob/bob1":     NotInLock,
+	}
+	if !StatusesEqual(got, same) {
+		t.Error("expected statuses to be equal")
+	}
+	if diffs := StatusesDiff(got, same); len(diffs) != 0 {
+		t.Errorf("(GOT): %q; (WNT): none", diffs)
+	}
+	if !StatusesEqual(nil, map[string]VendorStatus{}) {
+		t.Error("expected nil and empty statuses to be equal")
+	}
+}
+
+func TestPrefixLength(t *testing.T) {
+	testCases := []struct {
+		osDirname, separator string
+		osPathname, want     string
+	}{
+		{"/", "/", "/usr", "usr"},
+		{"/usr", "/", "/usr/lib", "lib"},
+		{"/usr/local", "/", "/usr/local/bin/go", "bin/go"},
+		{"vendor", "/", "vendor/github.com", "github.com"},
+		{`C:\`, `\`, `C:\Go`, "Go"},
+		{`C:\Go`, `\`, `C:\Go\src`, "src"},
+		{`\\server\share\`, `\`, `\\server\share\dir`, "dir"},
+		{`vendor`, `\`, `vendor\github.com\x`, `github.com\x`},
+	}
+	for _, testCase := range testCases {
+		if got := testCase.osPathname[prefixLength(testCase.osDirname, testCase.separator):]; got != testCase.want {
+			t.Errorf("%q beneath %q: (GOT): %q; (WNT): %q", testCase.osPathname, testCase.osDirname, got, testCase.want)
+		}
+	}
+}
+
+func TestDigestFromDirectoryAbsoluteAndRelative(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		"a.go":     "package a\n",
+		"sub/b.go": "package sub\n",
+	})
+	defer os.RemoveAll(dir)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	relative, err := filepath.Rel(cwd, dir)
+	if err != nil {
+		t.Skipf("cannot make %q relative to %q: %s", dir, cwd, err)
+	}
+
+	want, err := DigestFromDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, osDirname := range []string{relative, dir + string(filepath.Separator), filepath.Join(dir, "sub", "..")} {
+		got, err := DigestFromDirectory(osDirname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Digest, want.Digest) {
+			t.Errorf("%q:\n(GOT):\n\t%#v\n(WNT):\n\t%#v", osDirname, got.Digest, want.Digest)
+		}
+	}
+}
+
+func TestDigestFromDirectoryIndependentOfRootName(t *testing.T) {
+	parent, err := ioutil.TempDir("", "dep-verify-roots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+
+	// The digest of an empty directory is that of its own node alone, whose
+	// pathname is empty.
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write([]byte{0, 0, 0, 0x80, 0}) // os.ModeDir, little-endian
+	empty := h.Sum(nil)
+
+	trees := []struct {
+		slashRoots []string
+		files      map[string]string
+		want       []byte
+	}{
+		{[]string{"empty", "other", "nested/deeper/empty"}, nil, empty},
+		{[]string{"single", "elsewhere", "nested/single"}, map[string]string{"a.go": "package a\n"}, nil},
+	}
+	for _, tree := range trees {
+		want := tree.want
+		for _, slashRoot := range tree.slashRoots {
+			osDirname := filepath.Join(parent, filepath.FromSlash(slashRoot))
+			if err := os.MkdirAll(osDirname, 0755); err != nil {
+				t.Fatal(err)
+			}
+			for slashRelative, contents := range tree.files {
+				if err := ioutil.WriteFile(filepath.Join(osDirname, filepath.FromSlash(slashRelative)), []byte(contents), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := DigestFromDirectory(osDirname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want == nil {
+				want = got.Digest
+			}
+			if !bytes.Equal(got.Digest, want) {
+				t.Errorf("%s:\n(GOT):\n\t%x\n(WNT):\n\t%x", slashRoot, got.Digest, want)
+			}
+		}
+	}
+}
+
+func TestListDigestInputs(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		".git/HEAD":     "ref: refs/heads/master\n",
+		"a.go":          "package a\n",
+		"a/b.go":        "package b\n",
+		"a.b/c.go":      "package c\n",
+		"sub/d.go":      "package sub\n",
+		"sub/vendor/x":  "x\n",
+		"vendor/y/y.go": "package y\n",
+	})
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "sub/empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a.go", filepath.Join(dir, "link")); err != nil {
+		t.Skipf("cannot create symlink: %s", err)
+	}
+
+	got, err := ListDigestInputs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nodes are listed in the order filepath.Walk visits them, which is why
+	// `a` and its contents precede `a.b` and `a.go`.
+	want := []string{"", "a", "a/b.go", "a.b", "a.b/c.go", "a.go", "sub", "sub/d.go", "sub/empty"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\n(GOT):\n\t%q\n(WNT):\n\t%q", got, want)
+	}
+}
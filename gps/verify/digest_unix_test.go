@@ -0,0 +1,50 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package verify
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestDigestFromDirectoryWithSkipped(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		".git/HEAD":           "ref: refs/heads/master\n",
+		"a.go":                "package a\n",
+		"sub/b.go":            "package sub\n",
+		"sub/vendor/c/c.go":   "package c\n",
+		"sub/vendor/c/c_x.go": "package c\n",
+	})
+	defer os.RemoveAll(dir)
+
+	if err := syscall.Mkfifo(filepath.Join(dir, "fifo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a.go", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := DigestFromDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, skipped, err := DigestFromDirectoryWithSkipped(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want.Digest) {
+		t.Errorf("\n(GOT):\n\t%#v\n(WNT):\n\t%#v", got, want.Digest)
+	}
+	if wantSkipped := []string{".git", "fifo", "link", "sub/vendor"}; !reflect.DeepEqual(skipped, wantSkipped) {
+		t.Errorf("(GOT): %v; (WNT): %v", skipped, wantSkipped)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestCheckDepTreeSlashSeparatedKeys verifies that the slash-separated
+// pathnames of a lock file match dependencies whose pathnames on disk are
+// separated by backslashes, and that statuses are reported under
+// slash-separated pathnames too.
+func TestCheckDepTreeSlashSeparatedKeys(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go":      "package alice1\n",
+		"github.com/bob/nested/deeper/b1.go": "package deeper\n",
+		"github.com/eve/eve1/e1.go":          "package eve1\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+
+	digest := func(slashPathname string) VersionedDigest {
+		t.Helper()
+		vd, err := DigestFromDirectory(filepath.Join(vendorRoot, filepath.FromSlash(slashPathname)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return vd
+	}
+	wantDigests := map[string]VersionedDigest{
+		"github.com/alice/alice1":      digest("github.com/alice/alice1"),
+		"github.com/bob/nested/deeper": digest("github.com/bob/nested/deeper"),
+		`github.com\eve\eve1`:          digest("github.com/eve/eve1"), // backslashes do not separate elements of a key
+	}
+
+	got, err := CheckDepTree(vendorRoot, wantDigests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]VendorStatus{
+		"github.com/alice/alice1":      NoMismatch,
+		"github.com/bob/nested/deeper": NoMismatch,
+		`github.com\eve\eve1`:          NotInTree,
+		"github.com/eve":               NotInLock,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", got, want)
+	}
+}
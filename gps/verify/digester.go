@@ -0,0 +1,668 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"context"
+	"crypto/sha256"
+	"go/build"
+	"hash"
+	"io"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Digester computes hash digests of directory trees, and verifies dependency
+// trees against them, according to the options with which it was created.
+// Create Digesters with NewDigester; the zero value is not usable. A Digester
+// is not modified by its methods, so it may be used concurrently.
+//
+// A Digester created without options computes the same digests as
+// DigestFromDirectory. Digests computed by a Digester created with options
+// that change how a tree is hashed can only be verified by a Digester created
+// with the same options.
+type Digester struct {
+	newHash func() hash.Hash
+	err     error // first error encountered while applying options
+
+	// rejectEmptyDigests causes a dependency with an empty digest in the lock
+	// file to be an error when verifying a dependency tree.
+	rejectEmptyDigests bool
+
+	digestOptions
+}
+
+// DigestOption configures a Digester created by NewDigester.
+type DigestOption func(*Digester)
+
+// NewDigester returns a Digester configured by the specified options, which
+// are applied in order. When an option is invalid, every method of the
+// returned Digester returns the resulting error.
+func NewDigester(options ...DigestOption) *Digester {
+	d := &Digester{
+		newHash:       sha256.New,
+		digestOptions: defaultDigestOptions(),
+	}
+	for _, option := range options {
+		option(d)
+	}
+	return d
+}
+
+// NewBlake2bDigester returns a Digester that hashes directory trees using
+// BLAKE2b-256, which is usually faster than SHA256 on 64-bit platforms that
+// lack hardware support for SHA256. It is equivalent to NewDigester with the
+// WithHashAlgo(BLAKE2b256) option.
+func NewBlake2bDigester() *Digester {
+	return NewDigester(WithHashAlgo(BLAKE2b256))
+}
+
+// WithHash causes trees to be hashed using hash instances created by the
+// specified constructor, rather than SHA256.
+func WithHash(newHash func() hash.Hash) DigestOption {
+	return func(d *Digester) {
+		d.newHash = newHash
+	}
+}
+
+// WithHashAlgo causes trees to be hashed using the specified hash algorithm,
+// rather than SHA256.
+func WithHashAlgo(algo HashAlgo) DigestOption {
+	return func(d *Digester) {
+		newHash, err := newHashFunc(algo)
+		if err != nil {
+			d.setErr(err)
+			return
+		}
+		d.newHash = newHash
+	}
+}
+
+// WithSkipDirs causes the file system nodes whose names are in the specified
+// set to be skipped, along with everything beneath them, rather than those in
+// DefaultSkipDirs.
+func WithSkipDirs(skipDirs map[string]bool) DigestOption {
+	return func(d *Digester) {
+		d.walk.skipDirs = skipDirs
+	}
+}
+
+// WithAdditionalSkipDirs causes the file system nodes with the specified names
+// to be skipped, along with everything beneath them, in addition to those which
+// are already skipped, such as the directories of a VCS not named in
+// DefaultSkipDirs. Neither DefaultSkipDirs nor a set passed to WithSkipDirs is
+// modified.
+func WithAdditionalSkipDirs(names ...string) DigestOption {
+	return func(d *Digester) {
+		skipDirs := make(map[string]bool, len(d.walk.skipDirs)+len(names))
+		for name, skip := range d.walk.skipDirs {
+			skipDirs[name] = skip
+		}
+		for _, name := range names {
+			skipDirs[name] = true
+		}
+		d.walk.skipDirs = skipDirs
+	}
+}
+
+// WithVendor causes nested `vendor` directories to be hashed when include is
+// true, as described by DigestFromDirectoryWithVendor.
+func WithVendor(include bool) DigestOption {
+	return func(d *Digester) {
+		d.walk.includeVendor = include
+	}
+}
+
+// WithFollowSymlinks causes symbolic links to be followed when follow is true,
+// rather than ignored, so that the referent of each link is hashed as though
+// it were found in place of the link, under the link's pathname. A symbolic
+// link whose referent does not exist, or which refers to one of its own
+// ancestor directories, causes an error.
+//
+// Following symbolic links makes the digest of a tree depend on files outside
+// of the tree, which may change without the tree itself changing. When used to
+// verify a dependency tree, locked projects that are symbolic links are
+// hashed, rather than reported as SymlinkInTree.
+func WithFollowSymlinks(follow bool) DigestOption {
+	return func(d *Digester) {
+		d.walk.followSymlinks = follow
+	}
+}
+
+// WithSymlinkTargets causes symbolic links to be hashed when include is true,
+// rather than ignored, without being followed. Each link is written to the
+// hash under its own pathname, followed by its referent, normalized as
+// described by PerFileDigests, and by the type of the node it refers to, which
+// is a regular file, a directory, some other type of node, or none at all, so
+// that replacing a dangling link with one whose referent exists changes the
+// digest, even when the referent is the same. Nothing else about the node it
+// refers to is hashed. WithFollowSymlinks takes precedence over this option.
+func WithSymlinkTargets(include bool) DigestOption {
+	return func(d *Digester) {
+		d.walk.includeSymlinks = include
+		d.symlinkTargets = include
+	}
+}
+
+// WithFilter causes the file system nodes for which the specified function
+// returns false to be skipped, along with everything beneath them, so that
+// only part of a tree is hashed. The function is invoked with the
+// slash-separated pathname of each node relative to the hashed directory, and
+// its file info, except for the hashed directory itself, and for the nodes
+// which are already skipped, such as those named in DefaultSkipDirs.
+//
+// To hash only certain files, the function needs to return true for the
+// directories enclosing them, or they are never reached. For instance, a
+// filter which accepts directories, files whose names end in `.go`, and files
+// named `LICENSE`, hashes only those files, and every directory.
+func WithFilter(filter func(slashRelative string, info os.FileInfo) bool) DigestOption {
+	return func(d *Digester) {
+		d.walk.filter = filter
+	}
+}
+
+// WithCaseCollisionCheck causes a directory containing file system nodes whose
+// names differ only in case, such as `README` and `readme`, to be an error
+// when check is true. This option does not change the digests computed.
+//
+// Children are always written to the hash sorted by the bytes of their names,
+// which is the same order on every platform, so a tree has the same digest on
+// every platform on which it can be reproduced. However, a tree with such
+// names cannot be reproduced on a case-insensitive file system, as used by
+// default on macOS and Windows, where only one of the nodes is able to exist,
+// and its digest would differ. Checking for collisions allows a tree to be
+// rejected on any platform before being locked with a digest that cannot be
+// verified elsewhere. Names are compared as they are by strings.EqualFold;
+// names which only differ by Unicode normalization form, which some file
+// systems also consider equal, are not detected.
+func WithCaseCollisionCheck(check bool) DigestOption {
+	return func(d *Digester) {
+		d.walk.rejectCaseCollisions = check
+	}
+}
+
+// WithBuildTarget causes the Go source files which would not be built for the
+// specified operating system and architecture, such as `linux` and `amd64`,
+// to be skipped, as though they were not in the tree, so that the digest only
+// depends on the files which would be compiled for that platform, and on every
+// file other than Go source files. Whether a file would be built is determined
+// by go/build, from the suffixes of its name, such as `_windows.go`, and the
+// build constraints at its top, in the context of build.Default, other than
+// the platform. As with the go command, the `cgo` build constraint is only
+// satisfied for the platform build.Default targets, when cgo is enabled for
+// it.
+//
+// Both the operating system and the architecture must be specified. Reading
+// the build constraints of a file requires it to be read before anything
+// about it is hashed.
+func WithBuildTarget(goos, goarch string) DigestOption {
+	return func(d *Digester) {
+		if goos == "" || goarch == "" {
+			d.setErr(errors.Errorf("cannot hash files built for %q/%q: both the operating system and architecture are required", goos, goarch))
+			return
+		}
+		ctxt := build.Default
+		if goos != ctxt.GOOS || goarch != ctxt.GOARCH {
+			ctxt.CgoEnabled = false
+		}
+		ctxt.GOOS, ctxt.GOARCH = goos, goarch
+		d.walk.buildContext = &ctxt
+	}
+}
+
+// WithIgnores causes the file system nodes matching any of the specified
+// patterns to be skipped, as described by DigestFromDirectoryWithIgnores.
+func WithIgnores(ignores []string) DigestOption {
+	return func(d *Digester) {
+		patterns, err := parseIgnorePatterns(ignores)
+		if err != nil {
+			d.setErr(err)
+			return
+		}
+		d.walk.ignores = patterns
+	}
+}
+
+// GeneratedFilePattern matches the line which marks a file as generated, such
+// as by `go generate`, according to the convention documented by the go
+// command: `// Code generated ... DO NOT EDIT.`.
+const GeneratedFilePattern = `^// Code generated .* DO NOT EDIT\.$`
+
+// WithGeneratedFileSkipping causes the regular files whose first line matches
+// the specified regular expression, such as GeneratedFilePattern, to be
+// skipped, as though they were not in the tree, so that files which are
+// reproducible from other sources do not change the digest. An empty pattern
+// skips no files. A pattern which does not compile causes an error when the
+// Digester is used.
+//
+// The first line is matched without the line ending terminating it, and only
+// as much of it as is read along with the start of the file, which is several
+// kilobytes. Matching a file requires it to be opened before anything about it
+// is hashed, but its contents are still read only once.
+func WithGeneratedFileSkipping(pattern string) DigestOption {
+	return func(d *Digester) {
+		if pattern == "" {
+			d.generated = nil
+			return
+		}
+		generated, err := regexp.Compile(pattern)
+		if err != nil {
+			d.setErr(errors.Wrap(err, "cannot parse generated file pattern"))
+			return
+		}
+		d.generated = generated
+	}
+}
+
+// WithMaxBytes causes hashing to be abandoned with an error matching
+// ErrSizeLimitExceeded once more than the specified number of bytes of file
+// contents, counted after their line endings are normalized, would be written
+// to the hash, which guards against hashing an unexpectedly large tree, such
+// as a home directory reached by mistake. No more than a copy buffer's worth
+// of bytes beyond the limit are read. When verifying a dependency tree, the
+// limit applies to each dependency separately. A limit that is not positive
+// causes no limit to be applied.
+func WithMaxBytes(max int64) DigestOption {
+	return func(d *Digester) {
+		d.maxBytes = max
+	}
+}
+
+// WithMaxDepth causes hashing to be abandoned with an error matching
+// ErrMaxDepthExceeded when a directory is nested more than the specified
+// number of levels beneath the hashed directory, which guards against trees
+// inflated by deep nesting, or by symbolic links when they are followed. The
+// hashed directory is at level 0, the directories directly beneath it at level
+// 1, and so on, so the files in a directory at the limit are still hashed.
+//
+// Only the directories which would be hashed count: a directory skipped by name,
+// such as a VCS directory, or by an ignore pattern or a filter, is never
+// descended into, so nothing beneath it exceeds the limit. When verifying a
+// dependency tree, levels are counted from each dependency's directory. A
+// limit that is not positive causes no limit to be applied.
+func WithMaxDepth(max int) DigestOption {
+	return func(d *Digester) {
+		d.walk.maxDepth = max
+	}
+}
+
+// WithWalkFunc causes the specified function to be invoked for each file system
+// node written to the hash, in the order in which they are written, such as to
+// log or measure the progress of hashing a large tree, without changing the
+// digest. The function is invoked with the slash-separated pathname of each
+// node relative to the hashed directory, which is empty for the hashed
+// directory itself, and its file info, before the node is written.
+//
+// When the function returns an error, hashing is aborted, and that error is
+// returned. Unlike with filepath.Walk, returning filepath.SkipDir also aborts
+// hashing, because skipping a node would change the digest; use WithFilter to
+// skip nodes.
+func WithWalkFunc(fn func(slashRelative string, info os.FileInfo) error) DigestOption {
+	return func(d *Digester) {
+		d.walkFunc = fn
+	}
+}
+
+// WithTrailingWhitespaceStripping causes the spaces and tabs at the end of
+// each line of every regular file to be removed before it is hashed when strip
+// is true, so that reformatting which only changes trailing whitespace does
+// not change the digest. Lines are those ended by LF, after CRLF line endings
+// are normalized, so the spaces and tabs at the end of a file which does not
+// end with a line ending are still hashed, as are those before a CR when
+// contents are hashed raw.
+//
+// The digests computed with this option differ from those of DigestFromDirectory
+// for every tree with trailing whitespace, and are the same for trees which
+// differ only in trailing whitespace, including files in which trailing
+// whitespace is significant. They cannot be verified by CheckDepTree.
+func WithTrailingWhitespaceStripping(strip bool) DigestOption {
+	return func(d *Digester) {
+		d.stripTrailingWhitespace = strip
+	}
+}
+
+// WithReaderFunc causes the contents of each regular file to be read through
+// the reader returned by the specified function, when invoked with a reader of
+// those contents, so that callers are able to normalize contents in ways this
+// package does not. The function is applied after the normalization done by
+// this package, such as converting line endings, so to replace rather than
+// extend it, combine this option with WithRawContents. The size hashed after a
+// file's contents is the number of bytes read from the returned reader.
+//
+// The function must transform the same contents into the same bytes every
+// time, without depending on anything else, such as the time or the order in
+// which files are read, or the digests it produces are not reproducible. An
+// error returned by the reader is returned as an error reading the file.
+func WithReaderFunc(wrap func(io.Reader) io.Reader) DigestOption {
+	return func(d *Digester) {
+		d.wrapReader = wrap
+	}
+}
+
+// WithRawContents causes file contents to be hashed without normalizing their
+// line endings when raw is true, as described by DigestFromDirectoryRaw.
+func WithRawContents(raw bool) DigestOption {
+	return func(d *Digester) {
+		d.raw = raw
+	}
+}
+
+// WithLoneCRConversion causes a CR not followed by LF to be converted to LF
+// when convert is true, as described by DigestFromDirectoryConvertingLoneCR.
+func WithLoneCRConversion(convert bool) DigestOption {
+	return func(d *Digester) {
+		d.convertLoneCR = convert
+	}
+}
+
+// WithSizeOmission causes the size of each regular file's contents not to be
+// hashed after them when omit is true, so that the digest only depends on the
+// pathnames, types, and contents of the nodes. This suits callers which
+// normalize contents in ways that change their size spuriously.
+//
+// The size is what delimits a file's contents from the pathname of the next
+// node, so without it, distinct trees are able to have the same digest: for
+// instance, when the contents of a file end with what could be the pathname
+// and type of an empty file that follows it. Digests computed with this option
+// are therefore only suitable for detecting accidental changes.
+func WithSizeOmission(omit bool) DigestOption {
+	return func(d *Digester) {
+		d.omitSize = omit
+	}
+}
+
+// WithSizeSeparator causes a NULL byte to be hashed between each regular file's
+// contents and its size when separate is true, so that the contents are
+// explicitly delimited from the size that follows them.
+//
+// The default framing is already unambiguous: because the size counts the
+// contents it follows, no two distinct contents followed by their sizes are
+// the same sequence of bytes, whatever digits the contents end with. The
+// separator suits other tools which cannot parse the framing that way, such
+// as those which split it on NULL bytes. It produces different digests, so
+// digests computed with this option only match digests computed with it.
+func WithSizeSeparator(separate bool) DigestOption {
+	return func(d *Digester) {
+		d.sizeSeparator = separate
+	}
+}
+
+// WithFixedWidthSizes causes the size of each regular file's contents to be
+// hashed after them as 8 big-endian bytes when fixed is true, rather than as a
+// variable length decimal string followed by a NULL byte, so that every file's
+// contents are followed by a size of the same width, which is simpler for
+// other tools to reproduce when composing digests.
+//
+// The two encodings produce different digests of the same tree, so digests
+// computed with this option only match digests computed with it.
+func WithFixedWidthSizes(fixed bool) DigestOption {
+	return func(d *Digester) {
+		d.fixedWidthSize = fixed
+	}
+}
+
+// WithChangeDetection causes hashing a tree to fail with an error matching
+// ErrFileChangedDuringHash when detect is true, and a regular file changes
+// while its contents are being read, such as when the tree is being written
+// at the same time, rather than producing a digest which describes no
+// consistent state of the tree. This option does not change the digests
+// computed.
+//
+// A file is considered to have changed when the number of bytes read from it
+// differs from its size before it was read, or when its size or modification
+// time afterwards differ from those before. A file rewritten in place with
+// contents of the same size, more quickly than the file system records
+// modification times, is therefore not detected.
+func WithChangeDetection(detect bool) DigestOption {
+	return func(d *Digester) {
+		d.detectChanges = detect
+	}
+}
+
+// WithBOMStripping causes a UTF-8 byte order mark at the start of a file to be
+// removed before it is hashed when strip is true, as described by
+// DigestFromDirectoryStrippingBOM.
+func WithBOMStripping(strip bool) DigestOption {
+	return func(d *Digester) {
+		d.stripBOM = strip
+	}
+}
+
+// WithModTimes causes the modification time of every node, including each
+// directory and the hashed directory itself, to be hashed after its type when
+// include is true, so that the digest changes whenever a modification time
+// does, such as to audit that a tree was not touched since it was hashed.
+//
+// Modification times are set by whatever writes a tree, so two checkouts of
+// the same sources never have the same digest with this option, and neither
+// does a tree whose files were merely copied. These digests are only
+// comparable to digests of the very same tree, and never match those verified
+// by CheckDepTree. Modification times are only as precise as the file system
+// records them.
+func WithModTimes(include bool) DigestOption {
+	return func(d *Digester) {
+		d.includeModTime = include
+	}
+}
+
+// WithPerm causes the permission bits of regular files to be hashed when
+// include is true, as described by DigestFromDirectoryWithPerm.
+func WithPerm(include bool) DigestOption {
+	return func(d *Digester) {
+		d.includePerm = include
+	}
+}
+
+// WithMmap causes regular files at least as large as the specified threshold
+// to be memory mapped rather than read, as described by
+// DigestFromDirectoryWithMmap. A threshold of zero disables memory mapping.
+// This option does not change the digests computed.
+func WithMmap(threshold int64) DigestOption {
+	return func(d *Digester) {
+		d.mmapThreshold = threshold
+	}
+}
+
+// WithEmptyDigestRejection causes CheckDepTree to fail when reject is true and
+// any locked dependency which is present in the tree has an empty digest,
+// rather than reporting the dependency as EmptyDigestInLock, for policies
+// under which an empty digest in a lock file is a mistake. This option does
+// not change the digests computed.
+func WithEmptyDigestRejection(reject bool) DigestOption {
+	return func(d *Digester) {
+		d.rejectEmptyDigests = reject
+	}
+}
+
+// WithOpenErrorHandler causes the specified function to be invoked with the
+// pathname of each regular file that cannot be opened, such as a file without
+// read permission, and the resulting error. When the function returns true,
+// the file is hashed as though it were empty, and hashing continues;
+// otherwise the error is returned as usual.
+//
+// A skipped file's pathname and type are still hashed, so the digest remains
+// reproducible for a tree with the same files skipped, while differing from
+// that of the tree with the file readable. Errors encountered while reading a
+// file that was opened are always returned, because part of its contents may
+// already have been hashed.
+func WithOpenErrorHandler(onOpenError func(osPathname string, err error) (skip bool)) DigestOption {
+	return func(d *Digester) {
+		d.onOpenError = onOpenError
+	}
+}
+
+// WithSalt causes the specified salt, such as the name of the subsystem using
+// the digests, to be written to the hash before any node of a tree, so that
+// the digests computed for different purposes, such as caching and integrity,
+// are separated: a tree has a different digest under each salt, and the same
+// digest under the same salt. An empty salt writes nothing to the hash, so the
+// digests are those of a Digester created without this option.
+func WithSalt(salt string) DigestOption {
+	return func(d *Digester) {
+		d.salt = salt
+	}
+}
+
+// WithFileSystem causes trees to be found and read in the specified file
+// system, such as one held in memory, rather than in the one provided by the
+// os package, which is used when fsys is nil. This applies to hashing a tree
+// as well as to verifying a dependency tree.
+//
+// The digest of a tree does not depend on the file system in which it is
+// found, although WithMmap only memory maps the files which are opened as an
+// *os.File, and symbolic links are only followed, or hashed along with the
+// types of their referents, by resolving them with Lstat and Readlink.
+func WithFileSystem(fsys FileSystem) DigestOption {
+	return func(d *Digester) {
+		d.walk.fsys = fsys
+	}
+}
+
+// WithRetry causes each operation on a single file system node, such as
+// opening, listing, or reading it, which fails with a transient error, to be
+// attempted again up to the specified number of times before the error is
+// returned, waiting for the specified backoff before the first retry, and
+// twice as long as before each retry after it. Only the failed operation is
+// retried, so the nodes already hashed are not hashed again, and a file which
+// fails while being read is read onwards from where it failed.
+//
+// An error is transient when its Temporary or Timeout method, as implemented
+// by syscall.Errno and by the errors of network file systems, reports true,
+// such as for EAGAIN, EINTR, or ETIMEDOUT. Other errors are returned at once.
+// A negative number of retries causes an error when the Digester is used.
+func WithRetry(retries int, backoff time.Duration) DigestOption {
+	return func(d *Digester) {
+		if retries < 0 {
+			d.setErr(errors.Errorf("cannot retry file system operations %d times", retries))
+			return
+		}
+		d.walk.retry = retryPolicy{retries: retries, backoff: backoff}
+	}
+}
+
+// setErr records the specified error, unless an earlier option already failed.
+func (d *Digester) setErr(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+// newClosure returns a dirWalkClosure which writes to the specified hash
+// according to the Digester's options.
+func (d *Digester) newClosure(h hash.Hash) *dirWalkClosure {
+	closure := newDirWalkClosure(h)
+	closure.digestOptions = d.digestOptions
+	return closure
+}
+
+// Digest returns a hash of the specified directory contents.
+//
+// Because the options of the Digester determine how the hash is computed, the
+// result is the raw digest rather than a VersionedDigest: HashVersion only
+// describes digests computed by a Digester created without options.
+func (d *Digester) Digest(osDirname string) ([]byte, error) {
+	return d.DigestContext(context.Background(), osDirname)
+}
+
+// DigestContext returns a hash of the specified directory contents, exactly as
+// Digest does, unless the specified context is cancelled before the hash is
+// complete, in which case it returns the context's error.
+func (d *Digester) DigestContext(ctx context.Context, osDirname string) ([]byte, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	// Create a single hash instance for the entire operation, rather than a new
+	// hash for each node we encounter.
+	closure := d.newClosure(d.newHash())
+	defer closure.release()
+	closure.ctx = ctx
+
+	return closure.digest(osDirname)
+}
+
+// Hasher computes hash digests of directory trees, one after another, exactly
+// as the Digester from which it was created does, but reuses a single hash and
+// copy buffer for all of them, which are reset before each tree is hashed,
+// rather than allocating new ones each time. This makes it suited to hashing
+// many small trees in a loop.
+//
+// The copy buffer is borrowed from a pool shared with every Digester, so a
+// Hasher ought to be closed once it is no longer needed, which returns the
+// buffer to the pool. Digest returns an error once the Hasher is closed.
+//
+// Unlike a Digester, a Hasher is not safe for concurrent use. Goroutines that
+// hash trees concurrently ought to each create their own.
+type Hasher struct {
+	err     error // error encountered while applying the Digester's options
+	closure *dirWalkClosure
+}
+
+// NewHasher returns a Hasher which computes the same digests as the Digester.
+func (d *Digester) NewHasher() *Hasher {
+	if d.err != nil {
+		return &Hasher{err: d.err}
+	}
+	return &Hasher{closure: d.newClosure(d.newHash())}
+}
+
+// Digest returns a hash of the specified directory contents, exactly as the
+// Digest method of the Digester from which the Hasher was created does.
+func (h *Hasher) Digest(osDirname string) ([]byte, error) {
+	if h.err != nil {
+		return nil, h.err
+	}
+
+	h.closure.someHash.Reset()
+	h.closure.bytesHashed = 0
+	return h.closure.digest(osDirname)
+}
+
+// errHasherClosed is the error returned by the Digest method of a closed
+// Hasher.
+var errHasherClosed = errors.New("cannot hash tree: Hasher is closed")
+
+// Close releases the copy buffer of the Hasher, after which Digest returns an
+// error. Closing a Hasher more than once has no effect. The returned error is
+// always nil; Close returns one so that a Hasher is an io.Closer.
+func (h *Hasher) Close() error {
+	if h.closure != nil {
+		h.closure.release()
+		h.closure = nil
+	}
+	if h.err == nil {
+		h.err = errHasherClosed
+	}
+	return nil
+}
+
+// CheckDepTree verifies a dependency tree exactly as the CheckDepTree function
+// does, but computes the digest of each dependency according to the
+// Digester's options. Tagged digests are still computed using the algorithm
+// identified by their tag.
+func (d *Digester) CheckDepTree(osDirname string, wantDigests map[string]VersionedDigest) (map[string]VendorStatus, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	checker := depTreeChecker{ctx: context.Background(), digester: d}
+	return checker.check(osDirname, wantDigests)
+}
+
+// versionedDigest returns the specified digest as a VersionedDigest of the
+// current HashVersion, unless the specified error is not nil.
+func versionedDigest(digest []byte, err error) (VersionedDigest, error) {
+	if err != nil {
+		return VersionedDigest{}, err
+	}
+
+	return VersionedDigest{
+		HashVersion: HashVersion,
+		Digest:      digest,
+	}, nil
+}
@@ -0,0 +1,1423 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewDigesterDefaults(t *testing.T) {
+	osDirname := getTestdataVerifyRoot(t)
+
+	got, err := NewDigester().Digest(osDirname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := DigestFromDirectory(osDirname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want.Digest) {
+		t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", got, want.Digest)
+	}
+}
+
+func TestNewDigesterCombinesOptions(t *testing.T) {
+	withExtras := mkTestTree(t, map[string]string{
+		"a.go":         "\xEF\xBB\xBFpackage a\r\n",
+		"a_test.go":    "package a\n",
+		"tools/t.go":   "package tools\n",
+		"sub/b.go":     "package sub\r",
+		"sub/vendor/x": "x",
+	})
+	defer os.RemoveAll(withExtras)
+	withoutExtras := mkTestTree(t, map[string]string{
+		"a.go":         "package a\n",
+		"sub/b.go":     "package sub\n",
+		"sub/vendor/x": "x",
+	})
+	defer os.RemoveAll(withoutExtras)
+
+	d := NewDigester(
+		WithHashAlgo(SHA512),
+		WithSkipDirs(map[string]bool{"vendor": true, "tools": true}),
+		WithVendor(true),
+		WithIgnores([]string{"*_test.go"}),
+		WithBOMStripping(true),
+		WithLoneCRConversion(true),
+	)
+	got, err := d.Digest(withExtras)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewDigester(WithHashAlgo(SHA512), WithSkipDirs(map[string]bool{})).Digest(withoutExtras)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", got, want)
+	}
+}
+
+func TestWithAdditionalSkipDirs(t *testing.T) {
+	d := NewDigester(WithAdditionalSkipDirs(".fossil"))
+
+	withFossil := mkTestTree(t, map[string]string{
+		"a.go":              "package a\n",
+		".fossil/manifest":  "C initial\n",
+		".git/HEAD":         "ref: refs/heads/master\n",
+		"sub/.fossil/x":     "x",
+		"sub/b.go":          "package sub\n",
+		"sub/vendor/c/c.go": "package c\n",
+	})
+	defer os.RemoveAll(withFossil)
+	withoutFossil := mkTestTree(t, map[string]string{
+		"a.go":     "package a\n",
+		"sub/b.go": "package sub\n",
+	})
+	defer os.RemoveAll(withoutFossil)
+
+	got, err := d.Digest(withFossil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewDigester().Digest(withoutFossil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", got, want)
+	}
+	if DefaultSkipDirs[".fossil"] {
+		t.Error("Expected DefaultSkipDirs to be unchanged")
+	}
+
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go":            "package alice1\n",
+		"github.com/alice/alice1/.fossil/manifest": "C initial\n",
+		".fossil/manifest":                         "C initial\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+
+	digest, err := d.Digest(filepath.Join(vendorRoot, "github.com/alice/alice1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := d.CheckDepTree(vendorRoot, map[string]VersionedDigest{
+		"github.com/alice/alice1": {HashVersion: HashVersion, Digest: digest},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus := map[string]VendorStatus{"github.com/alice/alice1": NoMismatch}
+	if !reflect.DeepEqual(status, wantStatus) {
+		t.Errorf("(GOT): %v; (WNT): %v", status, wantStatus)
+	}
+}
+
+func TestWithSizeSeparator(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		"a.go": "package a\n",
+	})
+	defer os.RemoveAll(dir)
+
+	got, err := NewDigester(WithSizeSeparator(true)).Digest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := sha256.New()
+	for _, field := range [][]byte{{}, {0, 0, 0, 0x80}, []byte("a.go"), {0, 0, 0, 0}} {
+		writeBytesWithNull(h, field)
+	}
+	h.Write([]byte("package a\n"))
+	writeBytesWithNull(h, nil)
+	writeBytesWithNull(h, []byte("10"))
+	if want := h.Sum(nil); !bytes.Equal(got, want) {
+		t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", got, want)
+	}
+
+	dflt, err := NewDigester().Digest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(got, dflt) {
+		t.Error("Expected size separator to change the digest")
+	}
+}
+
+func TestWithFixedWidthSizes(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		"a.go": "package a\n",
+	})
+	defer os.RemoveAll(dir)
+
+	got, err := NewDigester(WithFixedWidthSizes(true)).Digest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dflt, err := NewDigester().Digest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(got, dflt) {
+		t.Fatal("Expected fixed width sizes to change the digest")
+	}
+
+	h := sha256.New()
+	for _, field := range [][]byte{{}, {0, 0, 0, 0x80}, []byte("a.go"), {0, 0, 0, 0}} {
+		writeBytesWithNull(h, field)
+	}
+	h.Write([]byte("package a\n"))
+	h.Write([]byte{0, 0, 0, 0, 0, 0, 0, 10})
+	if want := h.Sum(nil); !bytes.Equal(got, want) {
+		t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", got, want)
+	}
+
+	// Omitting sizes takes precedence.
+	omitted, err := NewDigester(WithFixedWidthSizes(true), WithSizeOmission(true)).Digest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewDigester(WithSizeOmission(true)).Digest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(omitted, want) {
+		t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", omitted, want)
+	}
+}
+
+func TestWithModTimes(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		"a.go":     "package a\n",
+		"sub/b.go": "package sub\n",
+	})
+	defer os.RemoveAll(dir)
+
+	withModTimes := NewDigester(WithModTimes(true))
+	digest := func(d *Digester) []byte {
+		t.Helper()
+		got, err := d.Digest(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	setModTime := func(slashRelative string, modTime time.Time) {
+		t.Helper()
+		if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(slashRelative)), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	epoch := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, slashRelative := range []string{"a.go", "sub/b.go", "sub", "."} {
+		setModTime(slashRelative, epoch)
+	}
+	dflt, stamped := digest(NewDigester()), digest(withModTimes)
+	if bytes.Equal(dflt, stamped) {
+		t.Fatal("Expected modification times to change the digest")
+	}
+
+	// Touching any node, including a directory, changes the digest only when
+	// modification times are hashed.
+	for _, slashRelative := range []string{"a.go", "sub/b.go", "sub", "."} {
+		setModTime(slashRelative, epoch.Add(time.Second))
+		if got := digest(withModTimes); bytes.Equal(got, stamped) {
+			t.Errorf("%s: expected touching the node to change the digest", slashRelative)
+		}
+		if got := digest(NewDigester()); !bytes.Equal(got, dflt) {
+			t.Errorf("%s: expected touching the node not to change the default digest", slashRelative)
+		}
+		setModTime(slashRelative, epoch)
+	}
+}
+
+func TestWithGeneratedFileSkipping(t *testing.T) {
+	withGenerated := mkTestTree(t, map[string]string{
+		"a.go":          "package a\n",
+		"a_string.go":   "// Code generated by \"stringer -type=A\"; DO NOT EDIT.\n\npackage a\n",
+		"b.pb.go":       "// Code generated by protoc-gen-go. DO NOT EDIT.\r\npackage a\r\n",
+		"c.go":          "// Package a is not generated.\n// Code generated by hand. DO NOT EDIT.\npackage a\n",
+		"d.go":          "// Code generated by hand. DO NOT EDIT.", // no line ending
+		"sub/z_gen.go":  "// Code generated by go generate. DO NOT EDIT.\n",
+		"sub/e.go":      "package sub\n",
+		"sub/empty.txt": "",
+	})
+	defer os.RemoveAll(withGenerated)
+	withoutGenerated := mkTestTree(t, map[string]string{
+		"a.go":          "package a\n",
+		"c.go":          "// Package a is not generated.\n// Code generated by hand. DO NOT EDIT.\npackage a\n",
+		"sub/e.go":      "package sub\n",
+		"sub/empty.txt": "",
+	})
+	defer os.RemoveAll(withoutGenerated)
+
+	want, err := NewDigester().Digest(withoutGenerated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []*Digester{
+		NewDigester(WithGeneratedFileSkipping(GeneratedFilePattern)),
+		NewDigester(WithGeneratedFileSkipping(GeneratedFilePattern), WithMmap(1)),
+	} {
+		got, err := d.Digest(withGenerated)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", got, want)
+		}
+	}
+
+	// A custom pattern, or none, selects other files.
+	got, err := NewDigester(WithGeneratedFileSkipping(`^package a$`)).Digest(withoutGenerated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(got, want) {
+		t.Error("Expected custom pattern to skip a.go")
+	}
+	got, err = NewDigester(WithGeneratedFileSkipping(GeneratedFilePattern), WithGeneratedFileSkipping("")).Digest(withGenerated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dflt, err := NewDigester().Digest(withGenerated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, dflt) {
+		t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", got, dflt)
+	}
+
+	if _, err = NewDigester(WithGeneratedFileSkipping("(")).Digest(withGenerated); err == nil {
+		t.Error("Expected error from invalid pattern")
+	}
+}
+
+func TestWithMaxBytes(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		"a.go":     "package a\n",              // 10 bytes
+		"sub/b.go": "package sub\r\n",          // 12 bytes, once normalized
+		"sub/c.go": strings.Repeat("c", 10000), // spans several copy buffers
+	})
+	defer os.RemoveAll(dir)
+
+	want, err := NewDigester().Digest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, max := range []int64{0, -1, 10022} {
+		got, err := NewDigester(WithMaxBytes(max)).Digest(dir)
+		if err != nil {
+			t.Fatalf("%d: %s", max, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%d: \n(GOT):\n\t%x\n(WNT):\n\t%x", max, got, want)
+		}
+	}
+
+	for _, max := range []int64{1, 10, 22, 10021} {
+		_, err := NewDigester(WithMaxBytes(max)).Digest(dir)
+		de, ok := err.(*DigestError)
+		if !ok || de.Err != ErrSizeLimitExceeded {
+			t.Errorf("%d: (GOT): %v; (WNT): %v", max, err, ErrSizeLimitExceeded)
+		}
+	}
+
+	// The limit applies to each dependency separately.
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go": "package alice1\n",
+		"github.com/bob/bob1/b1.go":     "package bob1\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+	d := NewDigester(WithMaxBytes(int64(len("package alice1\n"))))
+	wantDigests := make(map[string]VersionedDigest)
+	for _, slashPathname := range []string{"github.com/alice/alice1", "github.com/bob/bob1"} {
+		digest, err := d.Digest(filepath.Join(vendorRoot, filepath.FromSlash(slashPathname)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantDigests[slashPathname] = VersionedDigest{HashVersion: HashVersion, Digest: digest}
+	}
+	if _, err = d.CheckDepTree(vendorRoot, wantDigests); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWithMaxDepth(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		"a.go":                 "package a\n",
+		"b/c/d/e.go":           "package d\n",
+		".git/objects/ab/cdef": "x",
+		"vendor/x/y/z/w.go":    "package z\n",
+	})
+	defer os.RemoveAll(dir)
+
+	want, err := NewDigester().Digest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, max := range []int{0, -1, 3, 4} {
+		got, err := NewDigester(WithMaxDepth(max)).Digest(dir)
+		if err != nil {
+			t.Fatalf("%d: %s", max, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%d: \n(GOT):\n\t%x\n(WNT):\n\t%x", max, got, want)
+		}
+	}
+
+	for max, osTooDeep := range map[int]string{1: "b/c", 2: "b/c/d"} {
+		_, err := NewDigester(WithMaxDepth(max)).Digest(dir)
+		de, ok := err.(*DigestError)
+		if !ok || de.Err != ErrMaxDepthExceeded || de.Pathname != filepath.Join(dir, filepath.FromSlash(osTooDeep)) {
+			t.Errorf("%d: (GOT): %v; (WNT): %v at %s", max, err, ErrMaxDepthExceeded, osTooDeep)
+		}
+	}
+
+	// Filtering out the deep directory keeps the tree within the limit.
+	_, err = NewDigester(WithMaxDepth(1), WithFilter(func(slashRelative string, _ os.FileInfo) bool {
+		return slashRelative != "b/c"
+	})).Digest(dir)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWithWalkFunc(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		"a.go":       "package a\n",
+		".git/HEAD":  "ref: refs/heads/master\n",
+		"sub/b.go":   "package sub\n",
+		"sub/c/d.go": "package c\n",
+	})
+	defer os.RemoveAll(dir)
+
+	var visited []string
+	got, err := NewDigester(WithWalkFunc(func(slashRelative string, info os.FileInfo) error {
+		if info == nil {
+			t.Errorf("%q: expected file info", slashRelative)
+		}
+		visited = append(visited, slashRelative)
+		return nil
+	})).Digest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewDigester().Digest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", got, want)
+	}
+	wantVisited := []string{"", "a.go", "sub", "sub/b.go", "sub/c", "sub/c/d.go"}
+	if !reflect.DeepEqual(visited, wantVisited) {
+		t.Errorf("(GOT): %v; (WNT): %v", visited, wantVisited)
+	}
+
+	for _, abort := range []error{errors.New("abort"), filepath.SkipDir} {
+		visited = nil
+		_, err = NewDigester(WithWalkFunc(func(slashRelative string, _ os.FileInfo) error {
+			visited = append(visited, slashRelative)
+			if slashRelative == "sub" {
+				return abort
+			}
+			return nil
+		})).Digest(dir)
+		if err != abort {
+			t.Errorf("(GOT): %v; (WNT): %v", err, abort)
+		}
+		if wantVisited := []string{"", "a.go", "sub"}; !reflect.DeepEqual(visited, wantVisited) {
+			t.Errorf("(GOT): %v; (WNT): %v", visited, wantVisited)
+		}
+	}
+}
+
+func TestWithTrailingWhitespaceStripping(t *testing.T) {
+	withWhitespace := mkTestTree(t, map[string]string{
+		"a.go":     "package a \r\n\nfunc A() {\t\n}\n",
+		"sub/b.go": "package sub\t \n",
+	})
+	defer os.RemoveAll(withWhitespace)
+	withoutWhitespace := mkTestTree(t, map[string]string{
+		"a.go":     "package a\n\nfunc A() {\n}\n",
+		"sub/b.go": "package sub\n",
+	})
+	defer os.RemoveAll(withoutWhitespace)
+
+	d := NewDigester(WithTrailingWhitespaceStripping(true))
+	got, err := d.Digest(withWhitespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewDigester().Digest(withoutWhitespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", got, want)
+	}
+
+	dflt, err := NewDigester().Digest(withWhitespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(dflt, want) {
+		t.Error("Expected trailing whitespace to change the default digest")
+	}
+}
+
+// upperCaseReader converts the ASCII letters read from its source to upper
+// case.
+type upperCaseReader struct {
+	src io.Reader
+}
+
+func (r upperCaseReader) Read(buf []byte) (int, error) {
+	n, err := r.src.Read(buf)
+	copy(buf, bytes.ToUpper(buf[:n]))
+	return n, err
+}
+
+// failingReader returns its error from every Read.
+type failingReader struct {
+	err error
+}
+
+func (r failingReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestWithReaderFunc(t *testing.T) {
+	lower := mkTestTree(t, map[string]string{
+		"a.go":     "package a\r\n",
+		"sub/b.go": "package sub\n",
+	})
+	defer os.RemoveAll(lower)
+	upper := mkTestTree(t, map[string]string{
+		"a.go":     "PACKAGE A\n",
+		"sub/b.go": "PACKAGE SUB\n",
+	})
+	defer os.RemoveAll(upper)
+
+	toUpper := func(r io.Reader) io.Reader { return upperCaseReader{r} }
+	got, err := NewDigester(WithReaderFunc(toUpper)).Digest(lower)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewDigester().Digest(upper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", got, want)
+	}
+
+	// The function replaces normalization when contents are raw.
+	got, err = NewDigester(WithRawContents(true), WithReaderFunc(toUpper)).Digest(lower)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(got, want) {
+		t.Error("Expected raw contents not to have their line endings normalized")
+	}
+
+	// The size hashed is that of the transformed contents.
+	got, err = NewDigester(WithReaderFunc(func(r io.Reader) io.Reader {
+		return io.MultiReader(r, strings.NewReader("// appended\n"))
+	})).Digest(lower)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appended := mkTestTree(t, map[string]string{
+		"a.go":     "package a\n// appended\n",
+		"sub/b.go": "package sub\n// appended\n",
+	})
+	defer os.RemoveAll(appended)
+	if want, err = NewDigester().Digest(appended); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", got, want)
+	}
+
+	// Errors from the reader are errors reading the file.
+	failure := errors.New("transform failed")
+	_, err = NewDigester(WithReaderFunc(func(io.Reader) io.Reader {
+		return failingReader{failure}
+	})).Digest(lower)
+	if de, ok := err.(*DigestError); !ok || de.Op != "Copy" || de.Err != failure {
+		t.Errorf("(GOT): %v; (WNT): %v", err, failure)
+	}
+}
+
+// failingAfterReader reads from another reader, then returns its error once
+// the specified number of bytes have been read.
+type failingAfterReader struct {
+	src       io.Reader
+	remaining int
+	err       error
+}
+
+func (r *failingAfterReader) Read(buf []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, r.err
+	}
+	if len(buf) > r.remaining {
+		buf = buf[:r.remaining]
+	}
+	n, err := r.src.Read(buf)
+	r.remaining -= n
+	return n, err
+}
+
+func TestDigestErrorOffset(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		"a.go":    "package a\n",
+		"big.bin": strings.Repeat("\x00\x01", 5000), // spans several copy buffers
+	})
+	defer os.RemoveAll(dir)
+
+	failure := errors.New("flaky mount")
+	cases := []struct {
+		n          int
+		osRelative string // file which fails, at offset n
+	}{
+		{0, "a.go"},
+		{1, "a.go"},
+		{4096, "big.bin"}, // a.go is shorter, so it is read without failing
+		{6000, "big.bin"},
+	}
+	for _, c := range cases {
+		_, err := NewDigester(WithReaderFunc(func(r io.Reader) io.Reader {
+			return &failingAfterReader{src: r, remaining: c.n, err: failure}
+		})).Digest(dir)
+		de, ok := err.(*DigestError)
+		if !ok || de.Op != "Copy" || de.Err != failure {
+			t.Fatalf("%d: (GOT): %v; (WNT): %v", c.n, err, failure)
+		}
+		if want := filepath.Join(dir, c.osRelative); de.Pathname != want {
+			t.Errorf("%d: (GOT): %v; (WNT): %v", c.n, de.Pathname, want)
+		}
+		if g, w := de.Offset, int64(c.n); g != w {
+			t.Errorf("%d: (GOT): %v; (WNT): %v", c.n, g, w)
+		}
+		if want := fmt.Sprintf(" at offset %d: flaky mount", c.n); !strings.HasSuffix(err.Error(), want) {
+			t.Errorf("%d: (GOT): %v; (WNT): suffix %q", c.n, err, want)
+		}
+	}
+
+	// Other operations do not report an offset.
+	if err := (&DigestError{Op: "Open", Pathname: "a.go", Err: failure}).Error(); err != `cannot Open "a.go": flaky mount` {
+		t.Errorf("(GOT): %v", err)
+	}
+}
+
+// beforeFirstReadReader invokes a function once, before the first Read from
+// its source, so that a test is able to change a file while it is being read.
+type beforeFirstReadReader struct {
+	src    io.Reader
+	before func()
+}
+
+func (r *beforeFirstReadReader) Read(buf []byte) (int, error) {
+	if r.before != nil {
+		r.before()
+		r.before = nil
+	}
+	return r.src.Read(buf)
+}
+
+func TestWithChangeDetection(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		"a.go":     "package a\n",
+		"sub/b.go": strings.Repeat("package sub\n", 1000),
+	})
+	defer os.RemoveAll(dir)
+	osPathname := filepath.Join(dir, "sub/b.go")
+
+	want, err := NewDigester().Digest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewDigester(WithChangeDetection(true)).Digest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", got, want)
+	}
+
+	changes := map[string]func() error{
+		"Grow": func() error {
+			fh, err := os.OpenFile(osPathname, os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return err
+			}
+			if _, err = fh.WriteString("// appended while hashing\n"); err != nil {
+				_ = fh.Close()
+				return err
+			}
+			return fh.Close()
+		},
+		"Shrink": func() error {
+			return os.Truncate(osPathname, 100)
+		},
+	}
+	for name, change := range changes {
+		t.Run(name, func(t *testing.T) {
+			var changeErr error
+			wrap := func(r io.Reader) io.Reader {
+				return &beforeFirstReadReader{src: r, before: func() {
+					if changeErr == nil {
+						changeErr = change()
+					}
+				}}
+			}
+			if err := ioutil.WriteFile(osPathname, []byte(strings.Repeat("package sub\n", 1000)), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := NewDigester(WithChangeDetection(true), WithFilter(func(slashRelative string, _ os.FileInfo) bool {
+				return slashRelative != "a.go"
+			}), WithReaderFunc(wrap)).Digest(dir)
+			if changeErr != nil {
+				t.Fatal(changeErr)
+			}
+			de, ok := err.(*DigestError)
+			if !ok || de.Err != ErrFileChangedDuringHash || de.Pathname != osPathname {
+				t.Errorf("(GOT): %v; (WNT): %v", err, ErrFileChangedDuringHash)
+			}
+
+			// Without the option, the change goes unnoticed.
+			changeErr = nil
+			if err := ioutil.WriteFile(osPathname, []byte(strings.Repeat("package sub\n", 1000)), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = NewDigester(WithReaderFunc(wrap)).Digest(dir); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestNewDigesterBailsOnBadOption(t *testing.T) {
+	for _, d := range []*Digester{
+		NewDigester(WithHashAlgo(HashAlgo(255))),
+		NewDigester(WithIgnores([]string{"["})),
+	} {
+		if _, err := d.Digest(getTestdataVerifyRoot(t)); err == nil {
+			t.Error("expected error from Digest")
+		}
+		if _, err := d.CheckDepTree(getTestdataVerifyRoot(t), nil); err == nil {
+			t.Error("expected error from CheckDepTree")
+		}
+		h := d.NewHasher()
+		if _, err := h.Digest(getTestdataVerifyRoot(t)); err == nil {
+			t.Error("expected error from Hasher")
+		}
+		h.Close()
+	}
+}
+
+func TestHasher(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go":     "package alice1\n",
+		"github.com/alice/alice2/a2.go":     "package alice2\r\n",
+		"github.com/alice/alice2/sub/s.go":  "package sub\n",
+		"github.com/bob/bob1/b1.go":         strings.Repeat("b", 10000),
+		"github.com/charlie/charlie1/c1.go": "package charlie1\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+	osDirnames := []string{
+		filepath.Join(vendorRoot, "github.com/alice/alice1"),
+		filepath.Join(vendorRoot, "github.com/alice/alice2"),
+		filepath.Join(vendorRoot, "github.com/bob/bob1"),
+		filepath.Join(vendorRoot, "github.com/missing/missing1"),
+		filepath.Join(vendorRoot, "github.com/charlie/charlie1"),
+		filepath.Join(vendorRoot, "github.com/alice/alice1"),
+	}
+
+	for _, d := range []*Digester{
+		NewDigester(),
+		NewBlake2bDigester(),
+		NewDigester(WithMaxBytes(10000)),
+	} {
+		h := d.NewHasher()
+		defer h.Close()
+		for _, osDirname := range osDirnames {
+			want, wantErr := d.Digest(osDirname)
+			got, err := h.Digest(osDirname)
+			if (err == nil) != (wantErr == nil) {
+				t.Fatalf("%s: (GOT): %v; (WNT): %v", osDirname, err, wantErr)
+			}
+			// The outcome of hashing one tree, including a failure, has no
+			// effect on the next.
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s: \n(GOT):\n\t%x\n(WNT):\n\t%x", osDirname, got, want)
+			}
+		}
+	}
+}
+
+func TestHasherClose(t *testing.T) {
+	h := NewDigester().NewHasher()
+	if _, err := h.Digest(getTestdataVerifyRoot(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if h.closure != nil {
+		t.Error("expected Close to release the closure")
+	}
+	if got, err := h.Digest(getTestdataVerifyRoot(t)); err != errHasherClosed {
+		t.Errorf("(GOT): %x, %v; (WNT): %v", got, err, errHasherClosed)
+	}
+	if err := h.Close(); err != nil {
+		t.Errorf("second Close: (GOT): %v; (WNT): nil", err)
+	}
+}
+
+func BenchmarkHasherManyProjects(b *testing.B) {
+	vendorRoot, wantDigests := mkSyntheticVendorTree(b, 500)
+	defer os.RemoveAll(vendorRoot)
+	var osDirnames []string
+	for slashPathname := range wantDigests {
+		osDirnames = append(osDirnames, filepath.Join(vendorRoot, filepath.FromSlash(slashPathname)))
+	}
+
+	b.Run("Digester", func(b *testing.B) {
+		d := NewDigester()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, osDirname := range osDirnames {
+				if _, err := d.Digest(osDirname); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("Hasher", func(b *testing.B) {
+		h := NewDigester().NewHasher()
+		defer h.Close()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, osDirname := range osDirnames {
+				if _, err := h.Digest(osDirname); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+func TestDigesterCheckDepTree(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go":      "package alice1\r\n",
+		"github.com/alice/alice1/a1_test.go": "package alice1\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+
+	d := NewDigester(WithRawContents(true), WithIgnores([]string{"*_test.go"}))
+	digest, err := d.Digest(filepath.Join(vendorRoot, "github.com/alice/alice1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDigests := map[string]VersionedDigest{
+		"github.com/alice/alice1": {HashVersion: HashVersion, Digest: digest},
+	}
+
+	got, err := d.CheckDepTree(vendorRoot, wantDigests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]VendorStatus{"github.com/alice/alice1": NoMismatch}; !reflect.DeepEqual(got, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", got, want)
+	}
+
+	got, err = CheckDepTree(vendorRoot, wantDigests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]VendorStatus{"github.com/alice/alice1": DigestMismatchInLock}; !reflect.DeepEqual(got, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", got, want)
+	}
+}
+
+func TestWithSymlinkTargets(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		"a.go":     "package a\n",
+		"sub/b.go": "package sub\n",
+	})
+	defer os.RemoveAll(dir)
+	osLinkname := filepath.Join(dir, "link")
+	relink := func(referent string) {
+		t.Helper()
+		_ = os.Remove(osLinkname)
+		if err := os.Symlink(referent, osLinkname); err != nil {
+			t.Skipf("cannot create symlink: %s", err)
+		}
+	}
+
+	without, err := NewDigester().Digest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDigester(WithSymlinkTargets(true))
+	digests := make(map[string][]byte)
+	for _, referent := range []string{"a.go", "sub", "missing.go", "sub/b.go"} {
+		relink(referent)
+		if digests[referent], err = d.Digest(dir); err != nil {
+			t.Fatal(err)
+		}
+
+		// Without the option, links are still ignored.
+		got, err := NewDigester().Digest(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, without) {
+			t.Errorf("%s: \n(GOT):\n\t%x\n(WNT):\n\t%x", referent, got, without)
+		}
+	}
+	for referent, digest := range digests {
+		if bytes.Equal(digest, without) {
+			t.Errorf("%s: link did not change the digest", referent)
+		}
+		for other, otherDigest := range digests {
+			if other != referent && bytes.Equal(digest, otherDigest) {
+				t.Errorf("%s and %s have the same digest", referent, other)
+			}
+		}
+	}
+
+	// Creating the referent of a dangling link changes the digest, though
+	// the link does not change.
+	relink("missing.go")
+	dangling, err := d.Digest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dangling, digests["missing.go"]) {
+		t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", dangling, digests["missing.go"])
+	}
+	if err = os.Mkdir(filepath.Join(dir, "missing.go"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	resolvable, err := d.Digest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(resolvable, dangling) {
+		t.Error("resolving the link did not change the digest")
+	}
+	// Even when the referent itself is not hashed.
+	linkOnly, err := NewDigester(WithSymlinkTargets(true), WithIgnores([]string{"/missing.go"})).Digest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(linkOnly, dangling) {
+		t.Error("resolving the link did not change the digest of the link alone")
+	}
+}
+
+func TestWithFollowSymlinks(t *testing.T) {
+	shared := mkTestTree(t, map[string]string{
+		"cache/c.go": "package cache\n",
+		"note.txt":   "shared\n",
+	})
+	defer os.RemoveAll(shared)
+	linked := mkTestTree(t, map[string]string{
+		"a.go": "package a\n",
+	})
+	defer os.RemoveAll(linked)
+	copied := mkTestTree(t, map[string]string{
+		"a.go":       "package a\n",
+		"cache/c.go": "package cache\n",
+		"note.txt":   "shared\n",
+	})
+	defer os.RemoveAll(copied)
+
+	if err := os.Symlink(filepath.Join(shared, "cache"), filepath.Join(linked, "cache")); err != nil {
+		t.Skipf("cannot create symlink: %s", err)
+	}
+	if err := os.Symlink(filepath.Join(shared, "note.txt"), filepath.Join(linked, "note.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	follower := NewDigester(WithFollowSymlinks(true))
+	got, err := follower.Digest(linked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewDigester().Digest(copied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", got, want)
+	}
+
+	dflt, err := NewDigester().Digest(linked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(dflt, want) {
+		t.Error("Expected symlinks to be ignored by default")
+	}
+
+	t.Run("CheckDepTree", func(t *testing.T) {
+		vendorRoot := mkTestTree(t, map[string]string{
+			"github.com/alice/alice1/a1.go": "package alice1\n",
+		})
+		defer os.RemoveAll(vendorRoot)
+		if err := os.Symlink(linked, filepath.Join(vendorRoot, "github.com/alice/alice2")); err != nil {
+			t.Fatal(err)
+		}
+
+		status, err := follower.CheckDepTree(vendorRoot, map[string]VersionedDigest{
+			"github.com/alice/alice2": {HashVersion: HashVersion, Digest: want},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantStatus := map[string]VendorStatus{
+			"github.com/alice/alice1": NotInLock,
+			"github.com/alice/alice2": NoMismatch,
+		}
+		if !reflect.DeepEqual(status, wantStatus) {
+			t.Errorf("(GOT): %v; (WNT): %v", status, wantStatus)
+		}
+	})
+
+	t.Run("Cycle", func(t *testing.T) {
+		if err := os.Symlink("..", filepath.Join(shared, "cache", "loop")); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(filepath.Join(shared, "cache", "loop"))
+
+		_, err := follower.Digest(linked)
+		if err == nil {
+			t.Fatal("expected error for symlink cycle")
+		}
+		if got, want := err.Error(), "symlink cycle"; !strings.Contains(got, want) {
+			t.Errorf("(GOT): %v; (WNT): %v", got, want)
+		}
+	})
+
+	t.Run("Broken", func(t *testing.T) {
+		broken := filepath.Join(linked, "broken")
+		if err := os.Symlink(filepath.Join(shared, "missing"), broken); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(broken)
+
+		if _, err := follower.Digest(linked); err == nil {
+			t.Fatal("expected error for broken symlink")
+		}
+	})
+}
+
+func TestWithOpenErrorHandler(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("the super user is able to open files without permission")
+	}
+
+	dir := mkTestTree(t, map[string]string{
+		"a.go":      "package a\n",
+		"secret.go": "package a // secret\n",
+	})
+	defer os.RemoveAll(dir)
+	empty := mkTestTree(t, map[string]string{
+		"a.go":      "package a\n",
+		"secret.go": "",
+	})
+	defer os.RemoveAll(empty)
+
+	secret := filepath.Join(dir, "secret.go")
+	if err := os.Chmod(secret, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(secret, 0644)
+
+	if _, err := NewDigester().Digest(dir); err == nil {
+		t.Fatal("expected error for unreadable file")
+	}
+
+	var skipped []string
+	d := NewDigester(WithOpenErrorHandler(func(osPathname string, err error) bool {
+		skipped = append(skipped, osPathname)
+		return os.IsPermission(err)
+	}))
+	got, err := d.Digest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{secret}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", skipped, want)
+	}
+	want, err := NewDigester().Digest(empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", got, want)
+	}
+
+	refuser := NewDigester(WithOpenErrorHandler(func(string, error) bool { return false }))
+	if _, err := refuser.Digest(dir); err == nil {
+		t.Fatal("expected error when handler does not skip the file")
+	}
+}
+
+func TestWithCaseCollisionCheck(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		"README":       "read me\n",
+		"sub/b.go":     "package sub\n",
+		"sub/Makefile": "all:\n",
+	})
+	defer os.RemoveAll(dir)
+
+	d := NewDigester(WithCaseCollisionCheck(true))
+	got, err := d.Digest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewDigester().Digest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", got, want)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "sub/makefile"), []byte("all:\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	children, err := ioutil.ReadDir(filepath.Join(dir, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(children) != 3 {
+		t.Skip("file system is case-insensitive")
+	}
+	if _, err := NewDigester().Digest(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Digest(dir); err == nil {
+		t.Fatal("expected error for names differing only in case")
+	}
+}
+
+func TestFoldName(t *testing.T) {
+	testCases := []struct {
+		a, b string
+	}{
+		{"readme", "README"},
+		{"ReadMe.md", "rEADmE.MD"},
+		{"kelvin", "\u212Aelvin"}, // KELVIN SIGN folds to k
+		{"σίσυφος", "ΣΊΣΥΦΟΣ"},
+		{"ς", "σ"},
+		{"a.go", "b.go"},
+		{"straße", "STRASSE"}, // not equal under simple folding
+	}
+	for _, testCase := range testCases {
+		if got, want := foldName(testCase.a) == foldName(testCase.b), strings.EqualFold(testCase.a, testCase.b); got != want {
+			t.Errorf("%q, %q: (GOT): %v; (WNT): %v", testCase.a, testCase.b, got, want)
+		}
+	}
+}
+
+func TestWithEmptyDigestRejection(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go": "package alice1\n",
+		"github.com/bob/bob1/b1.go":     "package bob1\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+
+	alice1, err := DigestFromDirectory(filepath.Join(vendorRoot, "github.com/alice/alice1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDigests := map[string]VersionedDigest{
+		"github.com/alice/alice1": alice1,
+		"github.com/bob/bob1":     {HashVersion: HashVersion, Digest: []byte{}},
+	}
+
+	got, err := NewDigester().CheckDepTree(vendorRoot, wantDigests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]VendorStatus{
+		"github.com/alice/alice1": NoMismatch,
+		"github.com/bob/bob1":     EmptyDigestInLock,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", got, want)
+	}
+
+	strict := NewDigester(WithEmptyDigestRejection(true))
+	if _, err := strict.CheckDepTree(vendorRoot, wantDigests); err == nil {
+		t.Fatal("expected error for empty digest")
+	}
+
+	// An empty digest for a dependency which is not in the tree is not
+	// rejected, because the dependency is reported as NotInTree.
+	delete(wantDigests, "github.com/bob/bob1")
+	wantDigests["github.com/eve/eve1"] = VersionedDigest{HashVersion: HashVersion, Digest: []byte{}}
+	got, err = strict.CheckDepTree(vendorRoot, wantDigests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]VendorStatus{
+		"github.com/alice/alice1": NoMismatch,
+		"github.com/bob":          NotInLock,
+		"github.com/eve/eve1":     NotInTree,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", got, want)
+	}
+}
+
+func TestWithFilter(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		"LICENSE":           "license\n",
+		"README.md":         "read me\n",
+		"a.go":              "package a\n",
+		"sub/b.go":          "package sub\n",
+		"sub/b.c":           "int b;\n",
+		"testdata/c.go":     "package testdata\n",
+		"vendor/x/x.go":     "package x\n",
+		".git/hooks/pre.go": "package hooks\n",
+	})
+	defer os.RemoveAll(dir)
+	filtered := mkTestTree(t, map[string]string{
+		"LICENSE":  "license\n",
+		"a.go":     "package a\n",
+		"sub/b.go": "package sub\n",
+	})
+	defer os.RemoveAll(filtered)
+
+	var seen []string
+	d := NewDigester(WithFilter(func(slashRelative string, info os.FileInfo) bool {
+		seen = append(seen, slashRelative)
+		if info.IsDir() {
+			return slashRelative != "testdata"
+		}
+		return strings.HasSuffix(slashRelative, ".go") || path.Base(slashRelative) == "LICENSE"
+	}))
+	got, err := d.Digest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewDigester().Digest(filtered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", got, want)
+	}
+
+	// The filter is not consulted for the root, nor for skipped nodes, nor for
+	// the nodes beneath a directory it rejects.
+	if wantSeen := []string{"LICENSE", "README.md", "a.go", "sub", "sub/b.c", "sub/b.go", "testdata"}; !reflect.DeepEqual(seen, wantSeen) {
+		t.Errorf("(GOT): %v; (WNT): %v", seen, wantSeen)
+	}
+}
+
+func TestWithBuildTarget(t *testing.T) {
+	files := map[string]string{
+		"README.md":        "read me\n",
+		"a.go":             "package a\n",
+		"a_linux.go":       "package a\n",
+		"a_windows.go":     "package a\n",
+		"a_linux_arm64.go": "package a\n",
+		"a_amd64.s":        "TEXT ·f(SB),0,$0\n", // not a Go source file, so always hashed
+		"tagged.go":        "// +build darwin,amd64\n\npackage a\n",
+		"negated.go":       "//go:build !windows\n// +build !windows\n\npackage a\n",
+		"cgo.go":           "// +build cgo\n\npackage a\n\nimport \"C\"\n",
+		"sub/b_darwin.go":  "package sub\n",
+		"sub/b_test.go":    "package sub\n",
+		"_ignored.go":      "package a\n",
+	}
+	dir := mkTestTree(t, files)
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		goos, goarch string
+		want         []string
+	}{
+		{"linux", "amd64", []string{"README.md", "a.go", "a_linux.go", "a_amd64.s", "negated.go", "sub/b_test.go"}},
+		{"linux", "arm64", []string{"README.md", "a.go", "a_linux.go", "a_linux_arm64.go", "a_amd64.s", "negated.go", "sub/b_test.go"}},
+		{"windows", "amd64", []string{"README.md", "a.go", "a_windows.go", "a_amd64.s", "sub/b_test.go"}},
+		{"darwin", "amd64", []string{"README.md", "a.go", "a_amd64.s", "tagged.go", "negated.go", "sub/b_darwin.go", "sub/b_test.go"}},
+	}
+	for _, c := range cases {
+		matching := make(map[string]string, len(c.want))
+		for _, slashRelative := range c.want {
+			matching[slashRelative] = files[slashRelative]
+		}
+		// The cgo constraint is only satisfied for the host, when cgo is
+		// enabled for it.
+		if build.Default.GOOS == c.goos && build.Default.GOARCH == c.goarch && build.Default.CgoEnabled {
+			matching["cgo.go"] = files["cgo.go"]
+		}
+		filtered := mkTestTree(t, matching)
+		defer os.RemoveAll(filtered)
+		if err := os.MkdirAll(filepath.Join(filtered, "sub"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := NewDigester(WithBuildTarget(c.goos, c.goarch)).Digest(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := NewDigester().Digest(filtered)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s/%s: \n(GOT):\n\t%x\n(WNT):\n\t%x", c.goos, c.goarch, got, want)
+		}
+	}
+
+	if _, err := NewDigester(WithBuildTarget("linux", "")).Digest(dir); err == nil {
+		t.Error("expected error without an architecture")
+	}
+}
+
+func TestWithSizeOmission(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		"a.go": "package a\n",
+	})
+	defer os.RemoveAll(dir)
+
+	omitter := NewDigester(WithSizeOmission(true))
+	digest := func(d *Digester) []byte {
+		t.Helper()
+		got, err := d.Digest(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	withSize, withoutSize := digest(NewDigester()), digest(omitter)
+	if bytes.Equal(withSize, withoutSize) {
+		t.Fatal("Expected omitting sizes to change the digest")
+	}
+
+	// Without sizes, the digest is that of the pathnames, types, and contents
+	// alone.
+	h := sha256.New()
+	for _, field := range [][]byte{{}, {0, 0, 0, 0x80}, []byte("a.go"), {0, 0, 0, 0}} {
+		writeBytesWithNull(h, field)
+	}
+	h.Write([]byte("package a\n"))
+	if want := h.Sum(nil); !bytes.Equal(withoutSize, want) {
+		t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", withoutSize, want)
+	}
+
+	// Changing contents changes the digest either way.
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte("package b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(digest(NewDigester()), withSize) || bytes.Equal(digest(omitter), withoutSize) {
+		t.Error("Expected changed contents to change the digest")
+	}
+}
+
+func TestWithSalt(t *testing.T) {
+	files := map[string]string{
+		"a.go":     "package a\n",
+		"sub/b.go": "package sub\n",
+	}
+	dir := mkTestTree(t, files)
+	defer os.RemoveAll(dir)
+	same := mkTestTree(t, files) // byte-identical copy of the tree
+	defer os.RemoveAll(same)
+
+	unsalted, err := NewDigester().Digest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digests := make(map[string][]byte)
+	for _, salt := range []string{"cache", "integrity"} {
+		got, err := NewDigester(WithSalt(salt)).Digest(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(got, unsalted) {
+			t.Errorf("%s: salt did not change the digest", salt)
+		}
+		again, err := NewDigester(WithSalt(salt)).Digest(same)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(again, got) {
+			t.Errorf("%s: \n(GOT):\n\t%x\n(WNT):\n\t%x", salt, again, got)
+		}
+		digests[salt] = got
+	}
+	if bytes.Equal(digests["cache"], digests["integrity"]) {
+		t.Error("different salts produced the same digest")
+	}
+
+	// An empty salt is no salt at all.
+	got, err := NewDigester(WithSalt("")).Digest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, unsalted) {
+		t.Errorf("empty: \n(GOT):\n\t%x\n(WNT):\n\t%x", got, unsalted)
+	}
+
+	// The salt is written, NULL terminated, ahead of the directory itself.
+	empty, err := ioutil.TempDir("", "dep-verify-salt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(empty)
+	got, err = NewDigester(WithSalt("cache")).Digest(empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sha256.Sum256([]byte("cache\x00\x00\x00\x00\x00\x80\x00")); !bytes.Equal(got, want[:]) {
+		t.Errorf("framing: \n(GOT):\n\t%x\n(WNT):\n\t%x", got, want)
+	}
+
+	// Only a Digester with the same salt verifies a salted digest.
+	vendor := mkTestTree(t, map[string]string{"github.com/alice/alice1/a.go": "package alice1\n"})
+	defer os.RemoveAll(vendor)
+	salted, err := NewDigester(WithSalt("cache")).Digest(filepath.Join(vendor, "github.com/alice/alice1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDigests := map[string]VersionedDigest{"github.com/alice/alice1": {HashVersion: HashVersion, Digest: salted}}
+	for salt, want := range map[string]VendorStatus{"cache": NoMismatch, "integrity": DigestMismatchInLock, "": DigestMismatchInLock} {
+		status, err := NewDigester(WithSalt(salt)).CheckDepTree(vendor, wantDigests)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := status["github.com/alice/alice1"]; got != want {
+			t.Errorf("%q: (GOT): %v; (WNT): %v", salt, got, want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// opFindRoot is the operation of the *DigestError returned when the directory
+// to be hashed does not exist.
+const opFindRoot = "find root"
+
+// opCopy is the operation of the *DigestError returned when the contents of a
+// file cannot be copied to the hash.
+const opCopy = "Copy"
+
+// ErrRootNotFound matches, using errors.Is, the *DigestError returned when the
+// directory to be hashed does not exist at all, which distinguishes a missing
+// tree, such as a dependency which is not vendored, from an error encountered
+// within the tree. The error returned by the operation still satisfies
+// os.IsNotExist.
+var ErrRootNotFound = errors.New("root directory not found")
+
+// ErrSizeLimitExceeded matches, using errors.Is, the *DigestError returned when
+// hashing a tree is abandoned because more bytes of file contents than the
+// limit set by WithMaxBytes would be written to the hash.
+var ErrSizeLimitExceeded = errors.New("size limit exceeded")
+
+// ErrMaxDepthExceeded matches, using errors.Is, the *DigestError returned when
+// hashing a tree is abandoned because it has a directory nested more deeply
+// than the limit set by WithMaxDepth.
+var ErrMaxDepthExceeded = errors.New("maximum depth exceeded")
+
+// ErrFileChangedDuringHash matches, using errors.Is, the *DigestError returned
+// when hashing a tree with WithChangeDetection is abandoned because a file
+// changed while its contents were being read, so the digest would describe no
+// consistent state of the tree.
+var ErrFileChangedDuringHash = errors.New("file changed during hash")
+
+// DigestError records an operation on a file system node that failed while
+// hashing or verifying a directory tree, so that callers are able to tell
+// which node could not be processed, and why.
+type DigestError struct {
+	Op       string // operation that failed, such as "Open" or "Stat"
+	Pathname string // pathname of the node on which the operation failed
+	Err      error  // error returned by the operation
+
+	// Offset is the number of bytes of a file's contents which had been
+	// written to the hash, after normalization, when copying them failed,
+	// which is where reading the file failed unless its line endings were
+	// normalized. It is zero for any operation other than "Copy".
+	Offset int64
+}
+
+func (e *DigestError) Error() string {
+	if e.Op == opCopy {
+		return "cannot " + e.Op + " " + strconv.Quote(e.Pathname) + " at offset " + strconv.FormatInt(e.Offset, 10) + ": " + e.Err.Error()
+	}
+	return "cannot " + e.Op + " " + strconv.Quote(e.Pathname) + ": " + e.Err.Error()
+}
+
+// Unwrap returns the error returned by the operation.
+func (e *DigestError) Unwrap() error { return e.Err }
+
+// Is reports whether the target is ErrRootNotFound, and the error records that
+// the directory to be hashed does not exist.
+func (e *DigestError) Is(target error) bool {
+	return target == ErrRootNotFound && e.Op == opFindRoot
+}
+
+// Cause returns the error returned by the operation, so that errors.Cause from
+// github.com/pkg/errors is able to find it.
+func (e *DigestError) Cause() error { return e.Err }
+
+// newCopyError returns a *DigestError describing the failure to copy the
+// contents of the specified file to the hash, after the specified number of
+// bytes were written, or nil when the specified error is nil.
+func newCopyError(pathname string, written int64, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &DigestError{Op: opCopy, Pathname: pathname, Err: err, Offset: written}
+}
+
+// newDigestError returns a *DigestError describing the specified failed
+// operation, or nil when the specified error is nil.
+func newDigestError(op, pathname string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &DigestError{Op: op, Pathname: pathname, Err: err}
+}
@@ -0,0 +1,119 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build go1.13
+
+package verify
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDigestErrorAs(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go": "package alice1\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+
+	// A symlink to itself cannot be resolved, even by the super user.
+	loop := filepath.Join(vendorRoot, "github.com/alice/loop")
+	if err := os.Symlink("loop", loop); err != nil {
+		t.Skipf("cannot create symlink: %s", err)
+	}
+
+	t.Run("DigestFromDirectory", func(t *testing.T) {
+		_, err := NewDigester(WithFollowSymlinks(true)).Digest(vendorRoot)
+
+		var de *DigestError
+		if !errors.As(err, &de) {
+			t.Fatalf("(GOT): %#v; (WNT): *DigestError", err)
+		}
+		if de.Op != "Stat" || de.Pathname != loop {
+			t.Errorf("(GOT): %q %q; (WNT): %q %q", de.Op, de.Pathname, "Stat", loop)
+		}
+		if !errors.Is(err, de.Err) {
+			t.Error("expected Unwrap to return the operation's error")
+		}
+	})
+
+	t.Run("CheckDepTree", func(t *testing.T) {
+		_, err := CheckDepTree(vendorRoot, nil)
+
+		var de *DigestError
+		if !errors.As(err, &de) {
+			t.Fatalf("(GOT): %#v; (WNT): *DigestError", err)
+		}
+		if de.Op != "Stat" || de.Pathname != loop {
+			t.Errorf("(GOT): %q %q; (WNT): %q %q", de.Op, de.Pathname, "Stat", loop)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		missing, err := ioutil.TempDir("", "dep-verify-missing")
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.RemoveAll(missing)
+
+		_, err = DigestFromDirectory(missing)
+		var de *DigestError
+		if !errors.As(err, &de) {
+			t.Fatalf("(GOT): %#v; (WNT): *DigestError", err)
+		}
+		if de.Pathname != missing || !os.IsNotExist(de.Err) {
+			t.Errorf("(GOT): %v; (WNT): not exist error for %q", de, missing)
+		}
+		if !errors.Is(err, ErrRootNotFound) {
+			t.Errorf("(GOT): %v; (WNT): %v", err, ErrRootNotFound)
+		}
+
+		_, err = DigestTree(missing)
+		if !errors.Is(err, ErrRootNotFound) {
+			t.Errorf("(GOT): %v; (WNT): %v", err, ErrRootNotFound)
+		}
+	})
+
+	t.Run("MissingChild", func(t *testing.T) {
+		// A dangling symlink is missing, but is not the root of the tree.
+		dangling := filepath.Join(vendorRoot, "github.com/alice/dangling")
+		if err := os.Symlink("missing", dangling); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(dangling)
+
+		_, err := NewDigester(WithFollowSymlinks(true)).Digest(filepath.Join(vendorRoot, "github.com/alice/alice1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = NewDigester(WithFollowSymlinks(true)).Digest(filepath.Join(vendorRoot, "github.com/alice"))
+		if err == nil {
+			t.Fatal("expected error for dangling symlink")
+		}
+		if errors.Is(err, ErrRootNotFound) {
+			t.Errorf("(GOT): %v; (WNT): not %v", err, ErrRootNotFound)
+		}
+	})
+}
+
+func TestErrSizeLimitExceeded(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go": "package alice1\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+
+	d := NewDigester(WithMaxBytes(1))
+	if _, err := d.Digest(vendorRoot); !errors.Is(err, ErrSizeLimitExceeded) {
+		t.Errorf("(GOT): %v; (WNT): %v", err, ErrSizeLimitExceeded)
+	}
+	wantDigests := map[string]VersionedDigest{
+		"github.com/alice/alice1": {HashVersion: HashVersion, Digest: []byte{1}},
+	}
+	if _, err := d.CheckDepTree(vendorRoot, wantDigests); !errors.Is(err, ErrSizeLimitExceeded) {
+		t.Errorf("(GOT): %v; (WNT): %v", err, ErrSizeLimitExceeded)
+	}
+}
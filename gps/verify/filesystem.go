@@ -0,0 +1,266 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FileSystem is a file system in which a Digester hashes and verifies trees,
+// in place of the one provided by the os package, such as a tree held in
+// memory. Open, Lstat, and Readlink behave as the functions of the os package
+// with the same names do, and ReadDir returns the file info of every node in
+// the specified directory, as Lstat would describe it, in any order. An error
+// for a node which does not exist ought to satisfy os.IsNotExist.
+//
+// Pathnames are joined from the pathname of the walked directory using the
+// separator of the host, exactly as they are when the os package is used.
+// Symbolic links are resolved using only Lstat and Readlink, so a file system
+// which has none need not implement Readlink beyond returning an error.
+type FileSystem interface {
+	Open(name string) (io.ReadCloser, error)
+	Lstat(name string) (os.FileInfo, error)
+	Readlink(name string) (string, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+}
+
+// lstat returns the file info of the specified node, as os.Lstat does, from
+// the walked file system.
+func (opts *walkOptions) lstat(osPathname string) (os.FileInfo, error) {
+	for retries := 0; ; retries++ {
+		fi, err := opts.lstatOnce(osPathname)
+		if !opts.retry.again(retries, err) {
+			return fi, err
+		}
+	}
+}
+
+// lstatOnce attempts lstat once, without retrying transient errors.
+func (opts *walkOptions) lstatOnce(osPathname string) (os.FileInfo, error) {
+	if opts.fsys == nil {
+		return os.Lstat(osPathname)
+	}
+	return opts.fsys.Lstat(osPathname)
+}
+
+// stat returns the file info of the specified node, or of its referent when
+// it is a symbolic link, as os.Stat does, from the walked file system.
+func (opts *walkOptions) stat(osPathname string) (os.FileInfo, error) {
+	for retries := 0; ; retries++ {
+		fi, err := opts.statOnce(osPathname)
+		if !opts.retry.again(retries, err) {
+			return fi, err
+		}
+	}
+}
+
+// statOnce attempts stat once, without retrying transient errors.
+func (opts *walkOptions) statOnce(osPathname string) (os.FileInfo, error) {
+	if opts.fsys == nil {
+		return os.Stat(osPathname)
+	}
+	osResolved, err := resolveSymlinks(opts.fsys, osPathname)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := opts.fsys.Lstat(osResolved)
+	if err != nil {
+		return nil, err
+	}
+	return &resolvedFileInfo{FileInfo: fi, name: filepath.Base(osPathname), osResolved: osResolved}, nil
+}
+
+// readlink returns the referent of the specified symbolic link, as os.Readlink
+// does, from the walked file system.
+func (opts *walkOptions) readlink(osPathname string) (string, error) {
+	for retries := 0; ; retries++ {
+		referent, err := opts.readlinkOnce(osPathname)
+		if !opts.retry.again(retries, err) {
+			return referent, err
+		}
+	}
+}
+
+// readlinkOnce attempts readlink once, without retrying transient errors.
+func (opts *walkOptions) readlinkOnce(osPathname string) (string, error) {
+	if opts.fsys == nil {
+		return os.Readlink(osPathname)
+	}
+	return opts.fsys.Readlink(osPathname)
+}
+
+// open opens the specified regular file for reading, as os.Open does, from the
+// walked file system.
+func (opts *walkOptions) open(osPathname string) (io.ReadCloser, error) {
+	for retries := 0; ; retries++ {
+		fh, err := opts.openOnce(osPathname)
+		if !opts.retry.again(retries, err) {
+			return fh, err
+		}
+	}
+}
+
+// openOnce attempts open once, without retrying transient errors.
+func (opts *walkOptions) openOnce(osPathname string) (io.ReadCloser, error) {
+	if opts.fsys == nil {
+		fh, err := os.Open(osPathname)
+		if err != nil {
+			return nil, err // not a nil *os.File, which is not a nil io.ReadCloser
+		}
+		return fh, nil
+	}
+	return opts.fsys.Open(osPathname)
+}
+
+// dirChildren returns the nodes of the specified directory of the walked file
+// system sorted by name, as sortedDirChildren does.
+func (opts *walkOptions) dirChildren(osDirname string) ([]dirChild, error) {
+	for retries := 0; ; retries++ {
+		children, err := opts.dirChildrenOnce(osDirname)
+		if !opts.retry.again(retries, err) {
+			return children, err
+		}
+	}
+}
+
+// dirChildrenOnce attempts dirChildren once, without retrying transient errors.
+func (opts *walkOptions) dirChildrenOnce(osDirname string) ([]dirChild, error) {
+	if opts.fsys == nil {
+		return sortedDirChildren(osDirname)
+	}
+
+	infos, err := opts.fsys.ReadDir(osDirname)
+	if err != nil {
+		return nil, newDigestError("ReadDir", osDirname, err)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	children := make([]dirChild, len(infos))
+	for i, info := range infos {
+		children[i] = fileInfoChild{info}
+	}
+	return children, nil
+}
+
+// comparable returns file info of the specified directory which sameFile is
+// able to compare with that of other directories. The file info of the os
+// package is always comparable, whereas the directories of another file system
+// are identified by their pathnames once every symbolic link in them is
+// resolved.
+func (opts *walkOptions) comparable(osDirname string, info os.FileInfo) (os.FileInfo, error) {
+	if _, ok := info.(*resolvedFileInfo); ok || opts.fsys == nil {
+		return info, nil
+	}
+	return opts.stat(osDirname)
+}
+
+// sameFile reports whether the specified file info, as returned by comparable,
+// describe the same node, as os.SameFile does.
+func (opts *walkOptions) sameFile(fi1, fi2 os.FileInfo) bool {
+	if opts.fsys == nil {
+		return os.SameFile(fi1, fi2)
+	}
+	r1, ok1 := fi1.(*resolvedFileInfo)
+	r2, ok2 := fi2.(*resolvedFileInfo)
+	return ok1 && ok2 && r1.osResolved == r2.osResolved
+}
+
+// resolvedFileInfo is the file info of the referent of a pathname of a custom
+// file system, named as the pathname's final element, as os.Stat names it.
+type resolvedFileInfo struct {
+	os.FileInfo
+	name       string
+	osResolved string // pathname of the node once every symbolic link in it is resolved
+}
+
+func (fi *resolvedFileInfo) Name() string { return fi.name }
+
+// maxSymlinkHops is the greatest number of symbolic links followed while
+// resolving a single pathname, beyond which the links are assumed to form a
+// cycle, as they are by the os package.
+const maxSymlinkHops = 255
+
+// errTooManySymlinks is the error with which resolving a pathname is abandoned
+// after maxSymlinkHops symbolic links are followed.
+var errTooManySymlinks = errors.New("too many levels of symbolic links")
+
+// resolveSymlinks returns the specified pathname of the specified file system
+// once every symbolic link in it is resolved, as filepath.EvalSymlinks returns
+// it for the file system of the os package.
+func resolveSymlinks(fsys FileSystem, osPathname string) (string, error) {
+	resolved, rest := splitRoot(osPathname)
+	var hops int
+	for {
+		rest = strings.TrimLeft(rest, osPathSeparator)
+		if rest == "" {
+			break
+		}
+		name := rest
+		if i := strings.Index(rest, osPathSeparator); i >= 0 {
+			name, rest = rest[:i], rest[i:]
+		} else {
+			rest = ""
+		}
+
+		// The pathname resolved so far holds no symbolic links, so the
+		// parent of its final element is able to be found lexically.
+		osNext := filepath.Join(resolved, name)
+		if name == "." || name == ".." {
+			resolved = osNext
+			continue
+		}
+		fi, err := fsys.Lstat(osNext)
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = osNext
+			continue
+		}
+
+		if hops++; hops > maxSymlinkHops {
+			return "", errTooManySymlinks
+		}
+		referent, err := fsys.Readlink(osNext)
+		if err != nil {
+			return "", err
+		}
+		referentRoot, referentRest := splitRoot(referent)
+		if referentRoot != "" {
+			resolved = referentRoot
+		}
+		rest = referentRest + osPathSeparator + rest // referent is relative to the link's directory
+	}
+	if resolved == "" {
+		return ".", nil
+	}
+	return resolved, nil
+}
+
+// splitRoot splits the specified pathname into its volume name and leading
+// separator, when it is absolute, and the remainder relative to them.
+func splitRoot(osPathname string) (string, string) {
+	volume := filepath.VolumeName(osPathname)
+	rest := osPathname[len(volume):]
+	if strings.HasPrefix(rest, osPathSeparator) {
+		return volume + osPathSeparator, rest
+	}
+	return volume, rest
+}
+
+// fileInfoChild is a dirChild described by the file info with which it was
+// listed.
+type fileInfoChild struct {
+	os.FileInfo
+}
+
+func (child fileInfoChild) Type() os.FileMode          { return child.Mode() & os.ModeType }
+func (child fileInfoChild) Info() (os.FileInfo, error) { return child.FileInfo, nil }
@@ -0,0 +1,360 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// memNode is a file system node of a memFileSystem.
+type memNode struct {
+	mode os.FileMode // type of the node
+	data string      // contents of a regular file, or slash-separated referent of a symbolic link
+}
+
+// memFileSystem is a FileSystem held in memory, rooted at a pathname which
+// does not exist on disk, so that a Digester using it cannot accidentally
+// read a tree from the os package instead.
+type memFileSystem struct {
+	osRoot string
+	nodes  map[string]memNode // keyed by slash-separated pathname relative to the root, which is ""
+	errs   map[string]error   // returned by every method for the node at a slash-separated pathname
+}
+
+// newMemFileSystem returns a memFileSystem holding the specified regular files
+// and symbolic links, keyed by slash-separated pathname, along with the
+// directories enclosing them.
+func newMemFileSystem(files, links map[string]string) *memFileSystem {
+	fsys := &memFileSystem{
+		osRoot: filepath.FromSlash("/dep-verify-mem"),
+		nodes:  map[string]memNode{"": {mode: os.ModeDir}},
+		errs:   make(map[string]error),
+	}
+	add := func(slashPathname string, node memNode) {
+		fsys.nodes[slashPathname] = node
+		for slashParent := path.Dir(slashPathname); slashParent != "."; slashParent = path.Dir(slashParent) {
+			fsys.nodes[slashParent] = memNode{mode: os.ModeDir}
+		}
+	}
+	for slashPathname, contents := range files {
+		add(slashPathname, memNode{data: contents})
+	}
+	for slashPathname, referent := range links {
+		add(slashPathname, memNode{mode: os.ModeSymlink, data: referent})
+	}
+	return fsys
+}
+
+// resolve returns the slash-separated pathname, relative to the root, of the
+// node to which the specified pathname refers, once the symbolic links in all
+// but its final element are resolved, along with the final one when follow is
+// true.
+func (fsys *memFileSystem) resolve(name string, follow bool) (string, error) {
+	osRelative, err := filepath.Rel(fsys.osRoot, name)
+	if err != nil {
+		return "", &os.PathError{Op: "lookup", Path: name, Err: os.ErrNotExist}
+	}
+	slashRelative := filepath.ToSlash(osRelative)
+	if slashRelative == "." {
+		slashRelative = ""
+	}
+	if err = fsys.errs[slashRelative]; err != nil {
+		return "", err
+	}
+
+	var slashResolved string
+	elements := strings.Split(slashRelative, "/")
+	for hops := 0; len(elements) > 0; {
+		element := elements[0]
+		elements = elements[1:]
+		switch element {
+		case "", ".":
+			continue
+		case "..":
+			if slashResolved == "" {
+				return "", &os.PathError{Op: "lookup", Path: name, Err: os.ErrNotExist}
+			}
+			if slashResolved = path.Dir(slashResolved); slashResolved == "." {
+				slashResolved = ""
+			}
+			continue
+		}
+		slashNext := path.Join(slashResolved, element)
+		node, ok := fsys.nodes[slashNext]
+		if !ok {
+			return "", &os.PathError{Op: "lookup", Path: name, Err: os.ErrNotExist}
+		}
+		if node.mode&os.ModeSymlink != 0 && (len(elements) > 0 || follow) {
+			if hops++; hops > maxSymlinkHops {
+				return "", &os.PathError{Op: "lookup", Path: name, Err: errTooManySymlinks}
+			}
+			elements = append(strings.Split(node.data, "/"), elements...)
+			continue
+		}
+		slashResolved = slashNext
+	}
+	return slashResolved, nil
+}
+
+func (fsys *memFileSystem) Open(name string) (io.ReadCloser, error) {
+	slashResolved, err := fsys.resolve(name, true)
+	if err != nil {
+		return nil, err
+	}
+	if node := fsys.nodes[slashResolved]; node.mode.IsRegular() {
+		return ioutil.NopCloser(strings.NewReader(node.data)), nil // cannot be read at an offset
+	}
+	return nil, &os.PathError{Op: "open", Path: name, Err: errors.New("not a regular file")}
+}
+
+func (fsys *memFileSystem) Lstat(name string) (os.FileInfo, error) {
+	slashResolved, err := fsys.resolve(name, false)
+	if err != nil {
+		return nil, err
+	}
+	return memFileInfo{name: filepath.Base(name), node: fsys.nodes[slashResolved]}, nil
+}
+
+func (fsys *memFileSystem) Readlink(name string) (string, error) {
+	slashResolved, err := fsys.resolve(name, false)
+	if err != nil {
+		return "", err
+	}
+	if node := fsys.nodes[slashResolved]; node.mode&os.ModeSymlink != 0 {
+		return filepath.FromSlash(node.data), nil
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: errors.New("not a symbolic link")}
+}
+
+func (fsys *memFileSystem) ReadDir(name string) ([]os.FileInfo, error) {
+	slashResolved, err := fsys.resolve(name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !fsys.nodes[slashResolved].mode.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	var infos []os.FileInfo // in the random order of the map
+	for slashPathname, node := range fsys.nodes {
+		slashParent := path.Dir(slashPathname)
+		if slashParent == "." {
+			slashParent = ""
+		}
+		if slashPathname != "" && slashParent == slashResolved {
+			infos = append(infos, memFileInfo{name: path.Base(slashPathname), node: node})
+		}
+	}
+	return infos, nil
+}
+
+// memFileInfo is the file info of a node of a memFileSystem.
+type memFileInfo struct {
+	name string
+	node memNode
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return int64(len(fi.node.data)) }
+func (fi memFileInfo) ModTime() time.Time { return time.Unix(0, 0) }
+func (fi memFileInfo) IsDir() bool        { return fi.node.mode.IsDir() }
+func (fi memFileInfo) Sys() interface{}   { return nil }
+
+func (fi memFileInfo) Mode() os.FileMode {
+	switch {
+	case fi.node.mode.IsDir():
+		return fi.node.mode | 0755
+	case fi.node.mode&os.ModeSymlink != 0:
+		return fi.node.mode | 0777
+	}
+	return fi.node.mode | 0644
+}
+
+// mkTestFileSystems returns the pathname of a temporary directory, and a
+// memFileSystem, which both hold the specified regular files and symbolic
+// links, keyed by slash-separated pathname.
+func mkTestFileSystems(t *testing.T, files, links map[string]string) (string, *memFileSystem) {
+	t.Helper()
+
+	osDirname := mkTestTree(t, files)
+	for slashPathname, referent := range links {
+		osPathname := filepath.Join(osDirname, filepath.FromSlash(slashPathname))
+		if err := os.MkdirAll(filepath.Dir(osPathname), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.FromSlash(referent), osPathname); err != nil {
+			os.RemoveAll(osDirname)
+			t.Skipf("cannot create symlink: %s", err)
+		}
+	}
+	return osDirname, newMemFileSystem(files, links)
+}
+
+func TestWithFileSystem(t *testing.T) {
+	osDirname, fsys := mkTestFileSystems(t, map[string]string{
+		"a.go":              "package a\r\n",
+		"sub/b.go":          "package sub\n",
+		"sub/gen.go":        "// Code generated by x. DO NOT EDIT.\n\npackage sub\n",
+		"sub/deeper/c.txt":  strings.Repeat("c\r\n", 16*1024),
+		".git/HEAD":         "ref: refs/heads/master\n",
+		"sub/vendor/x/x.go": "package x\n",
+	}, map[string]string{
+		"file.link":  "a.go",
+		"dir.link":   "sub/deeper",
+		"sub/up.txt": "../a.go",
+	})
+	defer os.RemoveAll(osDirname)
+
+	cases := map[string][]DigestOption{
+		"Default":               nil,
+		"FollowSymlinks":        {WithFollowSymlinks(true)},
+		"SymlinkTargets":        {WithSymlinkTargets(true)},
+		"GeneratedFileSkipping": {WithGeneratedFileSkipping(GeneratedFilePattern)},
+		"ChangeDetection":       {WithChangeDetection(true)},
+		"Mmap":                  {WithMmap(1)},
+	}
+	for name, options := range cases {
+		t.Run(name, func(t *testing.T) {
+			want, err := NewDigester(options...).Digest(osDirname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := NewDigester(append(options, WithFileSystem(fsys))...).Digest(fsys.osRoot)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", got, want)
+			}
+		})
+	}
+
+	// A nil file system is the one provided by the os package.
+	want, err := DigestFromDirectory(osDirname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewDigester(WithFileSystem(fsys), WithFileSystem(nil)).Digest(osDirname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want.Digest) {
+		t.Errorf("nil: \n(GOT):\n\t%x\n(WNT):\n\t%x", got, want.Digest)
+	}
+}
+
+func TestWithFileSystemCheckDepTree(t *testing.T) {
+	osDirname, fsys := mkTestFileSystems(t, map[string]string{
+		"github.com/alice/alice1/a1.go": "package alice1\n",
+		"github.com/bob/bob1/b1.go":     "package bob1\n",
+		"github.com/eve/eve1/e1.go":     "package eve1\n",
+		"shared/s.go":                   "package shared\n",
+	}, map[string]string{
+		"github.com/alice/alice2": "alice1",
+		"github.com/dave":         "../shared",
+	})
+	defer os.RemoveAll(osDirname)
+
+	alice1, err := DigestFromDirectory(filepath.Join(osDirname, "github.com/alice/alice1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDigests := map[string]VersionedDigest{
+		"github.com/alice/alice1": alice1,
+		"github.com/alice/alice2": alice1,
+		"github.com/bob/bob1":     {HashVersion: HashVersion, Digest: []byte("mismatch")},
+		"github.com/carol/carol1": alice1,
+		"shared":                  {HashVersion: HashVersion, Digest: []byte("mismatch")},
+	}
+
+	want, err := NewDigester().CheckDepTree(osDirname, wantDigests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewDigester(WithFileSystem(fsys)).CheckDepTree(fsys.osRoot, wantDigests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", got, want)
+	}
+}
+
+func TestWithFileSystemSymlinkCycle(t *testing.T) {
+	cases := map[string]map[string]string{
+		"Ancestor": {"sub/up": ".."},
+		"Self":     {"sub/self": "."},
+		"Loop":     {"loop1": "loop2", "loop2": "loop1"},
+	}
+	for name, links := range cases {
+		t.Run(name, func(t *testing.T) {
+			fsys := newMemFileSystem(map[string]string{"a.go": "package a\n", "sub/b.go": "package sub\n"}, links)
+
+			// Links are ignored unless they are followed.
+			if _, err := NewDigester(WithFileSystem(fsys)).Digest(fsys.osRoot); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := NewDigester(WithFileSystem(fsys), WithFollowSymlinks(true)).Digest(fsys.osRoot); err == nil {
+				t.Errorf("(GOT): %x; (WNT): error", got)
+			}
+		})
+	}
+
+	fsys := newMemFileSystem(map[string]string{"a.go": "package a\n", "sub/b.go": "package sub\n"}, map[string]string{"sub/up": ".."})
+	_, err := NewDigester(WithFileSystem(fsys)).CheckDepTree(fsys.osRoot, map[string]VersionedDigest{
+		"other/project": {HashVersion: HashVersion, Digest: []byte("missing")},
+	})
+	if err == nil {
+		t.Fatal("expected error for symlink cycle")
+	}
+	if got, want := err.Error(), "symlink cycle"; !strings.Contains(got, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", got, want)
+	}
+}
+
+func TestWithFileSystemErrors(t *testing.T) {
+	failure := errors.New("injected failure")
+	cases := map[string]struct {
+		slashPathname string
+		op            string
+	}{
+		"Root":     {"", "Lstat"},
+		"Open":     {"sub/b.go", "Open"},
+		"ReadDir":  {"sub", "ReadDir"},
+		"Readlink": {"link", "Readlink"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			fsys := newMemFileSystem(map[string]string{"a.go": "package a\n", "sub/b.go": "package sub\n"}, map[string]string{"link": "a.go"})
+			fsys.errs[tc.slashPathname] = failure
+
+			_, err := NewDigester(WithFileSystem(fsys), WithSymlinkTargets(true)).Digest(fsys.osRoot)
+			de, ok := err.(*DigestError)
+			if !ok || de.Op != tc.op || de.Err != failure {
+				t.Errorf("(GOT): %v; (WNT): cannot %s: %v", err, tc.op, failure)
+			}
+		})
+	}
+
+	// A dependency which cannot be listed cannot be verified.
+	fsys := newMemFileSystem(map[string]string{"github.com/alice/alice1/a1.go": "package alice1\n"}, nil)
+	fsys.errs["github.com/alice/alice1"] = failure
+	_, err := NewDigester(WithFileSystem(fsys)).CheckDepTree(fsys.osRoot, map[string]VersionedDigest{
+		"github.com/alice/alice1": {HashVersion: HashVersion, Digest: []byte("unverifiable")},
+	})
+	if err == nil || !strings.Contains(err.Error(), failure.Error()) {
+		t.Errorf("(GOT): %v; (WNT): %v", err, failure)
+	}
+}
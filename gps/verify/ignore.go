@@ -0,0 +1,68 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ignorePattern is a parsed pattern matching file system nodes to be excluded
+// from a digest. See DigestFromDirectoryWithIgnores for the pattern syntax.
+type ignorePattern struct {
+	pattern  string // path.Match pattern, without leading or trailing solidus
+	anchored bool   // true iff pattern is matched against the entire relative pathname
+	dirOnly  bool   // true iff pattern only matches directories
+}
+
+// parseIgnorePatterns parses the specified ignore patterns, returning an error
+// for the first malformed pattern.
+func parseIgnorePatterns(ignores []string) ([]ignorePattern, error) {
+	patterns := make([]ignorePattern, 0, len(ignores))
+	for _, ignore := range ignores {
+		ip := ignorePattern{pattern: ignore}
+		if strings.HasSuffix(ip.pattern, "/") {
+			ip.dirOnly = true
+			ip.pattern = strings.TrimRight(ip.pattern, "/")
+		}
+		if strings.Contains(ip.pattern, "/") {
+			ip.anchored = true
+			ip.pattern = strings.TrimLeft(ip.pattern, "/")
+		}
+		if ip.pattern == "" {
+			return nil, errors.Errorf("empty ignore pattern: %q", ignore)
+		}
+		if _, err := path.Match(ip.pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "bad ignore pattern: %q", ignore)
+		}
+		patterns = append(patterns, ip)
+	}
+	return patterns, nil
+}
+
+// matchIgnorePatterns reports whether the node at the specified
+// slash-separated relative pathname matches any of the ignore patterns. The
+// root node, whose relative pathname is empty, is never matched.
+func matchIgnorePatterns(patterns []ignorePattern, slashRelative string, isDir bool) bool {
+	if slashRelative == "" {
+		return false
+	}
+	for _, ip := range patterns {
+		if ip.dirOnly && !isDir {
+			continue
+		}
+		name := slashRelative
+		if !ip.anchored {
+			name = path.Base(slashRelative)
+		}
+		// Patterns were validated when parsed, so Match cannot fail.
+		if matched, _ := path.Match(ip.pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMatchIgnorePatterns(t *testing.T) {
+	patterns, err := parseIgnorePatterns([]string{"*_test.go", "/fixtures", "internal/testdata", "big/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		slashRelative string
+		isDir         bool
+		want          bool
+	}{
+		{"", true, false},
+		{"a.go", false, false},
+		{"a_test.go", false, true},
+		{"sub/a_test.go", false, true},
+		{"fixtures", true, true},
+		{"sub/fixtures", true, false},
+		{"internal/testdata", true, true},
+		{"internal/testdata/x.go", false, false},
+		{"big", true, true},
+		{"big", false, false},
+		{"sub/big", true, true},
+	}
+
+	for _, tc := range testCases {
+		if got := matchIgnorePatterns(patterns, tc.slashRelative, tc.isDir); got != tc.want {
+			t.Errorf("%q (dir: %v): (GOT): %v; (WNT): %v", tc.slashRelative, tc.isDir, got, tc.want)
+		}
+	}
+}
+
+func TestParseIgnorePatternsBailsOnBadPattern(t *testing.T) {
+	for _, ignore := range []string{"[", "/", ""} {
+		if _, err := parseIgnorePatterns([]string{ignore}); err == nil {
+			t.Errorf("%q: expected error", ignore)
+		}
+	}
+}
+
+func TestDigestFromDirectoryWithIgnores(t *testing.T) {
+	withFixtures := mkTestTree(t, map[string]string{
+		"a.go":                  "package a\n",
+		"a_test.go":             "package a\n",
+		"fixtures/large.json":   "{}",
+		"sub/b.go":              "package sub\n",
+		"sub/fixtures/keep.txt": "kept, because /fixtures is anchored",
+		".git/HEAD":             "ref: refs/heads/master\n",
+	})
+	defer os.RemoveAll(withFixtures)
+	withoutFixtures := mkTestTree(t, map[string]string{
+		"a.go":                  "package a\n",
+		"sub/b.go":              "package sub\n",
+		"sub/fixtures/keep.txt": "kept, because /fixtures is anchored",
+	})
+	defer os.RemoveAll(withoutFixtures)
+
+	got, err := DigestFromDirectoryWithIgnores(withFixtures, []string{"*_test.go", "/fixtures"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := DigestFromDirectory(withoutFixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want.Digest) {
+		t.Errorf("\n(GOT):\n\t%#v\n(WNT):\n\t%#v", got, want.Digest)
+	}
+
+	if _, err := DigestFromDirectoryWithIgnores(withFixtures, []string{"["}); err == nil {
+		t.Error("expected error for bad pattern")
+	}
+}
+
+func TestDigestPackageOnly(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		"a.go":                   "package a\n",
+		"a_test.go":              "package a\n",
+		"testdata/golden.txt":    "golden\n",
+		"sub/b.go":               "package sub\n",
+		"sub/b_test.go":          "package sub\n",
+		"sub/testdata/input.txt": "input\n",
+		"sub/testdata.go":        "package sub // not a directory, so kept\n",
+	})
+	defer os.RemoveAll(dir)
+	withoutTests := mkTestTree(t, map[string]string{
+		"a.go":            "package a\n",
+		"sub/b.go":        "package sub\n",
+		"sub/testdata.go": "package sub // not a directory, so kept\n",
+	})
+	defer os.RemoveAll(withoutTests)
+
+	before, err := DigestPackageOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := DigestFromDirectory(withoutTests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, want.Digest) {
+		t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", before, want.Digest)
+	}
+
+	// Editing tests changes the full digest, but not the package-only one.
+	full, err := DigestFromDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, slashRelative := range []string{"a_test.go", "sub/testdata/input.txt"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(slashRelative)), []byte("edited\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	after, err := DigestPackageOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, before) {
+		t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", after, before)
+	}
+	edited, err := DigestFromDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(edited.Digest, full.Digest) {
+		t.Error("expected editing tests to change the full digest")
+	}
+
+	// Editing the package does change it.
+	if err = ioutil.WriteFile(filepath.Join(dir, "sub/b.go"), []byte("package sub // edited\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if after, err = DigestPackageOnly(dir); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(after, before) {
+		t.Error("expected editing the package to change the package-only digest")
+	}
+}
+
+// hiddenTestFiles is a tree of mixed hidden and visible nodes.
+var hiddenTestFiles = map[string]string{
+	"a.go":                           "package a\n",
+	".travis.yml":                    "language: go\n",
+	".github/workflows/ci.yml":       "on: push\n",
+	"sub/b.go":                       "package sub\n",
+	"sub/.env":                       "SECRET=1\n",
+	"sub/.hidden/c.go":               "package hidden\n",
+	"sub/not.hidden":                 "visible, despite the dot\n",
+	".git/HEAD":                      "ref: refs/heads/master\n", // always skipped
+	"vendor/github.com/x/.gitignore": "*.o\n",                    // always skipped
+}
+
+func TestDigestExcludingHidden(t *testing.T) {
+	dir := mkTestTree(t, hiddenTestFiles)
+	defer os.RemoveAll(dir)
+	visible := mkTestTree(t, map[string]string{
+		"a.go":           "package a\n",
+		"sub/b.go":       "package sub\n",
+		"sub/not.hidden": "visible, despite the dot\n",
+	})
+	defer os.RemoveAll(visible)
+
+	got, err := DigestExcludingHidden(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := DigestFromDirectory(visible)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want.Digest) {
+		t.Errorf("\n(GOT):\n\t%x\n(WNT):\n\t%x", got, want.Digest)
+	}
+
+	// Hidden nodes are hashed by default.
+	full, err := DigestFromDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(full.Digest, got) {
+		t.Error("hidden nodes did not change the full digest")
+	}
+}
+
+func TestListHidden(t *testing.T) {
+	dir := mkTestTree(t, hiddenTestFiles)
+	defer os.RemoveAll(dir)
+
+	got, err := ListHidden(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{".github", ".travis.yml", "sub/.env", "sub/.hidden"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("(GOT): %q; (WNT): %q", got, want)
+	}
+
+	visible := mkTestTree(t, map[string]string{"a.go": "package a\n"})
+	defer os.RemoveAll(visible)
+	if got, err = ListHidden(visible); err != nil || got != nil {
+		t.Errorf("(GOT): %q, %v; (WNT): none", got, err)
+	}
+
+	if _, err = ListHidden(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected error for missing directory")
+	}
+}
@@ -0,0 +1,18 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package verify
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// mmapFile always fails on this platform, so files are read instead.
+func mmapFile(fh *os.File, size int64) ([]byte, func() error, error) {
+	return nil, nil, errors.New("memory mapping files is not supported on this platform")
+}
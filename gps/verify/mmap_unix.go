@@ -0,0 +1,22 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package verify
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps the first size bytes of the specified open file into memory,
+// read only. The returned function unmaps the file again.
+func mmapFile(fh *os.File, size int64) ([]byte, func() error, error) {
+	data, err := syscall.Mmap(int(fh.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,238 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// fileContents holds the bytes written to the hash for a regular file, which
+// are its contents, normalized, followed by its size, or the error encountered
+// while reading them.
+type fileContents struct {
+	data []byte
+	err  error
+}
+
+// maxReadAheadSize is the size of the largest regular file whose contents are
+// read by a worker of DigestFromDirectoryParallel ahead of their turn to be
+// written to the hash. Larger files are read once it is their turn, so that
+// the memory held by files waiting for their turn is bounded.
+const maxReadAheadSize = 1 << 20
+
+// readAhead reports whether the contents of the regular file with the
+// specified file info are read ahead of their turn to be written to the hash.
+func readAhead(info os.FileInfo) bool {
+	return info.Size() <= maxReadAheadSize
+}
+
+// recordingHash is a hash.Hash which records the bytes written to it, so that
+// they are able to be written to another hash later.
+type recordingHash struct {
+	bytes.Buffer
+}
+
+func (h *recordingHash) Sum(b []byte) []byte { return append(b, h.Bytes()...) }
+func (h *recordingHash) Size() int           { return h.Len() }
+func (h *recordingHash) BlockSize() int      { return 1 }
+
+// readFileContents returns the bytes a closure with the specified options
+// writes to its hash for the specified regular file. They are read by a
+// closure of its own, so that it is able to run concurrently with the closures
+// of other files.
+func readFileContents(opts digestOptions, osPathname string) fileContents {
+	var recorded recordingHash
+	reader := newDirWalkClosure(&recorded)
+	defer reader.release()
+	reader.digestOptions = opts
+
+	err := reader.writeFile(osPathname)
+	return fileContents{data: recorded.Bytes(), err: err}
+}
+
+// DigestFromDirectoryParallel returns a hash of the specified directory
+// contents which is identical to the one returned by DigestFromDirectory, but
+// reads the contents of up to the specified number of files concurrently.
+//
+// The pathnames and contents of the file system nodes are still written to the
+// hash in the same order DigestFromDirectory writes them, and each file is read
+// exactly as DigestFromDirectory reads it. Like DigestFromDirectory, it always
+// uses the default options; there is no parallel counterpart of a Digester
+// created with options.
+//
+// Files of up to a megabyte are read ahead of their turn to be written to the
+// hash, and held in memory until then. No more than twice the specified number
+// of them are held at once, so the memory used for file contents is bounded by
+// about 2*workers MiB. Larger files are only read once it is their turn,
+// through a single copy buffer, so they do not add to the bound.
+func DigestFromDirectoryParallel(osDirname string, workers int) (VersionedDigest, error) {
+	if workers < 1 {
+		return VersionedDigest{}, errors.Errorf("cannot hash directory with %d workers", workers)
+	}
+
+	closure := newDirWalkClosure(sha256.New())
+	defer closure.release()
+
+	var entries []digestEntry
+	err := walkDigestEntries(osDirname, closure.walk, func(entry digestEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return VersionedDigest{}, err
+	}
+
+	// Closing done signals the producer to stop scheduling reads when this
+	// function returns early because of an error.
+	done := make(chan struct{})
+	defer close(done)
+
+	// Each regular file read ahead is read in its own goroutine, no more than
+	// workers of which run at a time. The channel on which each file's
+	// contents will be delivered is queued in walk order, so the results can
+	// be consumed in that order regardless of the order in which the reads
+	// complete.
+	pending := make(chan chan fileContents, workers)
+	opts := closure.digestOptions
+	go func() {
+		defer close(pending)
+		sem := make(chan struct{}, workers)
+		for _, entry := range entries {
+			if !entry.isRegular || !readAhead(entry.info) {
+				continue
+			}
+			result := make(chan fileContents, 1)
+			select {
+			case pending <- result:
+			case <-done:
+				return
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-done:
+				return
+			}
+			go func(osPathname string) {
+				result <- readFileContents(opts, osPathname)
+				<-sem
+			}(entry.osPathname)
+		}
+	}()
+
+	for _, entry := range entries {
+		closure.writeEntry(entry)
+		if !entry.isRegular {
+			continue
+		}
+		if !readAhead(entry.info) {
+			if err := closure.writeFile(entry.osPathname); err != nil {
+				return VersionedDigest{}, err
+			}
+			continue
+		}
+		contents := <-<-pending
+		_, _ = closure.someHash.Write(contents.data)
+		if contents.err != nil {
+			return VersionedDigest{}, contents.err
+		}
+	}
+
+	return VersionedDigest{
+		HashVersion: HashVersion,
+		Digest:      closure.someHash.Sum(nil),
+	}, nil
+}
+
+// CheckDepTreeParallel verifies a dependency tree exactly as CheckDepTree
+// does, returning an identical result, but computes the digests of up to the
+// specified number of dependencies concurrently, while the rest of the tree
+// continues to be walked.
+//
+// When the digests of several dependencies cannot be computed, the error
+// returned is the one CheckDepTree would return, for the first of them found.
+func CheckDepTreeParallel(osDirname string, wantDigests map[string]VersionedDigest, workers int) (map[string]VendorStatus, error) {
+	if workers < 1 {
+		return nil, errors.Errorf("cannot verify dependency tree with %d workers", workers)
+	}
+
+	checker := depTreeChecker{ctx: context.Background(), digester: NewDigester(), workers: workers}
+	return checker.check(osDirname, wantDigests)
+}
+
+// projectJob is the computation of the status of a single locked dependency
+// by a projectPool.
+type projectJob struct {
+	osPathname    string
+	slashPathname string
+	info          os.FileInfo // file info of the dependency's node, or nil
+	expectedSum   VersionedDigest
+
+	ls  VendorStatus // status computed by projectStatus
+	err error        // error returned by projectStatus
+}
+
+// projectPool computes the statuses of locked dependencies concurrently on
+// behalf of a depTreeChecker, which submits them as they are found while
+// walking the tree.
+type projectPool struct {
+	queue  chan *projectJob
+	jobs   []*projectJob // every submitted job, in the order submitted
+	wg     sync.WaitGroup
+	cancel context.CancelFunc
+	closed bool
+}
+
+// startProjectPool starts as many goroutines as the checker has workers, which
+// compute the statuses of the jobs submitted to the returned pool. Either the
+// wait or the stop method of the pool must be called once no more jobs are to
+// be submitted.
+//
+// Cancelling the pool cancels the checker's context, so the checker must not
+// be used once the pool has been stopped.
+func (checker *depTreeChecker) startProjectPool() *projectPool {
+	ctx, cancel := context.WithCancel(checker.ctx)
+	checker.ctx = ctx
+
+	pool := &projectPool{queue: make(chan *projectJob), cancel: cancel}
+	for i := 0; i < checker.workers; i++ {
+		pool.wg.Add(1)
+		go func() {
+			defer pool.wg.Done()
+			for job := range pool.queue {
+				job.ls, job.err = checker.projectStatus(job.osPathname, job.slashPathname, job.info, job.expectedSum)
+			}
+		}()
+	}
+	return pool
+}
+
+// submit queues the specified job, blocking until a worker is free to begin
+// it.
+func (pool *projectPool) submit(job *projectJob) {
+	pool.jobs = append(pool.jobs, job)
+	pool.queue <- job
+}
+
+// wait waits for every submitted job to complete.
+func (pool *projectPool) wait() {
+	if !pool.closed {
+		pool.closed = true
+		close(pool.queue)
+	}
+	pool.wg.Wait()
+}
+
+// stop abandons the jobs which have not yet completed, by cancelling them,
+// and waits for the workers to exit.
+func (pool *projectPool) stop() {
+	pool.cancel()
+	pool.wait()
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDigestFromDirectoryParallel(t *testing.T) {
+	synthetic := mkSyntheticTree(t, 500)
+	defer os.RemoveAll(synthetic)
+	// Files too large to be read ahead are read once it is their turn.
+	large := mkTestTree(t, map[string]string{
+		"a.go":       "package a\r\n",
+		"b/large.go": strings.Repeat("large\r\n", maxReadAheadSize/7+1),
+		"b/small.go": "package b\r\n",
+		"c.txt":      strings.Repeat("c", maxReadAheadSize),
+	})
+	defer os.RemoveAll(large)
+
+	for _, osDirname := range []string{getTestdataVerifyRoot(t), synthetic, large} {
+		want, err := DigestFromDirectory(osDirname)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, workers := range []int{1, 2, 8, 64} {
+			got, err := DigestFromDirectoryParallel(osDirname, workers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.HashVersion != want.HashVersion || !bytes.Equal(got.Digest, want.Digest) {
+				t.Errorf("%q with %d workers:\n(GOT):\n\t%v\n(WNT):\n\t%v", osDirname, workers, got, want)
+			}
+		}
+	}
+}
+
+func TestDigestFromDirectoryParallelBailsWithoutWorkers(t *testing.T) {
+	if _, err := DigestFromDirectoryParallel(getTestdataVerifyRoot(t), 0); err == nil {
+		t.Error("expected error with no workers")
+	}
+}
+
+func TestCheckDepTreeParallel(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go":      "package alice1\n",
+		"github.com/alice/alice2/a2.go":      "package alice2\n",
+		"github.com/alice/notInLock/n.go":    "package notInLock\n",
+		"github.com/bob/bob1/b1.go":          "package bob1\n",
+		"github.com/bob/bob1/sub/s.go":       "package sub\n",
+		"github.com/bob/emptyDigest/e.go":    "package emptyDigest\n",
+		"github.com/charlie/charlie1/c1.go":  "package charlie1\n",
+		"launchpad.net/nifty/n1.go":          "package nifty\n",
+		"gopkg.in/yaml.v2/yaml.go":           "package yaml\n",
+		"golang.org/x/crypto/blake2b/b2b.go": "package blake2b\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+
+	alice1, err := DigestFromDirectory(filepath.Join(vendorRoot, "github.com/alice/alice1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDigests := map[string]VersionedDigest{
+		"github.com/alice/alice1":      alice1,
+		"github.com/alice/alice2":      alice1,
+		"github.com/bob/bob1":          alice1,
+		"github.com/bob/emptyDigest":   {HashVersion: HashVersion},
+		"github.com/charlie/notInTree": alice1,
+		"launchpad.net/nifty":          {HashVersion: HashVersion + 1, Digest: alice1.Digest},
+		"gopkg.in/yaml.v2":             alice1,
+	}
+	// Many more projects than workers.
+	for i := 0; i < 50; i++ {
+		slashPathname := fmt.Sprintf("github.com/many/project%02d", i)
+		osPathname := filepath.Join(vendorRoot, filepath.FromSlash(slashPathname))
+		if err := os.MkdirAll(osPathname, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if i%3 != 0 {
+			wantDigests[slashPathname] = alice1
+		}
+	}
+
+	want, err := CheckDepTree(vendorRoot, wantDigests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{1, 2, 8, 64} {
+		got, err := CheckDepTreeParallel(vendorRoot, wantDigests, workers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d workers:\n(GOT): %v\n(WNT): %v", workers, got, want)
+		}
+	}
+
+	if _, err := CheckDepTreeParallel(vendorRoot, wantDigests, 0); err == nil {
+		t.Error("expected error with no workers")
+	}
+}
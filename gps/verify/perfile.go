@@ -0,0 +1,218 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PerFileDigests returns a digest of each file system node in the specified
+// directory, keyed by its slash-separated pathname relative to that directory,
+// so that callers are able to tell exactly which nodes changed between runs.
+// The directory itself is keyed by the empty string.
+//
+// Nodes are skipped exactly as they are by DigestFromDirectory. Each digest is
+// the SHA256 of the node's type, framed as DigestFromDirectory frames it, and
+// in the case of a regular file, of its normalized contents and size. Unlike
+// DigestFromDirectory, which ignores them, symbolic links are also included,
+// with a digest of their type and normalized referent, may it exist or not.
+// Because pathnames are only used as keys, the digest of a node does not
+// change when it is renamed.
+//
+// Referents are normalized so that a tree with relative symbolic links has the
+// same digests on every platform, even when its links were created on another
+// one: both solidus and reverse solidus are treated as separators, and
+// converted to solidus; a leading drive letter is upper-cased; and the
+// pathname is cleaned lexically, as by path.Clean. Absolute referents are
+// normalized the same way, but are only portable between systems with the
+// same directory layout.
+func PerFileDigests(osDirname string) (map[string][]byte, error) {
+	digests := make(map[string][]byte)
+	if err := addPerFileDigests(digests, osDirname, nil, ""); err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
+
+// addPerFileDigests adds the digest of each file system node in the specified
+// directory, as returned by PerFileDigests, to the specified map, keyed by its
+// slash-separated pathname relative to that directory, joined to the
+// specified prefix. The node at the specified pathname need not be a
+// directory, in which case only its digest is added, keyed by the prefix. Its
+// file info, as returned by os.Lstat, may be specified, or nil when it has not
+// already been obtained.
+func addPerFileDigests(digests map[string][]byte, osPathname string, info os.FileInfo, slashPrefix string) error {
+	closure := newDirWalkClosure(sha256.New())
+	defer closure.release()
+
+	opts := closure.walk
+	opts.includeSymlinks = true
+
+	return walkDigestEntriesFrom(osPathname, info, opts, func(entry digestEntry) error {
+		closure.someHash.Reset()
+		closure.writeModeType(entry.modeType)
+
+		switch {
+		case entry.isRegular:
+			if err := closure.writeFile(entry.osPathname); err != nil {
+				return err
+			}
+		case entry.modeType == os.ModeSymlink:
+			referent, err := opts.readlink(entry.osPathname)
+			if err != nil {
+				return newDigestError("Readlink", entry.osPathname, err)
+			}
+			writeBytesWithNull(closure.someHash, []byte(normalizeReferent(referent)))
+		}
+
+		digests[path.Join(slashPrefix, filepath.ToSlash(entry.osRelative))] = closure.someHash.Sum(nil)
+		return nil
+	})
+}
+
+// DigestFromPerFileDigests returns a digest of a directory tree derived from
+// the digests of its nodes, as returned by PerFileDigests for the directory.
+// It is the SHA256 of the pathname and digest of every node, in
+// lexicographical order of their pathnames, each NULL terminated, so that it
+// only depends on the nodes of the tree, and not on the order in which they
+// were found.
+//
+// This digest is not the one DigestFromDirectory returns for the directory,
+// which cannot be derived from the digests of its nodes, nor is it
+// interchangeable with it. Unlike that digest, it includes symbolic links, and
+// is able to be kept current by UpdateDigest without hashing the whole tree
+// again.
+func DigestFromPerFileDigests(fileDigests map[string][]byte) []byte {
+	slashPathnames := make([]string, 0, len(fileDigests))
+	for slashPathname := range fileDigests {
+		slashPathnames = append(slashPathnames, slashPathname)
+	}
+	sort.Strings(slashPathnames)
+
+	h := sha256.New()
+	for _, slashPathname := range slashPathnames {
+		writeBytesWithNull(h, []byte(slashPathname))
+		writeBytesWithNull(h, fileDigests[slashPathname])
+	}
+	return h.Sum(nil)
+}
+
+// UpdateDigest updates the specified digests of the nodes in the specified
+// directory, as previously returned by PerFileDigests for it, after the node
+// at the specified slash-separated pathname relative to the directory has
+// changed, been added, or been removed, and returns the digest of the updated
+// tree, as computed by DigestFromPerFileDigests. The map is modified in place.
+// When the node is a directory, the digests of every node beneath it are
+// recomputed; no other node is read.
+//
+// Because the digest of each node only depends on the node itself, and the
+// digest of the tree only depends on the digests of its nodes, replacing the
+// digests of the nodes which changed produces exactly the same map, and
+// therefore the same digest, as PerFileDigests would for the whole tree, as
+// long as no node other than the specified one, and those beneath it, has
+// changed since the map was computed. The directories enclosing an added node
+// are added to the map when they are not already in it. Nodes which are
+// skipped by PerFileDigests, such as those within VCS directories, leave the
+// map unchanged.
+func UpdateDigest(previous map[string][]byte, osDirname, slashRelative string) ([]byte, error) {
+	if slashRelative == "" || slashRelative == ".." || path.Clean(slashRelative) != slashRelative ||
+		strings.HasPrefix(slashRelative, "/") || strings.HasPrefix(slashRelative, "../") {
+		return nil, errors.Errorf("cannot update digest of %q: pathname is not clean and relative", slashRelative)
+	}
+	for _, element := range strings.Split(slashRelative, "/") {
+		if DefaultSkipDirs[element] {
+			return DigestFromPerFileDigests(previous), nil
+		}
+	}
+
+	delete(previous, slashRelative)
+	for slashPathname := range previous {
+		if strings.HasPrefix(slashPathname, slashRelative+"/") {
+			delete(previous, slashPathname)
+		}
+	}
+
+	osPathname := filepath.Join(osDirname, filepath.FromSlash(slashRelative))
+	opts := defaultDigestOptions().walk
+	info, err := opts.lstat(osPathname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return DigestFromPerFileDigests(previous), nil
+		}
+		return nil, newDigestError("Lstat", osPathname, err)
+	}
+	if err := addPerFileDigests(previous, osPathname, info, slashRelative); err != nil {
+		return nil, err
+	}
+
+	// The digest of a directory does not depend on its contents, so the
+	// directories enclosing the node need not be read to be added.
+	closure := newDirWalkClosure(sha256.New())
+	defer closure.release()
+	closure.writeModeType(os.ModeDir)
+	dirDigest := closure.someHash.Sum(nil)
+	for slashParent := path.Dir(slashRelative); ; slashParent = path.Dir(slashParent) {
+		if slashParent == "." {
+			slashParent = "" // the root
+		}
+		if _, ok := previous[slashParent]; !ok {
+			previous[slashParent] = dirDigest
+		}
+		if slashParent == "" {
+			break
+		}
+	}
+	return DigestFromPerFileDigests(previous), nil
+}
+
+// ExplainMismatch compares the per-node digests of the dependency at the
+// specified slash-separated pathname, relative to the specified vendor
+// directory, with the expected per-node digests, as previously returned by
+// PerFileDigests for the dependency's directory. It returns, in
+// lexicographical order, the slash-separated pathnames relative to the
+// dependency's directory of the nodes whose digests differ, including the
+// nodes which were added or removed, so that a DigestMismatchInLock status for
+// the dependency can be traced to the files which changed.
+func ExplainMismatch(osDirname, slashPathname string, wantFileDigests map[string][]byte) ([]string, error) {
+	gotFileDigests, err := PerFileDigests(filepath.Join(osDirname, filepath.FromSlash(slashPathname)))
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []string
+	for slashRelative, got := range gotFileDigests {
+		if want, ok := wantFileDigests[slashRelative]; !ok || !bytes.Equal(got, want) {
+			changed = append(changed, slashRelative)
+		}
+	}
+	for slashRelative := range wantFileDigests {
+		if _, ok := gotFileDigests[slashRelative]; !ok {
+			changed = append(changed, slashRelative)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// normalizeReferent returns the specified symbolic link referent normalized as
+// described by PerFileDigests. A reverse solidus is converted to a solidus on
+// every platform, rather than only where it is the separator, because on other
+// platforms it is far more likely to be the separator of a link created on
+// Windows than part of a name.
+func normalizeReferent(referent string) string {
+	slashReferent := strings.Replace(referent, `\`, "/", -1)
+	if len(slashReferent) >= 2 && slashReferent[1] == ':' && ('a' <= slashReferent[0] && slashReferent[0] <= 'z') {
+		slashReferent = strings.ToUpper(slashReferent[:1]) + slashReferent[1:]
+	}
+	return path.Clean(slashReferent)
+}
@@ -0,0 +1,245 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPerFileDigests(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		"a.go":                     "package a\r\n",
+		"sub/b.go":                 "package sub\n",
+		"sub/c.go":                 "package sub\n",
+		".git/HEAD":                "ref: refs/heads/master\n",
+		"vendor/github.com/x/x.go": "package x\n",
+	})
+	defer os.RemoveAll(dir)
+	if err := os.Symlink("sub/b.go", filepath.Join(dir, "link")); err != nil {
+		t.Skipf("cannot create symlink: %s", err)
+	}
+
+	digests, err := PerFileDigests(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for slashPathname := range digests {
+		got = append(got, slashPathname)
+	}
+	sort.Strings(got)
+	want := []string{"", "a.go", "link", "sub", "sub/b.go", "sub/c.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("(GOT): %v; (WNT): %v", got, want)
+	}
+
+	if !bytes.Equal(digests["sub/b.go"], digests["sub/c.go"]) {
+		t.Error("Expected files with identical contents to have identical digests")
+	}
+	if !bytes.Equal(digests[""], digests["sub"]) {
+		t.Error("Expected directories to have identical digests")
+	}
+	if bytes.Equal(digests["a.go"], digests["sub/b.go"]) {
+		t.Error("Expected files with different contents to have different digests")
+	}
+
+	// Changing a single file only changes its own digest.
+	if err := ioutil.WriteFile(filepath.Join(dir, "sub/c.go"), []byte("package sub // changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := PerFileDigests(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for slashPathname, digest := range digests {
+		if isEqual, wantEqual := bytes.Equal(digest, changed[slashPathname]), slashPathname != "sub/c.go"; isEqual != wantEqual {
+			t.Errorf("%s: (GOT): %v; (WNT): %v", slashPathname, isEqual, wantEqual)
+		}
+	}
+}
+
+func TestExplainMismatch(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a.go":     "package alice1\n",
+		"github.com/alice/alice1/b.go":     "package alice1\n",
+		"github.com/alice/alice1/sub/c.go": "package sub\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+	osProject := filepath.Join(vendorRoot, "github.com/alice/alice1")
+
+	wantFileDigests, err := PerFileDigests(osProject)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := ExplainMismatch(vendorRoot, "github.com/alice/alice1", wantFileDigests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 0 {
+		t.Errorf("(GOT): %v; (WNT): []", changed)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(osProject, "sub/c.go"), []byte("package sub // changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(osProject, "b.go")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(osProject, "d.go"), []byte("package alice1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err = ExplainMismatch(vendorRoot, "github.com/alice/alice1", wantFileDigests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b.go", "d.go", "sub/c.go"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("(GOT): %v; (WNT): %v", changed, want)
+	}
+}
+
+func TestNormalizeReferent(t *testing.T) {
+	testCases := []struct {
+		referent, want string
+	}{
+		{"b.go", "b.go"},
+		{"./b.go", "b.go"},
+		{"../lib/b.go", "../lib/b.go"},
+		{"sub//b.go", "sub/b.go"},
+		{"sub/", "sub"},
+		{"sub/../b.go", "b.go"},
+		{`..\lib\b.go`, "../lib/b.go"},
+		{`.\sub\b.go`, "sub/b.go"},
+		{`..\lib/b.go`, "../lib/b.go"},
+		{"/usr/lib/b.go", "/usr/lib/b.go"},
+		{`C:\Go\src`, "C:/Go/src"},
+		{`c:\Go\src`, "C:/Go/src"},
+		{"c:/Go/src/", "C:/Go/src"},
+	}
+	for _, testCase := range testCases {
+		if got := normalizeReferent(testCase.referent); got != testCase.want {
+			t.Errorf("%q: (GOT): %q; (WNT): %q", testCase.referent, got, testCase.want)
+		}
+	}
+}
+
+func TestPerFileDigestsPortableSymlinks(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		"sub/b.go": "package sub\n",
+	})
+	defer os.RemoveAll(dir)
+
+	// Relative links written differently, as they might be on different
+	// platforms, have the same digest.
+	for name, referent := range map[string]string{
+		"slash":     "sub/b.go",
+		"dotted":    "./sub/b.go",
+		"backslash": `sub\b.go`,
+	} {
+		if err := os.Symlink(referent, filepath.Join(dir, name)); err != nil {
+			t.Skipf("cannot create symlink: %s", err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "sub/b.go"), filepath.Join(dir, "absolute")); err != nil {
+		t.Fatal(err)
+	}
+
+	digests, err := PerFileDigests(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(digests["slash"], digests["dotted"]) || !bytes.Equal(digests["slash"], digests["backslash"]) {
+		t.Errorf("Expected equivalent relative links to have identical digests: %x", digests)
+	}
+	if bytes.Equal(digests["slash"], digests["absolute"]) {
+		t.Error("Expected absolute link to differ from relative link")
+	}
+}
+
+func TestUpdateDigest(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		"a.go":       "package a\n",
+		"sub/b.go":   "package sub\n",
+		"sub/c.go":   "package sub\n",
+		"other/d.go": "package other\n",
+		".git/HEAD":  "ref: refs/heads/master\n",
+	})
+	defer os.RemoveAll(dir)
+
+	digests, err := PerFileDigests(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial := DigestFromPerFileDigests(digests)
+
+	steps := []struct {
+		name          string
+		slashRelative string
+		change        func() error
+	}{
+		{"Edit", "sub/b.go", func() error {
+			return ioutil.WriteFile(filepath.Join(dir, "sub/b.go"), []byte("package sub // edited\n"), 0644)
+		}},
+		{"AddInNewDirectories", "new/deeper/e.go", func() error {
+			if err := os.MkdirAll(filepath.Join(dir, "new/deeper"), 0755); err != nil {
+				return err
+			}
+			return ioutil.WriteFile(filepath.Join(dir, "new/deeper/e.go"), []byte("package deeper\n"), 0644)
+		}},
+		{"Remove", "a.go", func() error {
+			return os.Remove(filepath.Join(dir, "a.go"))
+		}},
+		{"RemoveDirectory", "other", func() error {
+			return os.RemoveAll(filepath.Join(dir, "other"))
+		}},
+		{"ReplaceDirectory", "sub", func() error {
+			if err := os.RemoveAll(filepath.Join(dir, "sub")); err != nil {
+				return err
+			}
+			return ioutil.WriteFile(filepath.Join(dir, "sub"), []byte("now a file\n"), 0644)
+		}},
+		{"Skipped", ".git/HEAD", func() error {
+			return ioutil.WriteFile(filepath.Join(dir, ".git/HEAD"), []byte("ref: refs/heads/other\n"), 0644)
+		}},
+	}
+	previous := initial
+	for _, step := range steps {
+		if err := step.change(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := UpdateDigest(digests, dir, step.slashRelative)
+		if err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+
+		// The update is equivalent to computing the digests again.
+		wantDigests, err := PerFileDigests(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(digests, wantDigests) {
+			t.Errorf("%s: (GOT): %x; (WNT): %x", step.name, digests, wantDigests)
+		}
+		if want := DigestFromPerFileDigests(wantDigests); !bytes.Equal(got, want) {
+			t.Errorf("%s: (GOT): %x; (WNT): %x", step.name, got, want)
+		}
+		if changed := !bytes.Equal(got, previous); changed != (step.name != "Skipped") {
+			t.Errorf("%s: digest changed is %v", step.name, changed)
+		}
+		previous = got
+	}
+
+	if _, err := UpdateDigest(digests, dir, "../a.go"); err == nil {
+		t.Error("expected error for a pathname outside the directory")
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build go1.16
+
+package verify
+
+import (
+	"os"
+	"sort"
+)
+
+// sortedDirChildren returns the nodes of the specified directory sorted by
+// name. The type of each node is known without it being inspected again,
+// except on file systems which do not report node types while listing a
+// directory.
+func sortedDirChildren(osDirname string) ([]dirChild, error) {
+	fh, err := os.Open(osDirname)
+	if err != nil {
+		return nil, newDigestError("Open", osDirname, err)
+	}
+
+	entries, err := fh.ReadDir(-1) // -1: read all children
+	_ = fh.Close()
+	if err != nil {
+		return nil, newDigestError("ReadDir", osDirname, err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	children := make([]dirChild, len(entries))
+	for i, entry := range entries {
+		children[i] = entry
+	}
+	return children, nil
+}
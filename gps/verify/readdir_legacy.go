@@ -0,0 +1,35 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !go1.16
+
+package verify
+
+import (
+	"os"
+	"sort"
+)
+
+// sortedDirChildren returns the nodes of the specified directory sorted by
+// name, along with the file info of each, which is obtained while listing
+// the directory.
+func sortedDirChildren(osDirname string) ([]dirChild, error) {
+	fh, err := os.Open(osDirname)
+	if err != nil {
+		return nil, newDigestError("Open", osDirname, err)
+	}
+
+	infos, err := fh.Readdir(-1) // -1: read all children
+	_ = fh.Close()
+	if err != nil {
+		return nil, newDigestError("Readdir", osDirname, err)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	children := make([]dirChild, len(infos))
+	for i, info := range infos {
+		children[i] = fileInfoChild{info}
+	}
+	return children, nil
+}
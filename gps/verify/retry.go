@@ -0,0 +1,78 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// retryPolicy determines how many times, and how long after each failure, an
+// operation on a single file system node is attempted again when it fails
+// with a transient error.
+type retryPolicy struct {
+	retries int           // number of attempts after the first
+	backoff time.Duration // delay before the first retry, which doubles before each one after it
+}
+
+// again reports whether an operation which failed with the specified error,
+// after the specified number of retries, ought to be attempted again, in
+// which case it first waits for the backoff.
+func (policy *retryPolicy) again(retries int, err error) bool {
+	if err == nil || retries >= policy.retries || !isTransient(err) {
+		return false
+	}
+	time.Sleep(policy.backoff << uint(retries))
+	return true
+}
+
+// isTransient reports whether the specified error is one which an operation
+// may succeed after failing with, such as EAGAIN, EINTR, or a timeout, as
+// reported by the Temporary or Timeout method of the underlying error.
+func isTransient(err error) bool {
+	for {
+		switch e := err.(type) {
+		case *DigestError:
+			err = e.Err
+		case *os.PathError:
+			err = e.Err
+		case *os.LinkError:
+			err = e.Err
+		case *os.SyscallError:
+			err = e.Err
+		case interface{ Timeout() bool }:
+			if e.Timeout() {
+				return true
+			}
+			t, ok := err.(interface{ Temporary() bool })
+			return ok && t.Temporary()
+		case interface{ Temporary() bool }:
+			return e.Temporary()
+		default:
+			return false
+		}
+	}
+}
+
+// retryingReader reads from its source, retrying each read which fails with
+// a transient error according to its policy, so that a transient error while
+// reading a file does not cause the bytes already read to be read again.
+type retryingReader struct {
+	src    io.Reader
+	policy *retryPolicy
+}
+
+func (r *retryingReader) Read(buf []byte) (int, error) {
+	for retries := 0; ; retries++ {
+		n, err := r.src.Read(buf)
+		if n > 0 && err != nil && isTransient(err) {
+			return n, nil // the next read is retried instead, should it fail again
+		}
+		if n > 0 || !r.policy.again(retries, err) {
+			return n, err
+		}
+	}
+}
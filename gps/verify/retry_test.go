@@ -0,0 +1,173 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// flakyFileSystem wraps a file system so that the operations on certain nodes
+// fail with its error a number of times before they are passed to the wrapped
+// file system, and so that every other read of an open file fails with it.
+type flakyFileSystem struct {
+	FileSystem
+	err      error
+	failures map[string]int // number of failures left for the operations on each pathname
+}
+
+func (fsys *flakyFileSystem) fail(name string) error {
+	if fsys.failures[name] > 0 {
+		fsys.failures[name]--
+		return &os.PathError{Op: "flaky", Path: name, Err: fsys.err}
+	}
+	return nil
+}
+
+func (fsys *flakyFileSystem) Open(name string) (io.ReadCloser, error) {
+	if err := fsys.fail(name); err != nil {
+		return nil, err
+	}
+	rc, err := fsys.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &flakyReader{ReadCloser: rc, err: fsys.err}, nil
+}
+
+func (fsys *flakyFileSystem) Lstat(name string) (os.FileInfo, error) {
+	if err := fsys.fail(name); err != nil {
+		return nil, err
+	}
+	return fsys.FileSystem.Lstat(name)
+}
+
+func (fsys *flakyFileSystem) Readlink(name string) (string, error) {
+	if err := fsys.fail(name); err != nil {
+		return "", err
+	}
+	return fsys.FileSystem.Readlink(name)
+}
+
+func (fsys *flakyFileSystem) ReadDir(name string) ([]os.FileInfo, error) {
+	if err := fsys.fail(name); err != nil {
+		return nil, err
+	}
+	return fsys.FileSystem.ReadDir(name)
+}
+
+// flakyReader fails every other read with its error, and reads only a few
+// bytes at a time otherwise, so that reading a file fails many times.
+type flakyReader struct {
+	io.ReadCloser
+	err    error
+	failed bool
+}
+
+func (r *flakyReader) Read(buf []byte) (int, error) {
+	if r.failed = !r.failed; r.failed {
+		return 0, r.err
+	}
+	if len(buf) > 3 {
+		buf = buf[:3]
+	}
+	return r.ReadCloser.Read(buf)
+}
+
+func TestWithRetry(t *testing.T) {
+	mem := newMemFileSystem(map[string]string{
+		"a.go":     "package a\r\n",
+		"sub/b.go": "package sub\n",
+	}, map[string]string{
+		"link": "a.go",
+	})
+	want, err := NewDigester(WithFileSystem(mem), WithSymlinkTargets(true)).Digest(mem.osRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each operation on these nodes fails twice before it succeeds.
+	newFlaky := func(err error) *flakyFileSystem {
+		return &flakyFileSystem{FileSystem: mem, err: err, failures: map[string]int{
+			mem.osRoot:                               2,
+			filepath.Join(mem.osRoot, "a.go"):        2,
+			filepath.Join(mem.osRoot, "sub"):         2,
+			filepath.Join(mem.osRoot, "link"):        2,
+			filepath.Join(mem.osRoot, "sub", "b.go"): 2,
+		}}
+	}
+
+	t.Run("Transient", func(t *testing.T) {
+		for _, transient := range []error{syscall.EAGAIN, syscall.EINTR, syscall.ETIMEDOUT} {
+			d := NewDigester(WithFileSystem(newFlaky(transient)), WithSymlinkTargets(true), WithRetry(2, time.Microsecond))
+			got, err := d.Digest(mem.osRoot)
+			if err != nil {
+				t.Fatalf("%v: %v", transient, err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%v: \n(GOT):\n\t%x\n(WNT):\n\t%x", transient, got, want)
+			}
+		}
+	})
+
+	t.Run("TooFewRetries", func(t *testing.T) {
+		for _, retries := range []int{0, 1} {
+			d := NewDigester(WithFileSystem(newFlaky(syscall.EAGAIN)), WithRetry(retries, 0))
+			if got, err := d.Digest(mem.osRoot); err == nil {
+				t.Errorf("%d retries: (GOT): %x; (WNT): error", retries, got)
+			}
+		}
+	})
+
+	t.Run("NotTransient", func(t *testing.T) {
+		failure := errors.New("permanent failure")
+		flaky := newFlaky(failure)
+		_, err := NewDigester(WithFileSystem(flaky), WithRetry(5, 0)).Digest(mem.osRoot)
+		de, ok := err.(*DigestError)
+		if !ok || de.Op != "Lstat" {
+			t.Fatalf("(GOT): %v; (WNT): cannot Lstat", err)
+		}
+		if got, wnt := flaky.failures[mem.osRoot], 1; got != wnt {
+			t.Errorf("failures left: (GOT): %v; (WNT): %v", got, wnt)
+		}
+	})
+
+	t.Run("Negative", func(t *testing.T) {
+		if got, err := NewDigester(WithRetry(-1, 0)).Digest(mem.osRoot); err == nil {
+			t.Errorf("(GOT): %x; (WNT): error", got)
+		}
+	})
+}
+
+func TestIsTransient(t *testing.T) {
+	pathError := func(err error) error { return &os.PathError{Op: "open", Path: "a.go", Err: err} }
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{syscall.EAGAIN, true},
+		{syscall.EINTR, true},
+		{syscall.ETIMEDOUT, true},
+		{pathError(syscall.EAGAIN), true},
+		{newDigestError("Open", "a.go", pathError(syscall.EINTR)), true},
+		{&os.SyscallError{Syscall: "read", Err: syscall.ETIMEDOUT}, true},
+		{syscall.ENOENT, false},
+		{os.ErrNotExist, false},
+		{pathError(syscall.EACCES), false},
+		{errors.New("cannot read"), false},
+	}
+	for _, tc := range cases {
+		if got := isTransient(tc.err); got != tc.want {
+			t.Errorf("%v: (GOT): %v; (WNT): %v", tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// NodeKind identifies the type of a file system node in a DigestNode tree.
+type NodeKind uint8
+
+const (
+	// FileNode is a regular file.
+	FileNode NodeKind = iota
+
+	// DirNode is a directory.
+	DirNode
+
+	// SymlinkNode is a symbolic link.
+	SymlinkNode
+
+	// SpecialNode is a named pipe, socket, or device.
+	SpecialNode
+)
+
+func (k NodeKind) String() string {
+	switch k {
+	case FileNode:
+		return "file"
+	case DirNode:
+		return "dir"
+	case SymlinkNode:
+		return "symlink"
+	case SpecialNode:
+		return "special"
+	default:
+		return "unknown"
+	}
+}
+
+// DigestNode describes a file system node in a tree returned by DigestTree.
+type DigestNode struct {
+	// Path is the slash-separated pathname of the node, relative to the
+	// directory passed to DigestTree, which is itself the empty string.
+	Path string
+
+	// Kind is the type of the node.
+	Kind NodeKind
+
+	// Digest is the digest of the node. The digest of a directory is the
+	// digest DigestFromDirectory returns for it, while the digest of any
+	// other node is the one PerFileDigests returns for it.
+	Digest []byte
+
+	// Referent is the normalized referent of a symbolic link, as described
+	// by PerFileDigests, and is empty for any other node.
+	Referent string
+
+	// Children holds the nodes within a directory, in lexicographical order.
+	Children []*DigestNode
+}
+
+// DigestTree returns the tree of file system nodes in the specified directory,
+// along with the digest of each of them, so that tooling may render the tree
+// or compare it against another. The digest of the returned root node is
+// identical to the one DigestFromDirectory returns for the directory.
+//
+// Nodes are skipped exactly as they are by DigestFromDirectory, except for
+// symbolic links, which are included, but as they are ignored by
+// DigestFromDirectory, do not contribute to the digests of the directories
+// which contain them.
+//
+// The tree is computed in a single traversal: the contents of each file are
+// read once, and written to the hash of every directory enclosing it.
+func DigestTree(osDirname string) (*DigestNode, error) {
+	fileHash := &treeHash{Hash: sha256.New()}
+	fileClosure := newDirWalkClosure(fileHash)
+	defer fileClosure.release()
+
+	opts := fileClosure.walk
+	opts.includeSymlinks = true
+
+	// The root is not followed when it is a symbolic link, as it would not be
+	// by the walk, which would otherwise return it as the only node.
+	osDirname = filepath.Clean(osDirname)
+	fi, err := opts.lstat(osDirname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, newDigestError(opFindRoot, osDirname, err)
+		}
+		return nil, newDigestError("Lstat", osDirname, err)
+	}
+	if !fi.IsDir() {
+		return nil, errors.Errorf("cannot hash tree of %q: not a directory", osDirname)
+	}
+
+	var root *DigestNode
+	var open []*treeDir // directories enclosing the current node, outermost first
+	defer func() {
+		for _, dir := range open {
+			dir.closure.release()
+		}
+	}()
+
+	err = walkDigestEntriesFrom(osDirname, fi, opts, func(entry digestEntry) error {
+		// Every directory the walk has left is complete.
+		for len(open) > 0 && !open[len(open)-1].encloses(entry.osRelative) {
+			open[len(open)-1].close()
+			open = open[:len(open)-1]
+		}
+
+		node := &DigestNode{Path: filepath.ToSlash(entry.osRelative)}
+		if len(open) > 0 {
+			parent := open[len(open)-1].node
+			parent.Children = append(parent.Children, node)
+		} else {
+			root = node
+		}
+
+		if entry.modeType == os.ModeSymlink {
+			node.Kind = SymlinkNode
+			referent, err := opts.readlink(entry.osPathname)
+			if err != nil {
+				return newDigestError("Readlink", entry.osPathname, err)
+			}
+			node.Referent = normalizeReferent(referent)
+			fileHash.Reset()
+			fileClosure.writeModeType(entry.modeType)
+			writeBytesWithNull(fileHash, []byte(node.Referent))
+			node.Digest = fileHash.Sum(nil)
+			return nil
+		}
+
+		if entry.modeType == os.ModeDir {
+			node.Kind = DirNode
+			open = append(open, &treeDir{
+				node:       node,
+				osRelative: entry.osRelative,
+				closure:    newDirWalkClosure(sha256.New()),
+			})
+		}
+
+		// Write the node to the hash of every directory enclosing it, and of
+		// the node itself when it is a directory, exactly as
+		// DigestFromDirectory would write it to the hash of each of them.
+		for _, dir := range open {
+			dirEntry := entry
+			dirEntry.osRelative = dir.relative(entry.osRelative)
+			dir.closure.writeEntry(dirEntry)
+		}
+
+		switch {
+		case entry.isRegular:
+			node.Kind = FileNode
+			fileHash.Reset()
+			fileClosure.writeModeType(entry.modeType)
+			fileHash.dirs = fileHash.dirs[:0]
+			for _, dir := range open {
+				fileHash.dirs = append(fileHash.dirs, dir.closure.someHash)
+			}
+			err := fileClosure.writeFile(entry.osPathname)
+			fileHash.dirs = fileHash.dirs[:0]
+			if err != nil {
+				return err
+			}
+			node.Digest = fileHash.Sum(nil)
+		case entry.modeType != os.ModeDir:
+			node.Kind = SpecialNode
+			fileHash.Reset()
+			fileClosure.writeModeType(entry.modeType)
+			node.Digest = fileHash.Sum(nil)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for len(open) > 0 {
+		open[len(open)-1].close()
+		open = open[:len(open)-1]
+	}
+	return root, nil
+}
+
+// treeDir is a directory whose contents DigestTree is still walking.
+type treeDir struct {
+	node       *DigestNode
+	osRelative string          // pathname relative to the root of the tree
+	closure    *dirWalkClosure // writes the directory's contents to its hash
+}
+
+// encloses reports whether the node at the specified pathname, relative to
+// the root of the tree, is beneath the directory.
+func (dir *treeDir) encloses(osRelative string) bool {
+	return dir.osRelative == "" || strings.HasPrefix(osRelative, dir.osRelative+osPathSeparator)
+}
+
+// relative returns the specified pathname, which is relative to the root of
+// the tree, relative to the directory instead.
+func (dir *treeDir) relative(osRelative string) string {
+	if osRelative == dir.osRelative {
+		return ""
+	}
+	if dir.osRelative == "" {
+		return osRelative
+	}
+	return osRelative[len(dir.osRelative)+len(osPathSeparator):]
+}
+
+// close records the digest of the directory, and releases its closure.
+func (dir *treeDir) close() {
+	dir.node.Digest = dir.closure.someHash.Sum(nil)
+	dir.closure.release()
+}
+
+// treeHash is the hash DigestTree computes the digests of individual nodes
+// with. Anything written to it is also written to the hashes of its
+// directories, which is how the contents of a file are written to the hash of
+// every directory enclosing it while only being read once.
+type treeHash struct {
+	hash.Hash
+	dirs []hash.Hash
+}
+
+// Write writes the specified data to the hash, and to the hashes of its
+// directories.
+func (h *treeHash) Write(data []byte) (int, error) {
+	for _, dir := range h.dirs {
+		_, _ = dir.Write(data)
+	}
+	return h.Hash.Write(data)
+}
+
+// WriteManifest writes a manifest of the specified directory to the specified
+// writer, which describes every node DigestTree returns for the directory, so
+// that it can be stored and compared against later. Each node other than the
+// directory itself is described by a line of the form
+// "path\tkind\tdigest", in the order DigestFromDirectory visits them, where
+// the digest is hexadecimal, and is the normalized referent in the case of a
+// symbolic link. The final line, of the form "tree\tdigest", holds the digest
+// of the directory, as DigestFromDirectory returns it and as it would be
+// recorded in a lock file.
+//
+// Pathnames and referents which contain tab, newline, or quotation mark
+// characters are quoted, as by strconv.Quote, so that every line is able to
+// be split on tab characters.
+func WriteManifest(w io.Writer, osDirname string) error {
+	root, err := DigestTree(osDirname)
+	if err != nil {
+		return err
+	}
+
+	var write func(*DigestNode) error
+	write = func(node *DigestNode) error {
+		value := hex.EncodeToString(node.Digest)
+		if node.Kind == SymlinkNode {
+			value = quoteManifestField(node.Referent)
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\n", quoteManifestField(node.Path), node.Kind, value); err != nil {
+			return err
+		}
+		for _, child := range node.Children {
+			if err := write(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, child := range root.Children {
+		if err := write(child); err != nil {
+			return err
+		}
+	}
+
+	vd := VersionedDigest{HashVersion: HashVersion, Digest: root.Digest}
+	_, err = fmt.Fprintf(w, "tree\t%s\n", vd)
+	return err
+}
+
+// quoteManifestField returns the specified pathname or referent, quoted when
+// it contains characters which would make a manifest line ambiguous.
+func quoteManifestField(field string) string {
+	if strings.ContainsAny(field, "\t\n\"") {
+		return strconv.Quote(field)
+	}
+	return field
+}
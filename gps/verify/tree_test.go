@@ -0,0 +1,149 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDigestTree(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		".git/HEAD":                "ref: refs/heads/master\n",
+		"a.go":                     "package a\r\n",
+		"sub/b.go":                 "package sub\n",
+		"sub/deeper/c.go":          "package deeper\n",
+		"vendor/github.com/x/x.go": "package x\n",
+		"z.go":                     "package z\n",
+	})
+	defer os.RemoveAll(dir)
+	if err := os.Symlink("a.go", filepath.Join(dir, "sub/link")); err != nil {
+		t.Skipf("cannot create symlink: %s", err)
+	}
+
+	root, err := DigestTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type flatNode struct {
+		path     string
+		kind     NodeKind
+		children int
+	}
+	var got []flatNode
+	nodes := make(map[string]*DigestNode)
+	var flatten func(*DigestNode)
+	flatten = func(node *DigestNode) {
+		got = append(got, flatNode{node.Path, node.Kind, len(node.Children)})
+		nodes[node.Path] = node
+		for _, child := range node.Children {
+			flatten(child)
+		}
+	}
+	flatten(root)
+	want := []flatNode{
+		{"", DirNode, 3},
+		{"a.go", FileNode, 0},
+		{"sub", DirNode, 3},
+		{"sub/b.go", FileNode, 0},
+		{"sub/deeper", DirNode, 1},
+		{"sub/deeper/c.go", FileNode, 0},
+		{"sub/link", SymlinkNode, 0},
+		{"z.go", FileNode, 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("(GOT): %v; (WNT): %v", got, want)
+	}
+
+	// The digest of every directory is the one DigestFromDirectory computes.
+	for _, slashPathname := range []string{"", "sub", "sub/deeper"} {
+		want, err := DigestFromDirectory(filepath.Join(dir, filepath.FromSlash(slashPathname)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := nodes[slashPathname].Digest; !bytes.Equal(got, want.Digest) {
+			t.Errorf("%q:\n(GOT):\n\t%#v\n(WNT):\n\t%#v", slashPathname, got, want.Digest)
+		}
+	}
+
+	// The digest of every other node is the one PerFileDigests computes.
+	perFile, err := PerFileDigests(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, slashPathname := range []string{"a.go", "sub/b.go", "sub/deeper/c.go", "sub/link", "z.go"} {
+		if got, want := nodes[slashPathname].Digest, perFile[slashPathname]; !bytes.Equal(got, want) {
+			t.Errorf("%q:\n(GOT):\n\t%#v\n(WNT):\n\t%#v", slashPathname, got, want)
+		}
+	}
+
+	if _, err := DigestTree(filepath.Join(dir, "a.go")); err == nil {
+		t.Error("expected error for regular file")
+	}
+
+	// A root which is a symbolic link to a directory is not followed, just
+	// as the links within the tree are not.
+	link := filepath.Join(dir, "sub.link")
+	if err := os.Symlink("sub", link); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DigestTree(link); err == nil {
+		t.Error("expected error for symlinked root")
+	}
+}
+
+func TestWriteManifest(t *testing.T) {
+	dir := mkTestTree(t, map[string]string{
+		"a.go":       "package a\n",
+		"sub/b.go":   "package sub\n",
+		"tab\tname":  "",
+		".git/HEAD":  "ref: refs/heads/master\n",
+		"sub/c/d.go": "package c\n",
+	})
+	defer os.RemoveAll(dir)
+	if err := os.Symlink("../a.go", filepath.Join(dir, "sub/link")); err != nil {
+		t.Skipf("cannot create symlink: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteManifest(&buf, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	perFile, err := PerFileDigests(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := DigestFromDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := func(slashPathname, kind string) string {
+		return fmt.Sprintf("%s\t%s\t%x\n", slashPathname, kind, perFile[slashPathname])
+	}
+	dirLine := func(slashPathname string) string {
+		digest, err := DigestFromDirectory(filepath.Join(dir, filepath.FromSlash(slashPathname)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprintf("%s\tdir\t%x\n", slashPathname, digest.Digest)
+	}
+	want := line("a.go", "file") +
+		dirLine("sub") +
+		line("sub/b.go", "file") +
+		dirLine("sub/c") +
+		line("sub/c/d.go", "file") +
+		"sub/link\tsymlink\t../a.go\n" +
+		fmt.Sprintf("%q\tfile\t%x\n", "tab\tname", perFile["tab\tname"]) +
+		"tree\t" + tree.String() + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("\n(GOT):\n%s\n(WNT):\n%s", got, want)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"context"
+	"hash"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// TreeCache memoizes the digests of the dependencies in a vendor directory
+// across calls to its CheckDepTree method, so that a long-running process
+// which repeatedly verifies the same tree only computes the digests of the
+// dependencies which changed since the previous call.
+//
+// A dependency is considered unchanged while the most recent modification
+// time of all the file system nodes within its directory, including the
+// directories themselves, is unchanged. Creating, removing or renaming a node
+// updates the modification time of its parent directory, so such changes are
+// always noticed; however, a file whose contents are modified without
+// updating its modification time, or whose modification time is set back, is
+// not.
+//
+// A TreeCache is safe for concurrent use by multiple goroutines.
+type TreeCache struct {
+	digester *Digester
+
+	mu       sync.Mutex
+	projects map[treeCacheKey]treeCacheEntry
+}
+
+// treeCacheKey identifies a digest in a TreeCache. Digests computed by
+// different hash algorithms, as requested by tagged expected digests, are
+// cached separately.
+type treeCacheKey struct {
+	osPathname string // absolute pathname of the dependency
+	tag        string // algorithm tag of the digest
+}
+
+// treeCacheEntry records the digest of a dependency, and the most recent
+// modification time within the dependency's directory when it was computed.
+type treeCacheEntry struct {
+	modTime time.Time
+	digest  []byte
+}
+
+// NewTreeCache returns an empty TreeCache whose digests are computed according
+// to the specified options, which are interpreted as they are by NewDigester.
+func NewTreeCache(options ...DigestOption) *TreeCache {
+	return &TreeCache{
+		digester: NewDigester(options...),
+		projects: make(map[treeCacheKey]treeCacheEntry),
+	}
+}
+
+// CheckDepTree verifies a dependency tree exactly as the CheckDepTree function
+// does, but only computes the digests of the dependencies whose directories
+// changed since their digests were last computed by this cache.
+func (cache *TreeCache) CheckDepTree(osDirname string, wantDigests map[string]VersionedDigest) (map[string]VendorStatus, error) {
+	if cache.digester.err != nil {
+		return nil, cache.digester.err
+	}
+
+	checker := depTreeChecker{ctx: context.Background(), digester: cache.digester, cache: cache}
+	return checker.check(osDirname, wantDigests)
+}
+
+// projectDigest returns the digest of the dependency at the specified
+// pathname, either from the cache, or computed by the checker with the
+// specified hash function when the dependency changed or is not in the cache.
+func (cache *TreeCache) projectDigest(checker *depTreeChecker, osPathname string, info os.FileInfo, tag []byte, newHash func() hash.Hash) ([]byte, error) {
+	osPathname, err := filepath.Abs(osPathname)
+	if err != nil {
+		return nil, newDigestError("Abs", osPathname, err)
+	}
+	key := treeCacheKey{osPathname: osPathname, tag: string(tag)}
+
+	modTime, err := latestModTime(osPathname, info, cache.digester.walk)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.mu.Lock()
+	entry, ok := cache.projects[key]
+	cache.mu.Unlock()
+	if ok && entry.modTime.Equal(modTime) {
+		return entry.digest, nil
+	}
+
+	digest, err := checker.computeDigest(osPathname, info, newHash)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.mu.Lock()
+	cache.projects[key] = treeCacheEntry{modTime: modTime, digest: digest}
+	cache.mu.Unlock()
+	return digest, nil
+}
+
+// latestModTime returns the most recent modification time of the file system
+// nodes within the specified directory, including the directory itself, which
+// are visited by the specified walk options. The file info of the directory
+// as returned by os.Lstat is nil when it has not already been obtained.
+func latestModTime(osDirname string, info os.FileInfo, opts walkOptions) (time.Time, error) {
+	var latest time.Time
+	err := walkDigestEntriesFrom(osDirname, info, opts, func(entry digestEntry) error {
+		if modTime := entry.info.ModTime(); modTime.After(latest) {
+			latest = modTime
+		}
+		return nil
+	})
+	return latest, err
+}
@@ -0,0 +1,105 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTreeCache(t *testing.T) {
+	vendorRoot := mkTestTree(t, map[string]string{
+		"github.com/alice/alice1/a1.go": "package alice1\n",
+		"github.com/bob/bob1/b1.go":     "package bob1\n",
+	})
+	defer os.RemoveAll(vendorRoot)
+
+	wantDigests := make(map[string]VersionedDigest)
+	for _, slashPathname := range []string{"github.com/alice/alice1", "github.com/bob/bob1"} {
+		digest, err := DigestFromDirectory(filepath.Join(vendorRoot, filepath.FromSlash(slashPathname)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantDigests[slashPathname] = digest
+	}
+
+	// Set every modification time in the past, so that later changes are
+	// noticed even by file systems whose timestamps are coarse.
+	past := time.Now().Add(-time.Hour)
+	err := filepath.Walk(vendorRoot, func(osPathname string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(osPathname, past, past)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewTreeCache()
+	check := func(want map[string]VendorStatus) {
+		t.Helper()
+		got, err := cache.CheckDepTree(vendorRoot, wantDigests)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("(GOT): %v; (WNT): %v", got, want)
+		}
+	}
+
+	// Concurrent calls share the cache.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			check(map[string]VendorStatus{
+				"github.com/alice/alice1": NoMismatch,
+				"github.com/bob/bob1":     NoMismatch,
+			})
+		}()
+	}
+	wg.Wait()
+
+	// Modify a file without changing its modification time, which the cache
+	// cannot notice.
+	osPathname := filepath.Join(vendorRoot, "github.com/alice/alice1/a1.go")
+	if err := ioutil.WriteFile(osPathname, []byte("package alice2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(osPathname, past, past); err != nil {
+		t.Fatal(err)
+	}
+	check(map[string]VendorStatus{
+		"github.com/alice/alice1": NoMismatch,
+		"github.com/bob/bob1":     NoMismatch,
+	})
+
+	// Once the modification time changes, only that dependency is recomputed.
+	later := past.Add(time.Second)
+	if err := os.Chtimes(osPathname, later, later); err != nil {
+		t.Fatal(err)
+	}
+	check(map[string]VendorStatus{
+		"github.com/alice/alice1": DigestMismatchInLock,
+		"github.com/bob/bob1":     NoMismatch,
+	})
+
+	// Removing a file updates the modification time of its directory, which
+	// is then empty.
+	if err := os.Remove(filepath.Join(vendorRoot, "github.com/bob/bob1/b1.go")); err != nil {
+		t.Fatal(err)
+	}
+	check(map[string]VendorStatus{
+		"github.com/alice/alice1": DigestMismatchInLock,
+		"github.com/bob/bob1":     EmptyTreeInLock,
+	})
+}
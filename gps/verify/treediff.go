@@ -0,0 +1,95 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// DiffTrees compares two vendor trees project by project, returning the
+// vendor status condition of each project as CheckDepTree would if the digests
+// of the projects in the old tree were the expected digests in a lock file:
+//
+//   - NotInTree: the project is in the old tree, but was removed from the new
+//     tree.
+//   - NotInLock: the file system node is in the new tree, but was not in the
+//     old tree.
+//   - DigestMismatchInLock: the project is in both trees, but changed.
+//   - NoMismatch: the project is in both trees, and did not change.
+//
+// A project is identified as the shallowest directory of the old tree which
+// contains anything other than directories. When a project is nested at a
+// different depth in the new tree, the change is reported against the project
+// directories of the old tree: for instance, when files are moved from
+// `github.com/alice/alice1` into `github.com/alice/alice1/v2`, the old project
+// is reported as DigestMismatchInLock, while when files are added to
+// `github.com/alice`, the parent of an old project, they are reported
+// individually as NotInLock.
+//
+// A missing old tree is treated as an empty tree, as is a missing new tree.
+func DiffTrees(oldVendor, newVendor string) (map[string]VendorStatus, error) {
+	oldDigests, err := projectDigests(oldVendor)
+	if err != nil {
+		return nil, err
+	}
+	return CheckDepTree(newVendor, oldDigests)
+}
+
+// projectDigests returns the digests of all projects in the specified vendor
+// tree, keyed by slash-separated pathname relative to the tree. A project is
+// the shallowest directory which contains anything other than directories.
+func projectDigests(osDirname string) (map[string]VersionedDigest, error) {
+	osDirname = filepath.Clean(osDirname)
+	digests := make(map[string]VersionedDigest)
+
+	if _, err := os.Stat(osDirname); err != nil {
+		if os.IsNotExist(err) {
+			return digests, nil
+		}
+		return nil, newDigestError("Stat", osDirname, err)
+	}
+
+	queue := []string{""} // relative pathnames of directories that must be inspected
+	for len(queue) > 0 {
+		lq1 := len(queue) - 1
+		osRelative := queue[lq1]
+		queue = queue[:lq1]
+		osPathname := filepath.Join(osDirname, osRelative)
+
+		children, err := sortedDirChildren(osPathname)
+		if err != nil {
+			return nil, err
+		}
+
+		var isProject bool
+		var osSubdirs []string
+		for _, child := range children {
+			osChildName := child.Name()
+			if DefaultSkipDirs[osChildName] {
+				continue
+			}
+			if !child.Type().IsDir() {
+				isProject = true
+				break
+			}
+			osSubdirs = append(osSubdirs, filepath.Join(osRelative, osChildName))
+		}
+
+		// Nodes directly beneath the root of the tree, such as a manifest of
+		// vendored modules, do not belong to any project.
+		if isProject && osRelative != "" {
+			digest, err := DigestFromDirectory(osPathname)
+			if err != nil {
+				return nil, newDigestError("compute dependency hash", osPathname, err)
+			}
+			digests[filepath.ToSlash(osRelative)] = digest
+			continue
+		}
+		queue = append(queue, osSubdirs...)
+	}
+
+	return digests, nil
+}
@@ -0,0 +1,203 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"crypto/sha256"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// TreeHasher computes the digest of a directory tree from nodes added to it by
+// the caller, rather than read from the file system, so that a tree assembled
+// from other sources, such as an archive, is able to be hashed. The digest of
+// a TreeHasher to which the nodes of a directory have been added is identical
+// to the one DigestFromDirectory returns for the directory.
+//
+// Nodes are identified by their slash-separated pathnames relative to the root
+// of the tree, which is itself implicitly added by NewTreeHasher. They must be
+// added in the order DigestFromDirectory visits them, which is lexical order
+// of their pathname elements, so that every directory is added before the
+// nodes within it, which immediately follow it. For example, `a`, `a/b.go`,
+// and then `a.go`. Nodes beneath a name in DefaultSkipDirs are ignored, as
+// they are by DigestFromDirectory, which also ignores the nodes following a
+// regular file with such a name in its directory, such as the `.git` file of
+// a git worktree.
+//
+// A TreeHasher is not safe for concurrent use. Once any method returns an
+// error, every later call returns the same error, and the digest returned by
+// Sum is meaningless. A TreeHasher holds a copy buffer borrowed from a pool,
+// which Close returns.
+type TreeHasher struct {
+	closure *dirWalkClosure
+	dirs    []string // directories enclosing the most recently added node, outermost first
+	last    string   // pathname of the most recently added node
+	skipped string   // directory whose remaining nodes are ignored, when skip is set
+	skip    bool     // whether a regular file named in DefaultSkipDirs was added to skipped
+	err     error
+}
+
+// NewTreeHasher returns a TreeHasher for an empty directory tree.
+func NewTreeHasher() *TreeHasher {
+	th := &TreeHasher{
+		closure: newDirWalkClosure(sha256.New()),
+		dirs:    []string{""},
+	}
+	th.closure.writeEntry(digestEntry{modeType: os.ModeDir})
+	return th
+}
+
+// AddFile adds a regular file at the specified pathname to the tree, whose
+// contents are read from the specified reader, and have their line endings
+// normalized, exactly as DigestFromDirectory normalizes the contents of
+// files.
+func (th *TreeHasher) AddFile(slashPathname string, r io.Reader) error {
+	if ok, err := th.add(slashPathname, 0); !ok {
+		return err
+	}
+	th.closure.writeEntry(digestEntry{osRelative: slashPathname})
+	if written, err := th.closure.writeContents(r); err != nil {
+		th.err = newCopyError(slashPathname, written, err)
+		return th.err
+	}
+	return nil
+}
+
+// AddDir adds a directory at the specified pathname to the tree.
+func (th *TreeHasher) AddDir(slashPathname string) error {
+	if ok, err := th.add(slashPathname, os.ModeDir); !ok {
+		return err
+	}
+	th.closure.writeEntry(digestEntry{osRelative: slashPathname, modeType: os.ModeDir})
+	th.dirs = append(th.dirs, slashPathname)
+	return nil
+}
+
+// AddSymlink adds a symbolic link at the specified pathname, referring to the
+// specified target, to the tree. Because DigestFromDirectory ignores symbolic
+// links, neither the link nor its target change the digest; the link is only
+// checked to have been added in order.
+func (th *TreeHasher) AddSymlink(slashPathname, target string) error {
+	_, err := th.add(slashPathname, os.ModeSymlink)
+	return err
+}
+
+// Sum returns the digest of the nodes added to the tree so far. It does not
+// change the state of the TreeHasher, so more nodes may be added afterwards.
+// Sum returns nil once the TreeHasher is closed.
+func (th *TreeHasher) Sum() []byte {
+	if th.closure == nil {
+		return nil
+	}
+	return th.closure.someHash.Sum(nil)
+}
+
+// errTreeHasherClosed is the error returned by the methods of a closed
+// TreeHasher that add nodes.
+var errTreeHasherClosed = errors.New("cannot add to tree: TreeHasher is closed")
+
+// Close releases the copy buffer of the TreeHasher, after which adding a node
+// returns an error. Closing a TreeHasher more than once has no effect. The
+// returned error is always nil; Close returns one so that a TreeHasher is an
+// io.Closer.
+func (th *TreeHasher) Close() error {
+	if th.closure != nil {
+		th.closure.release()
+		th.closure = nil
+	}
+	if th.err == nil {
+		th.err = errTreeHasherClosed
+	}
+	return nil
+}
+
+// add checks that the node at the specified pathname is able to be added to
+// the tree next, given the type of the node, and records it as the most
+// recently added node. It returns true when the node ought to be written to
+// the hash, and false when it cannot be added, as indicated by a non-nil
+// error, or is skipped.
+func (th *TreeHasher) add(slashPathname string, modeType os.FileMode) (bool, error) {
+	if th.err != nil {
+		return false, th.err
+	}
+
+	if slashPathname == "" || slashPathname == ".." || path.Clean(slashPathname) != slashPathname ||
+		strings.HasPrefix(slashPathname, "/") || strings.HasPrefix(slashPathname, "../") {
+		th.err = errors.Errorf("cannot add %q to tree: pathname is not clean and relative", slashPathname)
+		return false, th.err
+	}
+
+	// As DigestFromDirectory does, the nodes following a regular file named
+	// in DefaultSkipDirs in its directory, and those within them, are ignored.
+	if th.skip {
+		if isAncestorOrSelf(th.skipped, slashPathname) {
+			return false, nil
+		}
+		th.skip = false
+	}
+
+	elements := strings.Split(slashPathname, "/")
+	for i, element := range elements {
+		if DefaultSkipDirs[element] {
+			// Symbolic links are ignored before their names are compared.
+			if i == len(elements)-1 && modeType == 0 {
+				th.skipped, th.skip = path.Dir(slashPathname), true
+				if th.skipped == "." {
+					th.skipped = ""
+				}
+			}
+			return false, nil
+		}
+	}
+
+	if !walkOrderLess(th.last, slashPathname) {
+		th.err = errors.Errorf("cannot add %q to tree after %q: nodes must be added in lexical order", slashPathname, th.last)
+		return false, th.err
+	}
+
+	// The parent of the node must be the most recently added directory which
+	// encloses the node, which also means it must have been added.
+	parent := path.Dir(slashPathname)
+	if parent == "." {
+		parent = ""
+	}
+	for len(th.dirs) > 1 && !isAncestorOrSelf(th.dirs[len(th.dirs)-1], parent) {
+		th.dirs = th.dirs[:len(th.dirs)-1]
+	}
+	if th.dirs[len(th.dirs)-1] != parent {
+		th.err = errors.Errorf("cannot add %q to tree: parent directory %q was not added", slashPathname, parent)
+		return false, th.err
+	}
+
+	th.last = slashPathname
+	return true, nil
+}
+
+// isAncestorOrSelf reports whether the directory at the first slash-separated
+// pathname is, or encloses, the node at the second.
+func isAncestorOrSelf(slashDirname, slashPathname string) bool {
+	return slashDirname == "" || slashDirname == slashPathname || strings.HasPrefix(slashPathname, slashDirname+"/")
+}
+
+// walkOrderLess reports whether the node at the first slash-separated pathname
+// is visited before the node at the second by DigestFromDirectory, which
+// compares their pathname elements in turn. The empty pathname, which is the
+// root, is visited first.
+func walkOrderLess(a, b string) bool {
+	if a == "" || b == "" {
+		return a == "" && b != ""
+	}
+	aElements, bElements := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(aElements) && i < len(bElements); i++ {
+		if aElements[i] != bElements[i] {
+			return aElements[i] < bElements[i]
+		}
+	}
+	return len(aElements) < len(bElements)
+}
@@ -0,0 +1,215 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package verify
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTreeHasher(t *testing.T) {
+	files := map[string]string{
+		".git/HEAD":         "ref: refs/heads/master\n",
+		"a/b.go":            "package a\r\n",
+		"a.go":              "package main\r\n\r\n",
+		"a/c/d.go":          "package c\n",
+		"vendor/x/x.go":     "package x\n",
+		"z.go":              "package z\n",
+		"a/vendor/y/y.go":   "package y\n",
+		"a/c/testdata/e.in": "now is the time\r",
+	}
+	dir := mkTestTree(t, files)
+	defer os.RemoveAll(dir)
+	want, err := DigestFromDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	th := NewTreeHasher()
+	steps := []struct {
+		slashPathname string
+		isDir         bool
+	}{
+		{".git", true},
+		{".git/HEAD", false},
+		{"a", true},
+		{"a/b.go", false},
+		{"a/c", true},
+		{"a/c/d.go", false},
+		{"a/c/testdata", true},
+		{"a/c/testdata/e.in", false},
+		{"a/vendor", true},
+		{"a/vendor/y", true},
+		{"a/vendor/y/y.go", false},
+		{"a.go", false},
+		{"link", false},
+		{"vendor", true},
+		{"vendor/x", true},
+		{"vendor/x/x.go", false},
+		{"z.go", false},
+	}
+	for _, step := range steps {
+		var err error
+		switch {
+		case step.isDir:
+			err = th.AddDir(step.slashPathname)
+		case step.slashPathname == "link":
+			err = th.AddSymlink(step.slashPathname, "a.go")
+		default:
+			err = th.AddFile(step.slashPathname, strings.NewReader(files[step.slashPathname]))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := th.Sum(); !bytes.Equal(got, want.Digest) {
+		t.Errorf("\n(GOT):\n\t%#v\n(WNT):\n\t%#v", got, want.Digest)
+	}
+}
+
+func TestTreeHasherSkipFile(t *testing.T) {
+	// A git worktree or submodule has a regular file named .git, after which
+	// DigestFromDirectory ignores the rest of its directory, but not the
+	// nodes following that directory.
+	files := map[string]string{
+		"a.go":       "package a\n",
+		"sub/.git":   "gitdir: ../../.git/modules/sub\n",
+		"sub/y/y.go": "package y\n",
+		"sub/z.go":   "package sub\n",
+		"z.go":       "package z\n",
+	}
+	dir := mkTestTree(t, files)
+	defer os.RemoveAll(dir)
+
+	steps := []struct {
+		slashPathname string
+		isDir         bool
+	}{
+		{"a.go", false},
+		{"sub", true},
+		{"sub/.git", false},
+		{"sub/y", true},
+		{"sub/y/y.go", false},
+		{"sub/z.go", false},
+		{"z.go", false},
+	}
+	for _, slashDirname := range []string{"", "sub"} {
+		want, err := DigestFromDirectory(filepath.Join(dir, slashDirname))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		th := NewTreeHasher()
+		for _, step := range steps {
+			if !isAncestorOrSelf(slashDirname, step.slashPathname) || step.slashPathname == slashDirname {
+				continue
+			}
+			slashRelative := strings.TrimPrefix(strings.TrimPrefix(step.slashPathname, slashDirname), "/")
+			var err error
+			if step.isDir {
+				err = th.AddDir(slashRelative)
+			} else {
+				err = th.AddFile(slashRelative, strings.NewReader(files[step.slashPathname]))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := th.Sum(); !bytes.Equal(got, want.Digest) {
+			t.Errorf("%q: \n(GOT):\n\t%#v\n(WNT):\n\t%#v", slashDirname, got, want.Digest)
+		}
+	}
+}
+
+func TestTreeHasherBailsOnInvalidOrder(t *testing.T) {
+	testCases := []struct {
+		name  string
+		setup func(*TreeHasher) error
+	}{
+		{"out of order", func(th *TreeHasher) error {
+			if err := th.AddFile("b.go", strings.NewReader("")); err != nil {
+				return err
+			}
+			return th.AddFile("a.go", strings.NewReader(""))
+		}},
+		{"directory after its sibling with a longer name", func(th *TreeHasher) error {
+			if err := th.AddFile("a.go", strings.NewReader("")); err != nil {
+				return err
+			}
+			return th.AddDir("a")
+		}},
+		{"duplicate", func(th *TreeHasher) error {
+			if err := th.AddDir("a"); err != nil {
+				return err
+			}
+			return th.AddDir("a")
+		}},
+		{"missing parent", func(th *TreeHasher) error {
+			return th.AddFile("a/b.go", strings.NewReader(""))
+		}},
+		{"beneath a file", func(th *TreeHasher) error {
+			if err := th.AddFile("a", strings.NewReader("")); err != nil {
+				return err
+			}
+			return th.AddFile("a/b.go", strings.NewReader(""))
+		}},
+		{"root", func(th *TreeHasher) error { return th.AddDir("") }},
+		{"absolute", func(th *TreeHasher) error { return th.AddDir("/a") }},
+		{"parent", func(th *TreeHasher) error { return th.AddDir("../a") }},
+		{"unclean", func(th *TreeHasher) error { return th.AddDir("a//b") }},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			th := NewTreeHasher()
+			err := testCase.setup(th)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if got := th.AddDir("z"); got != err {
+				t.Errorf("(GOT): %v; (WNT): %v", got, err)
+			}
+		})
+	}
+}
+
+func TestTreeHasherAddFileReadError(t *testing.T) {
+	failure := errors.New("truncated archive")
+	th := NewTreeHasher()
+	defer th.Close()
+	err := th.AddFile("a.go", &failingAfterReader{src: strings.NewReader("package a\n"), remaining: 4, err: failure})
+	de, ok := err.(*DigestError)
+	if !ok || de.Op != opCopy || de.Pathname != "a.go" || de.Offset != 4 || de.Err != failure {
+		t.Fatalf("(GOT): %#v; (WNT): copy error at offset 4", err)
+	}
+	if got := th.AddFile("b.go", strings.NewReader("")); got != err {
+		t.Errorf("(GOT): %v; (WNT): %v", got, err)
+	}
+}
+
+func TestTreeHasherClose(t *testing.T) {
+	th := NewTreeHasher()
+	if err := th.AddFile("a.go", strings.NewReader("package a\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := th.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if th.closure != nil {
+		t.Error("expected Close to release the closure")
+	}
+	if err := th.AddFile("b.go", strings.NewReader("")); err != errTreeHasherClosed {
+		t.Errorf("(GOT): %v; (WNT): %v", err, errTreeHasherClosed)
+	}
+	if got := th.Sum(); got != nil {
+		t.Errorf("(GOT): %x; (WNT): nil", got)
+	}
+	if err := th.Close(); err != nil {
+		t.Errorf("second Close: (GOT): %v; (WNT): nil", err)
+	}
+}
@@ -494,7 +494,7 @@ func NewDeltaWriter(p *Project, newLock *Lock, behavior VendorBehavior) (TreeWri
 				dw.changed[pr] = missingFromTree
 			case verify.NotInLock:
 				dw.changed[pr] = projectRemoved
-			case verify.DigestMismatchInLock:
+			case verify.DigestMismatchInLock, verify.SymlinkInTree, verify.EmptyTreeInLock:
 				dw.changed[pr] = hashMismatch
 			case verify.HashVersionMismatch:
 				dw.changed[pr] = hashVersionMismatch